@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/backfill"
+	"github.com/phaserunner03/logging/internal/budget"
+	"github.com/phaserunner03/logging/internal/logger"
+	"github.com/phaserunner03/logging/internal/sampling"
+)
+
+// runBackfill exports a range of past days per service and day, resuming the
+// plan in --state when one exists.
+func runBackfill(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	configFlags := configs.RegisterFlags(fs)
+	from := fs.String("from", "", "first day to backfill, YYYY-MM-DD (required unless resuming)")
+	to := fs.String("to", "", "last day to backfill, inclusive (default yesterday)")
+	statePath := fs.String("state", "backfill-state.json", "file recording the plan and the status of each unit")
+	workers := fs.Int("workers", 4, "service-days exported in parallel")
+	replace := fs.Bool("replace", false, "replace the rows already exported for each day instead of appending")
+	force := fs.Bool("force", false, "run even if the estimate exceeds the insert budget, lifting its limits")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(configFlags)
+	if err != nil {
+		return err
+	}
+	// Replaying history must not page anyone.
+	config.Alerts.Rules = nil
+
+	state, err := backfillState(*statePath, config.Services.Name, *from, *to)
+	if err != nil {
+		return err
+	}
+
+	exp, err := newExporter(config)
+	if err != nil {
+		return err
+	}
+	exp.replace = *replace
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if exp.budget.Limited() {
+		if err := estimateBackfill(ctx, config, state); err != nil {
+			if !*force {
+				return fmt.Errorf("%v (rerun with --force to backfill anyway)", err)
+			}
+			slog.WarnContext(ctx, "Backfilling over budget", "error", err)
+		}
+		if *force {
+			exp.budget = nil
+		}
+	}
+
+	counts := state.Counts()
+	slog.InfoContext(ctx, "Backfilling logs",
+		"from", state.From,
+		"to", state.To,
+		"services", state.Services,
+		"units", len(state.Units),
+		"done", counts[backfill.StatusDone],
+		"state", *statePath,
+		"replace", *replace,
+	)
+	progress := backfill.NewProgress(os.Stderr, len(state.Units), counts[backfill.StatusDone])
+	err = state.Run(ctx, *workers, exp.backfillUnit, progress)
+	progress.Finish()
+	if err != nil {
+		return fmt.Errorf("%v (rerun with --state %s to resume)", err, *statePath)
+	}
+	return nil
+}
+
+// backfillUnit exports one backfill unit. Retried units may have written
+// part of their rows already, so unless every unit is replaced they are
+// gap-filled: only entries whose insert_id is not exported yet are inserted.
+func (e *exporter) backfillUnit(ctx context.Context, service, start, end string, retry bool) (int, error) {
+	if !retry || e.replace {
+		return e.exportService(ctx, service, start, end)
+	}
+	fill := *e
+	fill.fillGaps = true
+	return fill.exportService(ctx, service, start, end)
+}
+
+// estimateBackfill estimates the rows and bytes the remaining units of state
+// insert, per service, and returns an error wrapping budget.ErrExceeded when
+// they would not fit the insert budget.
+func estimateBackfill(ctx context.Context, config *configs.Config, state *backfill.State) error {
+	// The span of the remaining days of each service, and how many there are.
+	type remaining struct {
+		first, last time.Time
+		days        int
+	}
+	spans := make(map[string]*remaining)
+	for _, u := range state.Units {
+		if u.Status == backfill.StatusDone {
+			continue
+		}
+		day, err := time.Parse(backfill.DayLayout, u.Day)
+		if err != nil {
+			return fmt.Errorf("invalid day %q in backfill state: %v", u.Day, err)
+		}
+		r, ok := spans[u.Service]
+		if !ok {
+			r = &remaining{first: day, last: day}
+			spans[u.Service] = r
+		}
+		r.first = minTime(r.first, day)
+		r.last = maxTime(r.last, day)
+		r.days++
+	}
+
+	b := budget.New(config)
+	sampler := sampling.New(config, nil)
+	for _, service := range state.Services {
+		r, ok := spans[service]
+		if !ok {
+			continue
+		}
+		end := r.last.Add(24*time.Hour - time.Second)
+		est, err := estimateExport(ctx, config, sampler, service, r.first, end, 1000)
+		if err != nil {
+			return fmt.Errorf("failed to estimate backfill of %s: %v", service, err)
+		}
+		// Scale to the remaining days when some in the span are done.
+		spanDays := int64(r.last.Sub(r.first)/(24*time.Hour)) + 1
+		u := budget.Usage{Rows: est.Rows * int64(r.days) / spanDays, Bytes: est.Bytes * int64(r.days) / spanDays}
+		slog.InfoContext(ctx, "Estimated backfill", logger.KeyService, service, "days", r.days, "rows", u.Rows, "bytes", u.Bytes)
+		if err := b.Reserve(service, u); err != nil {
+			return fmt.Errorf("backfill estimate: %v", err)
+		}
+	}
+	used := b.Used()
+	slog.InfoContext(ctx, "Backfill estimate within budget", "rows", used.Rows, "bytes", used.Bytes)
+	return nil
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// backfillState loads the plan saved at path, or plans and saves a new one.
+// from and to, when given for an existing plan, must match it.
+func backfillState(path string, services []string, from, to string) (*backfill.State, error) {
+	existing, err := backfill.Load(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if existing != nil && from == "" {
+		return existing, nil
+	}
+	if existing != nil && to == "" {
+		to = existing.To
+	}
+	if from == "" {
+		return nil, fmt.Errorf("--from is required to start a backfill (no state in %s)", path)
+	}
+
+	start, err := time.Parse(backfill.DayLayout, from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --from: %v", err)
+	}
+	end := time.Now().UTC().AddDate(0, 0, -1)
+	if to != "" {
+		if end, err = time.Parse(backfill.DayLayout, to); err != nil {
+			return nil, fmt.Errorf("invalid --to: %v", err)
+		}
+	}
+	state, err := backfill.Plan(path, services, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		if !existing.SamePlan(state) {
+			return nil, fmt.Errorf("%s holds a backfill of %v from %s to %s; use another --state or remove it", path, existing.Services, existing.From, existing.To)
+		}
+		return existing, nil
+	}
+	if err := state.Save(); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
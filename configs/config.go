@@ -1,45 +1,862 @@
 package configs
 
 import (
-	"io/ioutil"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/joho/godotenv"
+	"github.com/phaserunner03/logging/internal/filter"
 	"gopkg.in/yaml.v2"
 )
 
+// DefaultPath is the configuration file used when neither --config nor
+// LOGGING_CONFIG is set. It is optional: a missing file means defaults and
+// environment variables only.
+const DefaultPath = "./configs/services.yaml"
+
 type Config struct {
-	Services struct {
-		Name []string `yaml:"name"`
-	} `yaml:"service"`
+	Services  ServiceConfig   `yaml:"service"`
+	Timestamp TimestampConfig `yaml:"timestamp"`
+	Resource  ResourceConfig  `yaml:"resource"`
+	Source    SourceConfig    `yaml:"source"`
+	Log       LogConfig       `yaml:"log"`
+	GCP       GCPConfig       `yaml:"gcp"`
+	Auth      AuthConfig      `yaml:"auth"`
+	BigQuery  BigQueryConfig  `yaml:"bigquery"`
+	Routing   RoutingConfig   `yaml:"routing"`
+	Sampling  SamplingConfig  `yaml:"sampling"`
+	Rollup    RollupConfig    `yaml:"rollup"`
+	Trace     TraceConfig     `yaml:"trace"`
+	Alerts    AlertsConfig    `yaml:"alerts"`
+	Budget    BudgetConfig    `yaml:"budget"`
+	Retention RetentionConfig `yaml:"retention"`
+	Emulator  EmulatorConfig  `yaml:"emulator"`
+}
+
+type ServiceConfig struct {
+	Name []string `yaml:"name"`
+}
+
+// TimestampConfig is the default export window, as RFC 3339 timestamps.
+type TimestampConfig struct {
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+}
+
+type ResourceConfig struct {
+	Type []string `yaml:"type"`
+}
+
+// SourceConfig lists the Cloud Logging resources logs are read from. Each
+// entry is one of projects/P, folders/F, organizations/O, billingAccounts/B
+// or a log view projects/P/locations/L/buckets/B/views/V. When empty, the
+// project in gcp.project_id is used.
+//
+// Filter is a Logging query ANDed with the service and window filter. Input,
+// when set, reads entries from a local NDJSON or JSON array file of LogEntry
+// objects instead of the Logging API, applying the same filter.
+type SourceConfig struct {
+	ResourceNames []string `yaml:"resource_names"`
+	Filter        string   `yaml:"filter"`
+	Input         string   `yaml:"input"`
+}
+
+var resourceNamePattern = regexp.MustCompile(`^(projects|folders|organizations|billingAccounts)/[^/]+$|^projects/[^/]+/locations/[^/]+/buckets/[^/]+/views/[^/]+$`)
+
+type LogConfig struct {
+	Level string `yaml:"level"`
+}
+
+type GCPConfig struct {
+	ProjectID string `yaml:"project_id"`
+	// Credentials and ImpersonateServiceAccount apply to both the read and
+	// the write side unless overridden in AuthConfig.
+	Credentials               string   `yaml:"credentials"`
+	ImpersonateServiceAccount string   `yaml:"impersonate_service_account"`
+	Delegates                 []string `yaml:"delegates"`
+}
+
+// EmulatorConfig points the clients at local emulators or test fakes
+// (host:port) instead of Google APIs. Connections are unauthenticated and
+// unencrypted.
+type EmulatorConfig struct {
+	Logging  string `yaml:"logging"`
+	BigQuery string `yaml:"bigquery"`
+}
+
+// AuthConfig selects separate identities for reading logs (Cloud Logging)
+// and writing rows (BigQuery).
+type AuthConfig struct {
+	Read  IdentityConfig `yaml:"read"`
+	Write IdentityConfig `yaml:"write"`
+}
+
+// IdentityConfig describes how to authenticate. With no credentials file,
+// Application Default Credentials are used (metadata server, gcloud user
+// credentials, or a workload identity federation config in
+// GOOGLE_APPLICATION_CREDENTIALS). When ImpersonateServiceAccount is set the
+// base credentials are exchanged for a token of that service account.
+type IdentityConfig struct {
+	Credentials               string   `yaml:"credentials"`
+	ImpersonateServiceAccount string   `yaml:"impersonate_service_account"`
+	Delegates                 []string `yaml:"delegates"`
+}
+
+type BigQueryConfig struct {
+	DatasetID string `yaml:"dataset_id"`
+	TableID   string `yaml:"table_id"`
+	Schema    string `yaml:"schema"`
+	// Layout is "single" (every row goes to its routed table) or "per_log_id"
+	// (one table per log ID in the routed dataset, like Cloud Logging's own
+	// BigQuery sinks).
+	Layout string `yaml:"layout"`
+	// Partitioning applies to tables the exporter creates: "partitioned"
+	// (daily partitions on timestamp) or "sharded" (one table per day with a
+	// _YYYYMMDD suffix, per_log_id layout only).
+	Partitioning string `yaml:"partitioning"`
+	// AutoCreate creates missing destination tables from Schema. Always on
+	// for the per_log_id layout.
+	AutoCreate bool `yaml:"auto_create"`
+}
+
+// RoutingConfig sends rows to different tables. Rules are evaluated in order
+// and the first match wins; rows matching no rule go to Default, whose unset
+// fields fall back to gcp.project_id, bigquery.dataset_id and bigquery.table_id.
+type RoutingConfig struct {
+	Default DestinationConfig `yaml:"default"`
+	Rules   []RouteRule       `yaml:"rules"`
+}
+
+type RouteRule struct {
+	Name        string            `yaml:"name"`
+	Match       RouteMatch        `yaml:"match"`
+	Destination DestinationConfig `yaml:"destination"`
+}
+
+// RouteMatch holds patterns a row must all satisfy. Empty patterns match
+// anything. Patterns are globs (path.Match syntax) unless prefixed with "re:",
+// in which case the rest is a regular expression matched against the whole value.
+type RouteMatch struct {
+	Service  string `yaml:"service"`
+	Severity string `yaml:"severity"`
+	LogName  string `yaml:"log_name"`
+}
+
+type DestinationConfig struct {
+	ProjectID string `yaml:"project_id"`
+	DatasetID string `yaml:"dataset_id"`
+	TableID   string `yaml:"table_id"`
+}
+
+// SamplingConfig drops part of the rows between conversion and insertion.
+// Sampling is deterministic: a row is kept when the hash of its Key ("trace",
+// falling back to insert_id for untraced entries, or "insert_id") falls below
+// the rate for its severity, so all entries of a trace are kept or dropped
+// together. Services without their own policy use Default.
+type SamplingConfig struct {
+	Key      string                    `yaml:"key"`
+	Default  SamplingPolicy            `yaml:"default"`
+	Services map[string]SamplingPolicy `yaml:"services"`
+}
+
+type SamplingPolicy struct {
+	// Rates maps a severity (DEBUG, INFO, ...) to the fraction of rows kept;
+	// severities not listed are kept in full.
+	Rates map[string]float64 `yaml:"rates"`
+	// DailyCap is the maximum number of rows inserted per service and UTC
+	// day, counting the rows already in BigQuery; 0 means unlimited.
+	DailyCap int `yaml:"daily_cap"`
+}
+
+// SamplingPolicy returns the sampling policy of service.
+func (c *Config) SamplingPolicy(service string) SamplingPolicy {
+	if p, ok := c.Sampling.Services[service]; ok {
+		return p
+	}
+	return c.Sampling.Default
+}
+
+// RollupConfig enables aggregation of converted rows into per-service,
+// per-interval counts and latency percentiles written to a separate table.
+type RollupConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Interval is the bucket length, a Go duration such as "1m" or "5m".
+	Interval string `yaml:"interval"`
+	// Raw keeps exporting raw rows alongside the rollups.
+	Raw       bool   `yaml:"raw"`
+	ProjectID string `yaml:"project_id"`
+	DatasetID string `yaml:"dataset_id"`
+	TableID   string `yaml:"table_id"`
+	Schema    string `yaml:"schema"`
+}
+
+// RollupInterval returns the parsed rollup.interval.
+func (c *Config) RollupInterval() time.Duration {
+	d, _ := time.ParseDuration(c.Rollup.Interval)
+	return d
+}
+
+// TraceConfig controls enrichment of rows with span details from Cloud Trace.
+type TraceConfig struct {
+	// Enrich looks up the span of every sampled, traced row and records its
+	// name and duration.
+	Enrich bool `yaml:"enrich"`
+	// MaxTraces bounds the traces fetched per service export; 0 means unlimited.
+	MaxTraces int `yaml:"max_traces"`
+}
+
+// AlertsConfig defines rules evaluated on converted rows during export and
+// the notifiers alerts are delivered to.
+type AlertsConfig struct {
+	// Cooldown is the minimum time between two alerts of the same rule and
+	// service (and fingerprint, for new_fingerprint rules).
+	Cooldown string `yaml:"cooldown"`
+	// StateFile persists seen fingerprints and cooldowns across runs; without
+	// it they are kept in memory only.
+	StateFile string           `yaml:"state_file"`
+	Rules     []AlertRule      `yaml:"rules"`
+	Notifiers []NotifierConfig `yaml:"notifiers"`
+}
+
+// AlertRule is one alert condition. Types are:
+//   - error_rate: the fraction of ERROR or worse rows exceeds Threshold in a
+//     Window, once at least MinRows rows were seen in it;
+//   - pattern: Pattern (a regular expression) matches the payload of at
+//     least Count rows in a Window;
+//   - new_fingerprint: a stack trace with an error_group_id not seen before.
+type AlertRule struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	// Service is a pattern as in routing rules; empty matches every service.
+	Service   string  `yaml:"service"`
+	Window    string  `yaml:"window"`
+	Threshold float64 `yaml:"threshold"`
+	MinRows   int     `yaml:"min_rows"`
+	Pattern   string  `yaml:"pattern"`
+	Count     int     `yaml:"count"`
+	// Cooldown overrides alerts.cooldown for this rule.
+	Cooldown string `yaml:"cooldown"`
+}
+
+// NotifierConfig is an alert output: "webhook" (alert posted as JSON to URL),
+// "slack" (Slack-compatible incoming webhook at URL), "stdout" (alerts written
+// as JSON lines to standard output) or "file" (alerts appended as JSON lines
+// to Path).
+type NotifierConfig struct {
+	Type string `yaml:"type"`
+	URL  string `yaml:"url"`
+	Path string `yaml:"path"`
+}
+
+// RuleWindow returns the parsed window of r, defaulting to 5 minutes.
+func (r AlertRule) RuleWindow() time.Duration {
+	if r.Window == "" {
+		return 5 * time.Minute
+	}
+	d, _ := time.ParseDuration(r.Window)
+	return d
+}
 
-	Env struct {
-		GCP_Credentials   string
-		GCP_ProjectID     string
-		BigQueryDatasetID string
-		BigQueryTableID   string
+// RuleCooldown returns the cooldown of r, falling back to alerts.cooldown.
+func (c *Config) RuleCooldown(r AlertRule) time.Duration {
+	s := r.Cooldown
+	if s == "" {
+		s = c.Alerts.Cooldown
 	}
+	d, _ := time.ParseDuration(s)
+	return d
+}
+
+// RetentionConfig sets how many days exported rows are kept, per service.
+// Services without their own entry in Services use Days; 0 keeps rows
+// forever. The retention command enforces it.
+type RetentionConfig struct {
+	Days     int            `yaml:"days"`
+	Services map[string]int `yaml:"services"`
 }
 
-func LoadConfig() (*Config, error) {
-	filePath := "./configs/services.yaml"
-	data, err := ioutil.ReadFile(filePath)
+// RetentionDays returns the days rows of service are kept, or 0 for forever.
+func (c *Config) RetentionDays(service string) int {
+	if days, ok := c.Retention.Services[service]; ok {
+		return days
+	}
+	return c.Retention.Days
+}
+
+// BudgetConfig caps what the tool spends in BigQuery. Sizes are byte counts
+// with an optional unit, such as "500MB" or "2GiB"; zero or empty means
+// unlimited.
+type BudgetConfig struct {
+	// MaxBytesBilled caps every query the tool runs. BigQuery fails a query
+	// that would bill more, without charging for it.
+	MaxBytesBilled string `yaml:"max_bytes_billed"`
+	// The per-run limits bound the rows inserted by one export, backfill or
+	// control plane job across services, the per-service limits those of each
+	// service. Inserts that would exceed a limit fail.
+	MaxRowsPerRun      int64  `yaml:"max_rows_per_run"`
+	MaxBytesPerRun     string `yaml:"max_bytes_per_run"`
+	MaxRowsPerService  int64  `yaml:"max_rows_per_service"`
+	MaxBytesPerService string `yaml:"max_bytes_per_service"`
+}
+
+var byteUnits = map[string]int64{
+	"": 1, "B": 1,
+	"KB": 1e3, "MB": 1e6, "GB": 1e9, "TB": 1e12, "PB": 1e15,
+	"KIB": 1 << 10, "MIB": 1 << 20, "GIB": 1 << 30, "TIB": 1 << 40, "PIB": 1 << 50,
+}
+
+var bytesPattern = regexp.MustCompile(`^\s*([0-9]+(?:\.[0-9]+)?)\s*([A-Za-z]*)\s*$`)
+
+// ParseBytes parses a size such as "1024", "500MB" or "2GiB". The empty
+// string is zero.
+func ParseBytes(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	m := bytesPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	unit, ok := byteUnits[strings.ToUpper(m[2])]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, m[2])
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
 	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// MaxBytesBilled returns the parsed budget.max_bytes_billed.
+func (c *Config) MaxBytesBilled() int64 {
+	n, _ := ParseBytes(c.Budget.MaxBytesBilled)
+	return n
+}
+
+func defaults() *Config {
+	var c Config
+	c.Resource.Type = []string{"cloud_run_revision"}
+	c.Log.Level = "info"
+	c.BigQuery.Schema = "./schema.json"
+	c.Sampling.Key = "trace"
+	c.Rollup.Interval = "1m"
+	c.Rollup.Raw = true
+	c.Rollup.Schema = "./rollup_schema.json"
+	c.Trace.MaxTraces = 100
+	c.Alerts.Cooldown = "15m"
+	c.BigQuery.Layout = "single"
+	c.BigQuery.Partitioning = "partitioned"
+	return &c
+}
+
+// setting is a scalar configuration value that can be overridden by an
+// environment variable and a command-line flag.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	field func(*Config) *string
+}
+
+var settings = []setting{
+	{"GCP_PROJECT_ID", "project", "GCP project ID", func(c *Config) *string { return &c.GCP.ProjectID }},
+	{"GCP_CREDENTIALS", "credentials", "path to a credentials file (default: Application Default Credentials)", func(c *Config) *string { return &c.GCP.Credentials }},
+	{"GCP_IMPERSONATE_SERVICE_ACCOUNT", "impersonate-service-account", "service account to impersonate", func(c *Config) *string { return &c.GCP.ImpersonateServiceAccount }},
+	{"GCP_READ_CREDENTIALS", "read-credentials", "credentials file for reading logs", func(c *Config) *string { return &c.Auth.Read.Credentials }},
+	{"GCP_READ_IMPERSONATE_SERVICE_ACCOUNT", "read-impersonate-service-account", "service account to impersonate for reading logs", func(c *Config) *string { return &c.Auth.Read.ImpersonateServiceAccount }},
+	{"GCP_WRITE_CREDENTIALS", "write-credentials", "credentials file for writing to BigQuery", func(c *Config) *string { return &c.Auth.Write.Credentials }},
+	{"GCP_WRITE_IMPERSONATE_SERVICE_ACCOUNT", "write-impersonate-service-account", "service account to impersonate for writing to BigQuery", func(c *Config) *string { return &c.Auth.Write.ImpersonateServiceAccount }},
+	{"BIGQUERY_DATASET_ID", "dataset", "destination BigQuery dataset", func(c *Config) *string { return &c.BigQuery.DatasetID }},
+	{"BIGQUERY_TABLE_ID", "table", "destination BigQuery table", func(c *Config) *string { return &c.BigQuery.TableID }},
+	{"BIGQUERY_LAYOUT", "layout", "table layout: single or per_log_id", func(c *Config) *string { return &c.BigQuery.Layout }},
+	{"BIGQUERY_PARTITIONING", "partitioning", "partitioning of created tables: partitioned or sharded", func(c *Config) *string { return &c.BigQuery.Partitioning }},
+	{"BIGQUERY_SCHEMA", "schema", "path to the BigQuery table schema", func(c *Config) *string { return &c.BigQuery.Schema }},
+	{"BIGQUERY_MAX_BYTES_BILLED", "max-bytes-billed", "maximum bytes billed per query, e.g. 10GB (default unlimited)", func(c *Config) *string { return &c.Budget.MaxBytesBilled }},
+	{"LOGGING_START", "start", "start of the export window (RFC 3339)", func(c *Config) *string { return &c.Timestamp.Start }},
+	{"LOGGING_END", "end", "end of the export window (RFC 3339)", func(c *Config) *string { return &c.Timestamp.End }},
+	{"LOGGING_FILTER", "filter", "Logging query ANDed with the service and window filter", func(c *Config) *string { return &c.Source.Filter }},
+	{"LOGGING_INPUT", "input", "read entries from an NDJSON or JSON file instead of Cloud Logging", func(c *Config) *string { return &c.Source.Input }},
+	{"LOGGING_EMULATOR_HOST", "logging-emulator-host", "host:port of a Cloud Logging emulator (no authentication)", func(c *Config) *string { return &c.Emulator.Logging }},
+	{"BIGQUERY_EMULATOR_HOST", "bigquery-emulator-host", "host:port of a BigQuery emulator (no authentication)", func(c *Config) *string { return &c.Emulator.BigQuery }},
+	{"LOG_LEVEL", "log-level", "log level: debug, info, warning, error or critical", func(c *Config) *string { return &c.Log.Level }},
+}
+
+// listSetting is a list configuration value overridden by a comma-separated
+// environment variable or flag.
+type listSetting struct {
+	env   string
+	flag  string
+	usage string
+	field func(*Config) *[]string
+}
+
+var listSettings = []listSetting{
+	{"LOGGING_SERVICES", "services", "comma-separated services to export", func(c *Config) *[]string { return &c.Services.Name }},
+	{"LOGGING_RESOURCE_NAMES", "resource-names", "comma-separated Cloud Logging resources to read from", func(c *Config) *[]string { return &c.Source.ResourceNames }},
+}
+
+// Flags holds the configuration flags registered on a command's FlagSet.
+type Flags struct {
+	path   string
+	values map[string]*string
+}
+
+// RegisterFlags adds --config and one flag per overridable setting to fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{values: make(map[string]*string)}
+	fs.StringVar(&f.path, "config", "", "path to the YAML configuration (default $LOGGING_CONFIG or "+DefaultPath+")")
+	for _, s := range settings {
+		f.values[s.flag] = fs.String(s.flag, "", s.usage+" (env "+s.env+")")
+	}
+	for _, s := range listSettings {
+		f.values[s.flag] = fs.String(s.flag, "", s.usage+" (env "+s.env+")")
+	}
+	return f
+}
+
+// Load builds the configuration from, in increasing order of precedence:
+// built-in defaults, the YAML file, environment variables (including an
+// optional .env file) and flags. flags may be nil.
+func Load(flags *Flags) (*Config, error) {
+	if flags == nil {
+		flags = &Flags{}
+	}
+
+	// .env is optional; variables already set in the environment win.
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load .env: %v", err)
+	}
+
+	config := defaults()
+
+	path, explicit := flags.path, true
+	if path == "" {
+		path = os.Getenv("LOGGING_CONFIG")
+	}
+	if path == "" {
+		path, explicit = DefaultPath, false
+	}
+	if err := loadFile(config, path, explicit); err != nil {
 		return nil, err
 	}
 
-	var config Config
-	err = yaml.Unmarshal(data, &config)
-	if err != nil {
+	for _, s := range settings {
+		if v := os.Getenv(s.env); v != "" {
+			*s.field(config) = v
+		}
+		if v := flags.values[s.flag]; v != nil && *v != "" {
+			*s.field(config) = *v
+		}
+	}
+	for _, s := range listSettings {
+		if v := os.Getenv(s.env); v != "" {
+			*s.field(config) = splitList(v)
+		}
+		if v := flags.values[s.flag]; v != nil && *v != "" {
+			*s.field(config) = splitList(*v)
+		}
+	}
+
+	if err := config.Validate(); err != nil {
 		return nil, err
 	}
-	err = godotenv.Load()
+	return config, nil
+}
+
+func loadFile(config *Config, path string, explicit bool) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil
+	}
 	if err != nil {
+		return fmt.Errorf("failed to read config: %v", err)
+	}
+
+	data, err = interpolate(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return fmt.Errorf("%s: %v", path, describeYAMLError(err))
+	}
+	return nil
+}
+
+var varPattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// placeholder stands in for the i-th variable while the document is parsed.
+var placeholder = regexp.MustCompile(`__interpolated_(\d+)__`)
+
+// interpolate replaces ${VAR} and ${VAR:-default} with environment values.
+// "$$" produces a literal "$". A variable that is unset and has no default is an error.
+// Variables are replaced in the scalars of the parsed document, so values
+// cannot change its structure; the document is then written out again.
+func interpolate(data []byte) ([]byte, error) {
+	var vars [][]byte
+	data = varPattern.ReplaceAllFunc(data, func(m []byte) []byte {
+		if string(m) == "$$" {
+			return []byte("$")
+		}
+		vars = append(vars, m)
+		return fmt.Appendf(nil, "__interpolated_%d__", len(vars)-1)
+	})
+	if len(vars) == 0 {
+		return data, nil
+	}
+
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
 		return nil, err
 	}
-	config.Env.GCP_Credentials = os.Getenv("GCP_CREDENTIALS")
-	config.Env.GCP_ProjectID = os.Getenv("GCP_PROJECT_ID")
-	config.Env.BigQueryDatasetID = os.Getenv("BIGQUERY_DATASET_ID")
-	config.Env.BigQueryTableID = os.Getenv("BIGQUERY_TABLE_ID")
+	var missing []string
+	lookup := func(i string) string {
+		n, _ := strconv.Atoi(i)
+		sub := varPattern.FindSubmatch(vars[n])
+		if v, ok := os.LookupEnv(string(sub[1])); ok {
+			return v
+		}
+		if sub[2] == nil {
+			missing = append(missing, string(sub[1]))
+		}
+		return string(sub[3])
+	}
+	expand := func(s string) any {
+		if m := placeholder.FindStringSubmatch(s); m != nil && m[0] == s {
+			return plainScalar(lookup(m[1]))
+		}
+		return placeholder.ReplaceAllStringFunc(s, func(m string) string {
+			return lookup(placeholder.FindStringSubmatch(m)[1])
+		})
+	}
+	expandScalars(doc, expand)
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("undefined environment variables: %s", strings.Join(missing, ", "))
+	}
+	return yaml.Marshal(doc)
+}
+
+// expandScalars replaces every string key and value in v with expand of it.
+func expandScalars(v any, expand func(string) any) any {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		for i := range v {
+			v[i].Key = expandScalars(v[i].Key, expand)
+			v[i].Value = expandScalars(v[i].Value, expand)
+		}
+	case []any:
+		for i := range v {
+			v[i] = expandScalars(v[i], expand)
+		}
+	case string:
+		return expand(v)
+	}
+	return v
+}
+
+// plainScalar returns the number or boolean s reads as when written unquoted,
+// so variables can set numeric and boolean keys, and s itself otherwise.
+func plainScalar(s string) any {
+	var v any
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		return s
+	}
+	switch v.(type) {
+	case int, int64, uint64, float64, bool:
+		// Only when writing v back keeps s, so "1.10" stays a string.
+		if out, err := yaml.Marshal(v); err == nil && string(out) == s+"\n" {
+			return v
+		}
+	}
+	return s
+}
+
+var unknownField = regexp.MustCompile(`field (\S+) not found in type configs\.(\w+)`)
+
+// describeYAMLError rewrites strict decoding errors into "unknown key" messages.
+func describeYAMLError(err error) error {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return err
+	}
+	msgs := make([]string, len(typeErr.Errors))
+	for i, msg := range typeErr.Errors {
+		msgs[i] = unknownField.ReplaceAllStringFunc(msg, func(m string) string {
+			sub := unknownField.FindStringSubmatch(m)
+			section := strings.ToLower(strings.TrimSuffix(sub[2], "Config"))
+			if section == "" {
+				section = "the top level"
+			}
+			return fmt.Sprintf("unknown key %q in %s", sub[1], section)
+		})
+	}
+	return fmt.Errorf("invalid configuration:\n  %s", strings.Join(msgs, "\n  "))
+}
+
+// Validate reports every missing or malformed setting at once.
+func (c *Config) Validate() error {
+	var problems []string
+	require := func(key, env, flag, value string) {
+		if value == "" {
+			problems = append(problems, fmt.Sprintf("%s is required (set it in the config file, $%s or --%s)", key, env, flag))
+		}
+	}
+
+	if len(c.Services.Name) == 0 {
+		problems = append(problems, "service.name must list at least one service (or set $LOGGING_SERVICES or --services)")
+	}
+	for _, name := range c.Source.ResourceNames {
+		if !resourceNamePattern.MatchString(name) {
+			problems = append(problems, fmt.Sprintf("source.resource_names: %q is not a projects/, folders/, organizations/, billingAccounts/ or log view resource name", name))
+		}
+	}
+	if err := filter.Validate(c.Source.Filter); err != nil {
+		problems = append(problems, fmt.Sprintf("source.filter: %v", err))
+	}
+	require("gcp.project_id", "GCP_PROJECT_ID", "project", c.GCP.ProjectID)
+	require("bigquery.dataset_id", "BIGQUERY_DATASET_ID", "dataset", c.BigQuery.DatasetID)
+	require("bigquery.table_id", "BIGQUERY_TABLE_ID", "table", c.BigQuery.TableID)
+	switch c.BigQuery.Layout {
+	case "single", "per_log_id":
+	default:
+		problems = append(problems, fmt.Sprintf("bigquery.layout must be single or per_log_id, got %q", c.BigQuery.Layout))
+	}
+	switch c.BigQuery.Partitioning {
+	case "partitioned":
+	case "sharded":
+		if c.BigQuery.Layout != "per_log_id" {
+			problems = append(problems, "bigquery.partitioning sharded requires bigquery.layout per_log_id")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("bigquery.partitioning must be partitioned or sharded, got %q", c.BigQuery.Partitioning))
+	}
+	if c.Sampling.Key != "trace" && c.Sampling.Key != "insert_id" {
+		problems = append(problems, fmt.Sprintf("sampling.key must be trace or insert_id, got %q", c.Sampling.Key))
+	}
+	policies := map[string]SamplingPolicy{"sampling.default": c.Sampling.Default}
+	for service, p := range c.Sampling.Services {
+		policies["sampling.services."+service] = p
+	}
+	for _, key := range sortedKeys(policies) {
+		p := policies[key]
+		for _, severity := range sortedKeys(p.Rates) {
+			if rate := p.Rates[severity]; rate < 0 || rate > 1 {
+				problems = append(problems, fmt.Sprintf("%s.rates.%s must be between 0 and 1, got %v", key, severity, rate))
+			}
+		}
+		if p.DailyCap < 0 {
+			problems = append(problems, fmt.Sprintf("%s.daily_cap must not be negative", key))
+		}
+	}
+	if d, err := time.ParseDuration(c.Rollup.Interval); err != nil || d < time.Second {
+		problems = append(problems, fmt.Sprintf("rollup.interval must be a duration of at least 1s, got %q", c.Rollup.Interval))
+	}
+	if c.Trace.MaxTraces < 0 {
+		problems = append(problems, "trace.max_traces must not be negative")
+	}
+	if !c.Rollup.Enabled && !c.Rollup.Raw {
+		problems = append(problems, "rollup.raw is false but rollup.enabled is not set: nothing would be exported")
+	}
+	for i, rule := range c.Routing.Rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		for _, m := range [][2]string{{"service", rule.Match.Service}, {"severity", rule.Match.Severity}, {"log_name", rule.Match.LogName}} {
+			if err := checkPattern(m[1]); err != nil {
+				problems = append(problems, fmt.Sprintf("routing rule %s: match.%s: %v", name, m[0], err))
+			}
+		}
+		if rule.Destination == (DestinationConfig{}) {
+			problems = append(problems, fmt.Sprintf("routing rule %s: destination needs a project_id, dataset_id or table_id", name))
+		}
+	}
+	problems = append(problems, c.Alerts.validate()...)
+	problems = append(problems, c.Budget.validate()...)
+	if c.Retention.Days < 0 {
+		problems = append(problems, "retention.days must not be negative")
+	}
+	for _, service := range sortedKeys(c.Retention.Services) {
+		if c.Retention.Services[service] < 0 {
+			problems = append(problems, fmt.Sprintf("retention.services.%s must not be negative", service))
+		}
+	}
+	if len(c.Resource.Type) == 0 {
+		problems = append(problems, "resource.type must list at least one monitored resource type")
+	}
+
+	var start, end time.Time
+	var err error
+	if c.Timestamp.Start != "" {
+		if start, err = time.Parse(time.RFC3339, c.Timestamp.Start); err != nil {
+			problems = append(problems, fmt.Sprintf("timestamp.start %q is not an RFC 3339 timestamp", c.Timestamp.Start))
+		}
+	}
+	if c.Timestamp.End != "" {
+		if end, err = time.Parse(time.RFC3339, c.Timestamp.End); err != nil {
+			problems = append(problems, fmt.Sprintf("timestamp.end %q is not an RFC 3339 timestamp", c.Timestamp.End))
+		}
+	}
+	if !start.IsZero() && !end.IsZero() && end.Before(start) {
+		problems = append(problems, "timestamp.end must not be before timestamp.start")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// ReadIdentity returns the identity used for Cloud Logging, falling back to the gcp section.
+func (c *Config) ReadIdentity() IdentityConfig {
+	return c.GCP.identity(c.Auth.Read)
+}
+
+// WriteIdentity returns the identity used for BigQuery, falling back to the gcp section.
+func (c *Config) WriteIdentity() IdentityConfig {
+	return c.GCP.identity(c.Auth.Write)
+}
+
+func (g GCPConfig) identity(side IdentityConfig) IdentityConfig {
+	if side.Credentials == "" {
+		side.Credentials = g.Credentials
+	}
+	if side.ImpersonateServiceAccount == "" {
+		side.ImpersonateServiceAccount = g.ImpersonateServiceAccount
+		if len(side.Delegates) == 0 {
+			side.Delegates = g.Delegates
+		}
+	}
+	return side
+}
+
+// Window returns the configured export window, failing if either end is unset.
+func (c *Config) Window() (string, string, error) {
+	if c.Timestamp.Start == "" || c.Timestamp.End == "" {
+		return "", "", fmt.Errorf("an export window is required: set timestamp.start and timestamp.end, $LOGGING_START and $LOGGING_END, or --start and --end")
+	}
+	return c.Timestamp.Start, c.Timestamp.End, nil
+}
+
+// checkPattern validates a routing pattern: a glob, or a regular expression prefixed with "re:".
+func checkPattern(pattern string) error {
+	if re, ok := strings.CutPrefix(pattern, "re:"); ok {
+		_, err := regexp.Compile(re)
+		return err
+	}
+	_, err := path.Match(pattern, "")
+	return err
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
 
-	return &config, nil
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func (b BudgetConfig) validate() []string {
+	var problems []string
+	for _, size := range [][2]string{{"max_bytes_billed", b.MaxBytesBilled}, {"max_bytes_per_run", b.MaxBytesPerRun}, {"max_bytes_per_service", b.MaxBytesPerService}} {
+		if _, err := ParseBytes(size[1]); err != nil {
+			problems = append(problems, fmt.Sprintf("budget.%s: %v", size[0], err))
+		}
+	}
+	if b.MaxRowsPerRun < 0 || b.MaxRowsPerService < 0 {
+		problems = append(problems, "budget row limits must not be negative")
+	}
+	return problems
+}
+
+func (a AlertsConfig) validate() []string {
+	var problems []string
+	if d, err := time.ParseDuration(a.Cooldown); err != nil || d < 0 {
+		problems = append(problems, fmt.Sprintf("alerts.cooldown must be a duration, got %q", a.Cooldown))
+	}
+	names := make(map[string]bool)
+	for i, rule := range a.Rules {
+		name := rule.Name
+		if name == "" {
+			problems = append(problems, fmt.Sprintf("alert rule #%d: name is required", i+1))
+			name = fmt.Sprintf("#%d", i+1)
+		} else if names[name] {
+			problems = append(problems, fmt.Sprintf("alert rule %s: duplicate name", name))
+		}
+		names[name] = true
+
+		if err := checkPattern(rule.Service); err != nil {
+			problems = append(problems, fmt.Sprintf("alert rule %s: service: %v", name, err))
+		}
+		if rule.Window != "" {
+			if d, err := time.ParseDuration(rule.Window); err != nil || d < time.Second {
+				problems = append(problems, fmt.Sprintf("alert rule %s: window must be a duration of at least 1s, got %q", name, rule.Window))
+			}
+		}
+		if rule.Cooldown != "" {
+			if d, err := time.ParseDuration(rule.Cooldown); err != nil || d < 0 {
+				problems = append(problems, fmt.Sprintf("alert rule %s: cooldown must be a duration, got %q", name, rule.Cooldown))
+			}
+		}
+		switch rule.Type {
+		case "error_rate":
+			if rule.Threshold <= 0 || rule.Threshold > 1 {
+				problems = append(problems, fmt.Sprintf("alert rule %s: threshold must be a fraction in (0, 1], got %v", name, rule.Threshold))
+			}
+			if rule.MinRows < 0 {
+				problems = append(problems, fmt.Sprintf("alert rule %s: min_rows must not be negative", name))
+			}
+		case "pattern":
+			if rule.Pattern == "" {
+				problems = append(problems, fmt.Sprintf("alert rule %s: pattern is required", name))
+			} else if _, err := regexp.Compile(rule.Pattern); err != nil {
+				problems = append(problems, fmt.Sprintf("alert rule %s: invalid pattern: %v", name, err))
+			}
+			if rule.Count < 1 {
+				problems = append(problems, fmt.Sprintf("alert rule %s: count must be at least 1", name))
+			}
+		case "new_fingerprint":
+		default:
+			problems = append(problems, fmt.Sprintf("alert rule %s: type must be error_rate, pattern or new_fingerprint, got %q", name, rule.Type))
+		}
+	}
+	for i, n := range a.Notifiers {
+		switch n.Type {
+		case "webhook", "slack":
+			if n.URL == "" {
+				problems = append(problems, fmt.Sprintf("alerts.notifiers[%d]: %s notifier needs a url", i, n.Type))
+			}
+		case "file":
+			if n.Path == "" {
+				problems = append(problems, fmt.Sprintf("alerts.notifiers[%d]: file notifier needs a path", i))
+			}
+		case "stdout":
+		default:
+			problems = append(problems, fmt.Sprintf("alerts.notifiers[%d]: type must be webhook, slack, stdout or file, got %q", i, n.Type))
+		}
+	}
+	if len(a.Rules) > 0 && len(a.Notifiers) == 0 {
+		problems = append(problems, "alerts.rules are set but alerts.notifiers is empty")
+	}
+	return problems
 }
@@ -0,0 +1,152 @@
+package configs
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// required holds the settings Validate needs besides the one under test.
+const required = `
+service:
+  name: [api]
+gcp:
+  project_id: p
+bigquery:
+  dataset_id: logs
+  table_id: entries
+`
+
+// load writes config to a file and loads it with args on the command line.
+func load(t *testing.T, config string, args ...string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := RegisterFlags(fs)
+	if err := fs.Parse(append([]string{"--config", path}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	return Load(flags)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name, file, env, flag string
+		want                  string
+	}{
+		{"default", "", "", "", "info"},
+		{"file", "warning", "", "", "warning"},
+		{"env over file", "warning", "error", "", "error"},
+		{"flag over env", "warning", "error", "debug", "debug"},
+		{"flag over default", "", "", "debug", "debug"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LOG_LEVEL", tt.env)
+			config := required
+			if tt.file != "" {
+				config += "log:\n  level: " + tt.file + "\n"
+			}
+			var args []string
+			if tt.flag != "" {
+				args = []string{"--log-level", tt.flag}
+			}
+			c, err := load(t, config, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Log.Level != tt.want {
+				t.Errorf("log level = %q, want %q", c.Log.Level, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadListSettings(t *testing.T) {
+	t.Setenv("LOGGING_SERVICES", "web, worker")
+	c, err := load(t, required)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"web", "worker"}; !reflect.DeepEqual(c.Services.Name, want) {
+		t.Errorf("services = %q, want %q", c.Services.Name, want)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("NAME", "api")
+	t.Setenv("EMPTY", "")
+	t.Setenv("DAYS", "30")
+	t.Setenv("VERSION", "1.10")
+	t.Setenv("TRICKY", "a: b # c\n- 'd")
+
+	tests := []struct {
+		name, in string
+		want     any
+	}{
+		{"set", "v: ${NAME}", "api"},
+		{"default", "v: ${UNSET_NAME:-web}", "web"},
+		{"empty default", "v: ${UNSET_NAME:-}", ""},
+		{"set but empty", "v: ${EMPTY:-web}", ""},
+		{"inside a value", "v: svc-${NAME}-${UNSET_NAME:-x}", "svc-api-x"},
+		{"inside quotes", `v: "${NAME} ok"`, "api ok"},
+		{"dollar", "v: $${NAME} costs $$5", "${NAME} costs $5"},
+		{"number", "v: ${DAYS}", 30},
+		{"number-like string", "v: ${VERSION}", "1.10"},
+		{"yaml syntax in value", "v: ${TRICKY}", "a: b # c\n- 'd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := interpolate([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]any
+			if err := yaml.Unmarshal(out, &got); err != nil {
+				t.Fatalf("interpolated document %q: %v", out, err)
+			}
+			if len(got) != 1 || got["v"] != tt.want {
+				t.Errorf("got %#v, want v: %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterpolateMissing(t *testing.T) {
+	_, err := interpolate([]byte("a: ${MISSING_ONE}\nb:\n  - x${MISSING_TWO}\n# ${IN_A_COMMENT}\n"))
+	if err == nil || err.Error() != "undefined environment variables: MISSING_ONE, MISSING_TWO" {
+		t.Errorf("error = %v, want MISSING_ONE and MISSING_TWO undefined", err)
+	}
+}
+
+func TestLoadInterpolatesTypedKeys(t *testing.T) {
+	t.Setenv("DAYS", "30")
+	t.Setenv("FILTER", `severity>=WARNING AND jsonPayload.msg:"a: b"`)
+	c, err := load(t, required+"retention:\n  days: ${DAYS}\nsource:\n  filter: ${FILTER}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Retention.Days != 30 || c.Source.Filter != os.Getenv("FILTER") {
+		t.Errorf("retention.days = %d, source.filter = %q", c.Retention.Days, c.Source.Filter)
+	}
+}
+
+func TestLoadUnknownKeys(t *testing.T) {
+	_, err := load(t, required+"servces: [web]\nrollup:\n  enabld: true\n")
+	if err == nil {
+		t.Fatal("Load succeeded with unknown keys")
+	}
+	for _, want := range []string{"invalid configuration:", `unknown key "servces" in the top level`, `unknown key "enabld" in rollup`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+}
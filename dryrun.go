@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/bigquery"
+	"github.com/phaserunner03/logging/internal/logs"
+	"github.com/phaserunner03/logging/internal/sampling"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+)
+
+// dryRunOptions controls what dryRun samples and prints.
+type dryRunOptions struct {
+	SampleSize int // entries fetched per service
+	ShowRows   int // converted rows printed per service
+}
+
+// dryRun explains what an export of services over the window would do
+// without writing to BigQuery, printing a report to w.
+func dryRun(ctx context.Context, w io.Writer, config *configs.Config, services []string, startDate, endDate string, opts dryRunOptions) error {
+	schema, err := bigquery.LoadSchema(config.BigQuery.Schema)
+	if err != nil {
+		return err
+	}
+
+	start, err := time.Parse(time.RFC3339, startDate)
+	if err != nil {
+		return fmt.Errorf("invalid start date: %v", err)
+	}
+	end, err := time.Parse(time.RFC3339, endDate)
+	if err != nil {
+		return fmt.Errorf("invalid end date: %v", err)
+	}
+
+	fmt.Fprintf(w, "Dry run: %s to %s (no data will be written)\n", startDate, endDate)
+	if config.Source.Input != "" {
+		fmt.Fprintf(w, "Input: %s\n\n", config.Source.Input)
+	} else {
+		fmt.Fprintf(w, "Resource names: %v\n\n", logs.ResourceNames(config))
+	}
+
+	sampler := sampling.New(config, nil)
+	var totalRows, totalBytes int64
+	var invalid int
+	for _, service := range services {
+		fmt.Fprintf(w, "Service %s\n", service)
+		fmt.Fprintf(w, "  Filter: %s\n", logs.BuildFilter(config, service, startDate, endDate))
+
+		est, err := estimateExport(ctx, config, sampler, service, start, end, opts.SampleSize)
+		if err != nil {
+			return err
+		}
+		if est.More {
+			fmt.Fprintf(w, "  Sampled entries: %d (more available)\n", est.Entries)
+		} else {
+			fmt.Fprintf(w, "  Matching entries: %d\n", est.Entries)
+		}
+		fmt.Fprintf(w, "  Converted rows: %d (%d conversion errors)\n", est.Converted, est.ConversionErrors)
+		if est.Dropped.Total() > 0 {
+			fmt.Fprintf(w, "  Sampling drops %d of %d rows (%d sampled, %d over daily cap)\n", est.Dropped.Total(), est.Converted, est.Dropped.Sampled, est.Dropped.Capped)
+		}
+
+		for i, row := range est.Sample {
+			for _, err := range bigquery.ValidateRow(schema, row) {
+				invalid++
+				fmt.Fprintf(w, "  Schema error in row %d (insert_id %s): %v\n", i, row.InsertID, err)
+			}
+			if i < opts.ShowRows {
+				data, err := json.MarshalIndent(row, "    ", "  ")
+				if err != nil {
+					return fmt.Errorf("failed to print row: %v", err)
+				}
+				fmt.Fprintf(w, "  Row %d:\n    %s\n", i, data)
+			}
+		}
+
+		fmt.Fprintf(w, "  Estimated rows: %d, estimated bytes: %d\n\n", est.Rows, est.Bytes)
+		totalRows += est.Rows
+		totalBytes += est.Bytes
+	}
+
+	fmt.Fprintf(w, "Total estimated rows: %d, estimated bytes: %d\n", totalRows, totalBytes)
+	if invalid > 0 {
+		return fmt.Errorf("%d schema validation errors in sampled rows", invalid)
+	}
+	return nil
+}
+
+// exportEstimate predicts what exporting one service over a window inserts,
+// extrapolated from a sample of its newest entries.
+type exportEstimate struct {
+	Entries          int  // sampled entries
+	More             bool // whether the window holds more entries than sampled
+	Converted        int
+	ConversionErrors int
+	Dropped          sampling.Dropped
+	Sample           []bigquery.BQLogRow // sampled rows that survive sampling
+	Rows             int64               // estimated rows inserted for the window
+	Bytes            int64               // estimated bytes inserted for the window
+}
+
+// estimateExport samples up to size entries of service within [start, end]
+// and converts and samples them as an export would.
+func estimateExport(ctx context.Context, config *configs.Config, sampler *sampling.Sampler, service string, start, end time.Time, size int) (exportEstimate, error) {
+	var est exportEstimate
+	entries, more, err := logs.SamplePage(ctx, config, service, start.Format(time.RFC3339), end.Format(time.RFC3339), size)
+	if err != nil {
+		return est, err
+	}
+	est.Entries, est.More = len(entries), more
+	est.Rows = estimateEntries(entries, more, start, end)
+
+	rows, _, conversionErrors := convertEntries(ctx, service, entries)
+	est.Converted, est.ConversionErrors = len(rows), conversionErrors
+	if est.Sample, est.Dropped, err = sampler.Apply(ctx, service, rows); err != nil {
+		return est, err
+	}
+	if est.Converted > 0 {
+		est.Rows = est.Rows * int64(len(est.Sample)) / int64(est.Converted)
+	}
+
+	var sampleBytes int
+	for _, row := range est.Sample {
+		sampleBytes += bigquery.EstimateRowBytes(row)
+	}
+	if len(est.Sample) > 0 {
+		est.Bytes = est.Rows * int64(sampleBytes) / int64(len(est.Sample))
+	}
+	return est, nil
+}
+
+// estimateEntries extrapolates the number of entries in [start, end] from a
+// sample of the newest entries. Entries are returned newest first, so the
+// sample covers [oldest sampled, end] and the rate is assumed constant.
+func estimateEntries(sample []*logpb.LogEntry, more bool, start, end time.Time) int64 {
+	if !more || len(sample) == 0 {
+		return int64(len(sample))
+	}
+	oldest := sample[len(sample)-1].GetTimestamp().AsTime()
+	covered := end.Sub(oldest)
+	if covered <= 0 {
+		return int64(len(sample))
+	}
+	return int64(float64(len(sample)) * float64(end.Sub(start)) / float64(covered))
+}
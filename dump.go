@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/bigquery"
+	"github.com/phaserunner03/logging/internal/filter"
+	"github.com/phaserunner03/logging/internal/logger"
+	"github.com/phaserunner03/logging/internal/logs"
+	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// runDump writes the exported rows of the window back out as LogEntry NDJSON.
+func runDump(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	configFlags := configs.RegisterFlags(fs)
+	output := fs.String("output", "-", "NDJSON file to write, or - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(configFlags)
+	if err != nil {
+		return err
+	}
+	startDate, endDate, err := config.Window()
+	if err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
+	var f *os.File
+	if *output != "-" {
+		if f, err = os.Create(*output); err != nil {
+			return fmt.Errorf("failed to create output: %v", err)
+		}
+		w = f
+	}
+
+	n, err := dumpEntries(ctx, w, config, config.Services.Name, startDate, endDate)
+	if f != nil {
+		// Some file systems only report failed writes on Close.
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to write output: %v", closeErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Dumped log entries", "services", config.Services.Name, logger.Window(startDate, endDate), "entries", n, "output", *output)
+	return nil
+}
+
+// dumpEntries reconstructs the LogEntry of every row exported for services
+// within the window, in timestamp order, and writes those matching
+// source.filter to w as NDJSON. It returns the number of entries written.
+func dumpEntries(ctx context.Context, w io.Writer, config *configs.Config, services []string, startDate, endDate string) (int, error) {
+	start, err := time.Parse(time.RFC3339, startDate)
+	if err != nil {
+		return 0, fmt.Errorf("invalid start date: %v", err)
+	}
+	end, err := time.Parse(time.RFC3339, endDate)
+	if err != nil {
+		return 0, fmt.Errorf("invalid end date: %v", err)
+	}
+	// The Logging filter applies to the reconstructed entries, so it can use
+	// the same field paths as in Cloud Logging.
+	expr, err := filter.Parse(config.Source.Filter)
+	if err != nil {
+		return 0, fmt.Errorf("invalid filter: %v", err)
+	}
+	schema, err := bigquery.LoadSchema(config.BigQuery.Schema)
+	if err != nil {
+		return 0, err
+	}
+
+	client, tables, err := exportedTables(ctx, config)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	selects := make([]string, len(tables))
+	for i, table := range tables {
+		selects[i] = fmt.Sprintf(`SELECT %s
+FROM %s
+WHERE timestamp BETWEEN @start AND @end AND service_name IN UNNEST(@services)`, bigquery.SelectColumns(schema), bigquery.TableRef(table))
+	}
+	sql := strings.Join(selects, "\nUNION ALL\n") + "\nORDER BY timestamp"
+
+	it, err := bigquery.Query(ctx, client, sql, map[string]any{"start": start, "end": end, "services": services}, config.MaxBytesBilled())
+	if err != nil {
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(w)
+	var n int
+	for {
+		var row bigquery.BQLogRow
+		err := it.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return n, fmt.Errorf("failed to read rows: %v", err)
+		}
+		entry, err := logs.ConvertFromBQRow(row)
+		if err != nil {
+			return n, fmt.Errorf("failed to convert row %s: %v", row.InsertID, err)
+		}
+		if !filter.Match(expr, entry) {
+			continue
+		}
+		data, err := protojson.Marshal(entry)
+		if err != nil {
+			return n, fmt.Errorf("failed to marshal entry %s: %v", row.InsertID, err)
+		}
+		bw.Write(data)
+		bw.WriteByte('\n')
+		n++
+	}
+	if err := bw.Flush(); err != nil {
+		return n, fmt.Errorf("failed to write entries: %v", err)
+	}
+	return n, nil
+}
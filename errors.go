@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/bigquery"
+	"google.golang.org/api/iterator"
+)
+
+// runErrors dispatches the errors subcommands.
+func runErrors(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "summary" {
+		return errors.New("usage: errors summary [flags]")
+	}
+
+	fs := flag.NewFlagSet("errors summary", flag.ContinueOnError)
+	configFlags := configs.RegisterFlags(fs)
+	top := fs.Int("top", 10, "error groups listed per service")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(configFlags)
+	if err != nil {
+		return err
+	}
+	startDate, endDate, err := config.Window()
+	if err != nil {
+		return err
+	}
+	return errorSummary(ctx, os.Stdout, config, config.Services.Name, startDate, endDate, *top)
+}
+
+// errorGroup is one row of the error summary.
+type errorGroup struct {
+	ServiceName   bq.NullString `bigquery:"service_name"`
+	ErrorGroupID  string        `bigquery:"error_group_id"`
+	ExceptionType bq.NullString `bigquery:"exception_type"`
+	Count         int64         `bigquery:"count"`
+	FirstSeen     time.Time     `bigquery:"first_seen"`
+	LastSeen      time.Time     `bigquery:"last_seen"`
+	Sample        bq.NullString `bigquery:"sample"`
+}
+
+// errorSummary writes the top error groups of each service within the window to w.
+func errorSummary(ctx context.Context, w io.Writer, config *configs.Config, services []string, startDate, endDate string, top int) error {
+	start, err := time.Parse(time.RFC3339, startDate)
+	if err != nil {
+		return fmt.Errorf("invalid start date: %v", err)
+	}
+	end, err := time.Parse(time.RFC3339, endDate)
+	if err != nil {
+		return fmt.Errorf("invalid end date: %v", err)
+	}
+
+	client, tables, err := exportedTables(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	selects := make([]string, len(tables))
+	for i, table := range tables {
+		selects[i] = fmt.Sprintf(`SELECT service_name, error_group_id, exception_type, timestamp,
+  COALESCE(NULLIF(text_payload, ''), TO_JSON_STRING(json_payload)) AS payload
+FROM %s
+WHERE NULLIF(error_group_id, '') IS NOT NULL AND timestamp BETWEEN @start AND @end AND service_name IN UNNEST(@services)`, bigquery.TableRef(table))
+	}
+	sql := fmt.Sprintf(`SELECT service_name, error_group_id, ANY_VALUE(exception_type) AS exception_type,
+  COUNT(*) AS count, MIN(timestamp) AS first_seen, MAX(timestamp) AS last_seen,
+  ARRAY_AGG(payload ORDER BY timestamp DESC LIMIT 1)[OFFSET(0)] AS sample
+FROM (
+%s
+)
+GROUP BY service_name, error_group_id
+QUALIFY ROW_NUMBER() OVER (PARTITION BY service_name ORDER BY count DESC) <= @top
+ORDER BY service_name, count DESC`, strings.Join(selects, "\nUNION ALL\n"))
+
+	it, err := bigquery.Query(ctx, client, sql, map[string]any{
+		"start":    start,
+		"end":      end,
+		"services": services,
+		"top":      top,
+	}, config.MaxBytesBilled())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Error groups from %s to %s\n\n", startDate, endDate)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tGROUP\tEXCEPTION\tCOUNT\tFIRST SEEN\tLAST SEEN\tSAMPLE")
+	var n int
+	for {
+		var g errorGroup
+		err := it.Next(&g)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read error groups: %v", err)
+		}
+		n++
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			g.ServiceName.StringVal,
+			g.ErrorGroupID,
+			g.ExceptionType.StringVal,
+			g.Count,
+			g.FirstSeen.UTC().Format(time.RFC3339),
+			g.LastSeen.UTC().Format(time.RFC3339),
+			truncate(g.Sample.StringVal, 80),
+		)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if n == 0 {
+		fmt.Fprintln(w, "\nNo errors found.")
+	}
+	return nil
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/alerting"
+	"github.com/phaserunner03/logging/internal/bigquery"
+	"github.com/phaserunner03/logging/internal/budget"
+	"github.com/phaserunner03/logging/internal/errorgroup"
+	"github.com/phaserunner03/logging/internal/logger"
+	"github.com/phaserunner03/logging/internal/logs"
+	"github.com/phaserunner03/logging/internal/rollup"
+	"github.com/phaserunner03/logging/internal/routing"
+	"github.com/phaserunner03/logging/internal/sampling"
+	"github.com/phaserunner03/logging/internal/server"
+	"github.com/phaserunner03/logging/internal/telemetry"
+	"github.com/phaserunner03/logging/internal/traces"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/api/iterator"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+)
+
+// runStatus records the outcome of every export run so the control plane can report it.
+var runStatus = server.NewStatus()
+
+// exporter runs the fetch, convert, sample, enrich and insert pipeline for one configuration.
+type exporter struct {
+	config  *configs.Config
+	router  *routing.Router
+	sampler *sampling.Sampler
+	alerts  *alerting.Evaluator
+
+	// budget bounds the rows inserted by this run; nil lifts the limits.
+	budget *budget.Budget
+
+	// replace rewrites each exported window instead of appending to it, see
+	// bigquery.ReplaceBatches and bigquery.ReplaceRollups.
+	replace bool
+
+	// fillGaps skips converted rows whose insert_id is already exported and
+	// replaces the window's rollups, so a partly written window can be
+	// exported again without duplicates.
+	fillGaps bool
+}
+
+func newExporter(config *configs.Config) (*exporter, error) {
+	router, err := routing.New(config)
+	if err != nil {
+		return nil, err
+	}
+	alerts, err := alerting.New(config)
+	if err != nil {
+		return nil, err
+	}
+	e := &exporter{
+		config: config,
+		router: router,
+		alerts: alerts,
+		budget: budget.New(config),
+	}
+	e.sampler = sampling.New(config, e.countExported)
+	return e, nil
+}
+
+func (e *exporter) processLogs(ctx context.Context, services []string, startDate, endDate string) error {
+	var failed int
+	for _, service := range services {
+		if _, err := e.exportService(ctx, service, startDate, endDate); err != nil {
+			slog.ErrorContext(ctx, "Failed to export logs",
+				logger.KeyService, service,
+				logger.Window(startDate, endDate),
+				"error", err,
+			)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d services failed to export", failed, len(services))
+	}
+	return nil
+}
+
+// exportResult summarizes one service export.
+type exportResult struct {
+	Rows    int       // rows written to BigQuery
+	Rollups int       // rollup rows written to BigQuery
+	Dropped int       // rows removed by sampling and daily caps
+	Newest  time.Time // timestamp of the newest converted entry
+}
+
+// exportService runs the pipeline for one service and records the run in
+// runStatus. It returns the rows written, raw and rollup.
+func (e *exporter) exportService(ctx context.Context, service, startDate, endDate string) (int, error) {
+	run := server.Run{Start: time.Now().UTC(), WindowStart: startDate, WindowEnd: endDate}
+	result, err := e.exportServiceLogs(ctx, service, startDate, endDate)
+	run.End = time.Now().UTC()
+	run.Rows = result.Rows
+	run.Rollups = result.Rollups
+	run.Dropped = result.Dropped
+	run.Newest = result.Newest
+	run.Err = err
+	runStatus.Record(service, run)
+	return result.Rows + result.Rollups, err
+}
+
+func (e *exporter) exportServiceLogs(ctx context.Context, service, startDate, endDate string) (result exportResult, err error) {
+	ctx, span := telemetry.Tracer().Start(ctx, "exportService")
+	defer func() {
+		span.SetAttributes(attribute.Int("rows", result.Rows), attribute.Int("rollups", result.Rollups), attribute.Int("dropped", result.Dropped))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	span.SetAttributes(
+		attribute.String("service", service),
+		attribute.String("window.start", startDate),
+		attribute.String("window.end", endDate),
+	)
+
+	start, end, err := parseWindow(startDate, endDate)
+	if err != nil {
+		return result, err
+	}
+	// Rollups are computed from whole buckets and replace the buckets the
+	// window touches, so a bucket split across runs is rewritten in full.
+	fetchStart, fetchEnd := startDate, endDate
+	var firstBucket, lastBucket time.Time
+	if e.config.Rollup.Enabled {
+		interval := e.config.RollupInterval()
+		firstBucket, lastBucket = start.UTC().Truncate(interval), end.UTC().Truncate(interval)
+		fetchStart = firstBucket.Format(time.RFC3339Nano)
+		fetchEnd = lastBucket.Add(interval - time.Nanosecond).Format(time.RFC3339Nano)
+	}
+
+	// Fetch logs from Cloud Logging
+	stageStart := time.Now()
+	entries, err := logs.FetchLogs(ctx, e.config, []string{service}, fetchStart, fetchEnd)
+	telemetry.StageDuration.WithLabelValues(service, telemetry.StageFetch).Observe(time.Since(stageStart).Seconds())
+	if err != nil {
+		return result, fmt.Errorf("failed to fetch logs: %v", err)
+	}
+
+	if len(entries) == 0 {
+		slog.InfoContext(ctx, "No log entries to process",
+			logger.KeyService, service,
+			logger.Window(startDate, endDate),
+		)
+		if e.replace {
+			// Rows exported earlier for the window must still go.
+			if e.config.Rollup.Enabled {
+				if err := bigquery.ReplaceRollups(ctx, e.config, service, firstBucket, lastBucket, nil); err != nil {
+					return result, fmt.Errorf("failed to replace rollups in BigQuery: %v", err)
+				}
+			}
+			if !e.config.Rollup.Enabled || e.config.Rollup.Raw {
+				if _, err := e.replaceRows(ctx, service, startDate, endDate, nil); err != nil {
+					return result, fmt.Errorf("failed to replace logs in BigQuery: %v", err)
+				}
+			}
+		}
+		return result, nil
+	}
+
+	converted, _, conversionErrors := convertEntries(ctx, service, entries)
+	if len(converted) == 0 {
+		return result, fmt.Errorf("all %d log entries failed to convert", len(entries))
+	}
+	bqRows := converted
+	if e.config.Rollup.Enabled {
+		bqRows = inWindow(converted, start, end)
+	}
+	for _, row := range bqRows {
+		if row.Timestamp.After(result.Newest) {
+			result.Newest = row.Timestamp
+		}
+	}
+
+	e.alerts.Evaluate(ctx, service, bqRows)
+
+	if e.config.Rollup.Enabled {
+		rollups := rollup.Aggregate(converted, e.config.RollupInterval())
+		usage := budget.Usage{Rows: int64(len(rollups))}
+		for _, row := range rollups {
+			usage.Bytes += int64(bigquery.EstimateRowBytes(row))
+		}
+		if err := e.budget.Reserve(service, usage); err != nil {
+			return result, err
+		}
+		err = bigquery.ReplaceRollups(ctx, e.config, service, firstBucket, lastBucket, rollups)
+		if err != nil {
+			// The buckets are swapped in one transaction: nothing was written.
+			e.budget.Release(service, usage)
+		} else {
+			result.Rollups = len(rollups)
+		}
+		switch {
+		case err != nil && !e.config.Rollup.Raw:
+			return result, fmt.Errorf("failed to insert rollups into BigQuery: %v", err)
+		case err != nil:
+			// The raw rows are still exported; the run fails afterwards so
+			// the window is retried, which replaces its rollups.
+			slog.ErrorContext(ctx, "Failed to insert rollups, exporting raw rows",
+				logger.KeyService, service,
+				logger.Window(startDate, endDate),
+				"error", err,
+			)
+			rollupErr := fmt.Errorf("failed to insert rollups into BigQuery: %v", err)
+			defer func() { err = errors.Join(err, rollupErr) }()
+		default:
+			slog.InfoContext(ctx, "Inserted rollups",
+				logger.KeyService, service,
+				logger.Window(startDate, endDate),
+				"buckets", len(rollups),
+				"interval", e.config.Rollup.Interval,
+			)
+			if !e.config.Rollup.Raw {
+				return result, nil
+			}
+		}
+	}
+	if len(bqRows) == 0 && !e.replace {
+		// Only entries of neighbouring windows share the buckets.
+		return result, nil
+	}
+
+	if e.fillGaps {
+		total := len(bqRows)
+		if bqRows, err = unexported(ctx, e.config, service, startDate, endDate, bqRows); err != nil {
+			return result, fmt.Errorf("failed to find exported entries: %v", err)
+		}
+		if len(bqRows) == 0 {
+			slog.InfoContext(ctx, "All log entries already exported",
+				logger.KeyService, service,
+				logger.Window(startDate, endDate),
+				"entries", total,
+			)
+			return result, nil
+		}
+	}
+
+	bqRows, dropped, err := e.sampler.Apply(ctx, service, bqRows)
+	if err != nil {
+		return result, fmt.Errorf("failed to apply sampling policy: %v", err)
+	}
+	result.Dropped = dropped.Total()
+	telemetry.RowsDropped.WithLabelValues(service, telemetry.StageSample, "sampled").Add(float64(dropped.Sampled))
+	telemetry.RowsDropped.WithLabelValues(service, telemetry.StageSample, "daily_cap").Add(float64(dropped.Capped))
+	if dropped.Total() > 0 {
+		slog.InfoContext(ctx, "Dropped rows by sampling policy",
+			logger.KeyService, service,
+			logger.KeyStage, telemetry.StageSample,
+			"sampled", dropped.Sampled,
+			"daily_cap", dropped.Capped,
+			"kept", len(bqRows),
+		)
+	}
+
+	if e.config.Trace.Enrich {
+		if _, err := traces.Enrich(ctx, e.config, service, bqRows); err != nil {
+			return result, fmt.Errorf("failed to enrich rows from Cloud Trace: %v", err)
+		}
+	}
+
+	var size int64
+	for _, row := range bqRows {
+		size += int64(bigquery.EstimateRowBytes(row))
+	}
+	// Reserved up front so concurrent services cannot overshoot the run budget
+	// together; what fails to insert is released below.
+	if err := e.budget.Reserve(service, budget.Usage{Rows: int64(len(bqRows)), Bytes: size}); err != nil {
+		return result, err
+	}
+
+	// Insert rows into BigQuery, one batch per destination table
+	stageStart = time.Now()
+	if e.replace {
+		result.Rows, err = e.replaceRows(ctx, service, startDate, endDate, bqRows)
+	} else {
+		result.Rows, err = bigquery.InsertBatches(ctx, e.config, e.router.Split(bqRows))
+	}
+	// Replacing drops earlier rows of the window, so the caps are counted again.
+	e.sampler.Record(service, bqRows, err == nil && !e.replace)
+	telemetry.StageDuration.WithLabelValues(service, telemetry.StageInsert).Observe(time.Since(stageStart).Seconds())
+	if err != nil {
+		// Only the rows written count against the budget.
+		if unwritten := int64(len(bqRows) - result.Rows); unwritten > 0 {
+			e.budget.Release(service, budget.Usage{Rows: unwritten, Bytes: size * unwritten / int64(len(bqRows))})
+		}
+		return result, fmt.Errorf("failed to insert logs into BigQuery (%d of %d rows written): %v", result.Rows, len(bqRows), err)
+	}
+
+	slog.InfoContext(ctx, "Successfully processed log entries",
+		logger.KeyService, service,
+		logger.Window(startDate, endDate),
+		"rows", len(bqRows),
+		"dropped", dropped.Total(),
+		"conversion_errors", conversionErrors,
+	)
+	return result, nil
+}
+
+// replaceRows replaces the rows of service exported within the window with
+// rows and returns the number of rows written.
+func (e *exporter) replaceRows(ctx context.Context, service, startDate, endDate string, rows []bigquery.BQLogRow) (int, error) {
+	start, end, err := parseWindow(startDate, endDate)
+	if err != nil {
+		return 0, err
+	}
+	return bigquery.ReplaceBatches(ctx, e.config, service, start, end, e.router.Destinations(), e.router.Split(rows))
+}
+
+// countExported counts the rows of service exported within [start, end], to
+// seed the daily caps of the sampler.
+func (e *exporter) countExported(ctx context.Context, service string, start, end time.Time) (int64, error) {
+	return bigquery.CountRows(ctx, e.config, e.router.Destinations(), service, start, end)
+}
+
+// unexported returns the rows whose insert_id has no row of service in the
+// exported tables within the window.
+func unexported(ctx context.Context, config *configs.Config, service, startDate, endDate string, rows []bigquery.BQLogRow) ([]bigquery.BQLogRow, error) {
+	start, end, err := parseWindow(startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	client, tables, err := exportedTables(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	selects := make([]string, len(tables))
+	for i, table := range tables {
+		selects[i] = fmt.Sprintf(`SELECT insert_id
+FROM %s
+WHERE timestamp BETWEEN @start AND @end AND service_name = @service`, bigquery.TableRef(table))
+	}
+	sql := "SELECT DISTINCT insert_id FROM (\n" + strings.Join(selects, "\nUNION ALL\n") + "\n)"
+
+	it, err := bigquery.Query(ctx, client, sql, map[string]any{"start": start, "end": end, "service": service}, config.MaxBytesBilled())
+	if err != nil {
+		return nil, err
+	}
+	exported := make(map[string]bool)
+	for {
+		var row struct {
+			InsertID bq.NullString `bigquery:"insert_id"`
+		}
+		err := it.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read exported insert IDs: %v", err)
+		}
+		exported[row.InsertID.StringVal] = true
+	}
+
+	var missing []bigquery.BQLogRow
+	for _, row := range rows {
+		if !exported[row.InsertID] {
+			missing = append(missing, row)
+		}
+	}
+	return missing, nil
+}
+
+// parseWindow parses the RFC 3339 bounds of an export window.
+func parseWindow(startDate, endDate string) (time.Time, time.Time, error) {
+	start, err := time.Parse(time.RFC3339Nano, startDate)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid start date: %v", err)
+	}
+	end, err := time.Parse(time.RFC3339Nano, endDate)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid end date: %v", err)
+	}
+	return start, end, nil
+}
+
+// inWindow returns the rows with a timestamp within [start, end].
+func inWindow(rows []bigquery.BQLogRow, start, end time.Time) []bigquery.BQLogRow {
+	var out []bigquery.BQLogRow
+	for _, row := range rows {
+		if !row.Timestamp.Before(start) && !row.Timestamp.After(end) {
+			out = append(out, row)
+		}
+	}
+	return out
+}
+
+// convertEntries converts log entries to BigQuery rows, skipping entries that fail to convert.
+func convertEntries(ctx context.Context, service string, entries []*logpb.LogEntry) ([]bigquery.BQLogRow, time.Time, int) {
+	_, span := telemetry.Tracer().Start(ctx, "ConvertToBQRow")
+	defer span.End()
+	start := time.Now()
+	defer func() {
+		telemetry.StageDuration.WithLabelValues(service, telemetry.StageConvert).Observe(time.Since(start).Seconds())
+	}()
+
+	var newest time.Time
+	var conversionErrors int
+
+	// Pre-allocate slice with exact capacity needed
+	bqRows := make([]bigquery.BQLogRow, 0, len(entries))
+
+	// Convert log entries to BigQuery rows in batch
+	for _, entry := range entries {
+		row, err := logs.ConvertToBQRow(entry)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to convert log entry",
+				logger.KeyService, service,
+				logger.KeyStage, telemetry.StageConvert,
+				"insert_id", entry.GetInsertId(),
+				"error", err,
+			)
+			conversionErrors++
+			continue
+		}
+		row.ServiceName = entry.GetResource().GetLabels()["service_name"] // Add service name to row
+		errorgroup.Classify(&row)
+		if row.Timestamp.After(newest) {
+			newest = row.Timestamp
+		}
+		bqRows = append(bqRows, row)
+	}
+
+	telemetry.RowsConverted.WithLabelValues(service, telemetry.StageConvert).Add(float64(len(bqRows)))
+	telemetry.ConversionErrors.WithLabelValues(service, telemetry.StageConvert).Add(float64(conversionErrors))
+	span.SetAttributes(
+		attribute.String("service", service),
+		attribute.Int("rows", len(bqRows)),
+		attribute.Int("conversion_errors", conversionErrors),
+	)
+	return bqRows, newest, conversionErrors
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/fakes"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+	logtypepb "google.golang.org/genproto/googleapis/logging/type"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// newTestExport starts the Logging and BigQuery fakes and returns a config
+// exporting the checkout service through them into logs.<table>.
+func newTestExport(t *testing.T, table string) (*fakes.Logging, *fakes.BigQuery, *configs.Config) {
+	t.Helper()
+	logging, err := fakes.NewLogging()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(logging.Close)
+	bq := fakes.NewBigQuery()
+	t.Cleanup(bq.Close)
+
+	config := &configs.Config{}
+	config.Services.Name = []string{"checkout"}
+	config.Resource.Type = []string{"cloud_run_revision"}
+	config.GCP.ProjectID = "test-project"
+	config.BigQuery.DatasetID = "logs"
+	config.BigQuery.TableID = table
+	config.BigQuery.Schema = "./schema.json"
+	config.BigQuery.Layout = "single"
+	config.BigQuery.Partitioning = "partitioned"
+	config.BigQuery.AutoCreate = true
+	config.Sampling.Key = "trace"
+	config.Emulator.Logging = logging.Addr()
+	config.Emulator.BigQuery = bq.Host()
+	return logging, bq, config
+}
+
+// TestExportEndToEnd runs the whole pipeline from the Logging fake to the
+// BigQuery fake.
+func TestExportEndToEnd(t *testing.T) {
+	logging, bq, config := newTestExport(t, "e2e")
+
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	resource := &mrpb.MonitoredResource{
+		Type:   "cloud_run_revision",
+		Labels: map[string]string{"service_name": "checkout", "project_id": "test-project"},
+	}
+	logging.Add(
+		&logpb.LogEntry{
+			LogName:   "projects/test-project/logs/run.googleapis.com%2Frequests",
+			Resource:  resource,
+			Timestamp: timestamppb.New(ts),
+			Severity:  logtypepb.LogSeverity_ERROR,
+			InsertId:  "request-1",
+			Trace:     "projects/test-project/traces/abc123",
+			HttpRequest: &logtypepb.HttpRequest{
+				RequestMethod: "POST",
+				RequestUrl:    "https://checkout.example.com/cart/pay?order=42",
+				Status:        500,
+				Latency:       durationpb.New(123 * time.Millisecond),
+			},
+		},
+		&logpb.LogEntry{
+			LogName:   "projects/test-project/logs/run.googleapis.com%2Fstderr",
+			Resource:  resource,
+			Timestamp: timestamppb.New(ts.Add(time.Millisecond)),
+			Severity:  logtypepb.LogSeverity_ERROR,
+			InsertId:  "stderr-1",
+			Trace:     "projects/test-project/traces/abc123",
+			Payload: &logpb.LogEntry_TextPayload{TextPayload: "Traceback (most recent call last):\n" +
+				"  File \"/app/main.py\", line 10, in pay\n    charge()\nValueError: card declined"},
+		},
+	)
+
+	exp, err := newExporter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, end := ts.Add(-time.Hour).Format(time.RFC3339), ts.Add(time.Hour).Format(time.RFC3339)
+	if err := exp.processLogs(context.Background(), config.Services.Name, start, end); err != nil {
+		t.Fatalf("processLogs: %v", err)
+	}
+
+	rows := bq.Rows("test-project", "logs", "e2e")
+	if len(rows) != 2 {
+		t.Fatalf("exported %d rows, want 2", len(rows))
+	}
+	byID := make(map[string]map[string]any)
+	for _, row := range rows {
+		byID[row["insert_id"].(string)] = row
+	}
+
+	request := byID["request-1"]
+	for col, want := range map[string]any{
+		"service_name":   "checkout",
+		"source_project": "test-project",
+		"request_method": "POST",
+		"request_path":   "/cart/pay",
+		"request_query":  "order=42",
+		"status":         float64(500),
+		"latency_ms":     float64(123),
+		"trace_id":       "abc123",
+	} {
+		if got := request[col]; got != want {
+			t.Errorf("request row %s = %v (%T), want %v", col, got, got, want)
+		}
+	}
+
+	stderr := byID["stderr-1"]
+	if got := stderr["exception_type"]; got != "ValueError" {
+		t.Errorf("exception_type = %v, want ValueError", got)
+	}
+	if got, _ := stderr["error_group_id"].(string); got == "" {
+		t.Error("error_group_id is empty for a Python traceback")
+	}
+
+	status := runStatus.Snapshot()
+	if len(status) != 1 || status[0].RowsExported != 2 || status[0].LastError != "" {
+		t.Errorf("run status = %+v, want one successful run of 2 rows", status)
+	}
+}
+
+// enableRollups turns on rollups into logs.<table>_rollups with the given
+// interval and answers their transactions like BigQuery.
+func enableRollups(bq *fakes.BigQuery, config *configs.Config, interval string) {
+	config.Rollup.Enabled = true
+	config.Rollup.Raw = true
+	config.Rollup.Interval = interval
+	config.Rollup.Schema = "./rollup_schema.json"
+	config.Rollup.TableID = config.BigQuery.TableID + "_rollups"
+	table := "test-project.logs." + config.Rollup.TableID
+	bq.HandleQueries(func(sql string, params map[string]any) (*fakes.QueryResult, error) {
+		return nil, swapRows(bq, sql, params, table, "bucket_start")
+	})
+}
+
+func checkoutEntry(id string, at time.Time) *logpb.LogEntry {
+	return &logpb.LogEntry{
+		LogName:   "projects/test-project/logs/run.googleapis.com%2Frequests",
+		Resource:  &mrpb.MonitoredResource{Type: "cloud_run_revision", Labels: map[string]string{"service_name": "checkout"}},
+		Timestamp: timestamppb.New(at),
+		InsertId:  id,
+	}
+}
+
+// TestExportKeepsRawRowsWhenRollupsFail checks that a failed rollup insert
+// still exports the raw rows and fails the run.
+func TestExportKeepsRawRowsWhenRollupsFail(t *testing.T) {
+	logging, bq, config := newTestExport(t, "rollup_failure")
+	enableRollups(bq, config, "1m")
+	bq.HandleQueries(func(sql string, params map[string]any) (*fakes.QueryResult, error) {
+		return nil, errors.New("transaction aborted")
+	})
+
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	logging.Add(checkoutEntry("request-1", ts))
+
+	exp, err := newExporter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, end := ts.Add(-time.Hour).Format(time.RFC3339), ts.Add(time.Hour).Format(time.RFC3339)
+	result, err := exp.exportServiceLogs(context.Background(), "checkout", start, end)
+	if err == nil || !strings.Contains(err.Error(), "rollups") {
+		t.Errorf("export error = %v, want a rollup insert failure", err)
+	}
+	if result.Rows != 1 || len(bq.Rows("test-project", "logs", "rollup_failure")) != 1 {
+		t.Errorf("exported %d raw rows, want 1", result.Rows)
+	}
+	if got := bq.Rows("test-project", "logs", "rollup_failure_rollups"); len(got) != 0 {
+		t.Errorf("rollup table has %d rows after a failed insert", len(got))
+	}
+}
+
+// TestExportRollupsOfUnalignedWindows checks that a bucket split across two
+// runs ends up as one rollup row of all its entries, while raw rows are
+// exported once.
+func TestExportRollupsOfUnalignedWindows(t *testing.T) {
+	logging, bq, config := newTestExport(t, "unaligned")
+	enableRollups(bq, config, "10m")
+
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, minutes := range []int{1, 4, 6, 8, 12} {
+		logging.Add(checkoutEntry(fmt.Sprint("entry-", i), t0.Add(time.Duration(minutes)*time.Minute)))
+	}
+
+	exp, err := newExporter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, w := range [][2]time.Duration{{0, 5*time.Minute - time.Second}, {5 * time.Minute, 15*time.Minute - time.Second}} {
+		start, end := t0.Add(w[0]).Format(time.RFC3339), t0.Add(w[1]).Format(time.RFC3339)
+		if _, err := exp.exportServiceLogs(ctx, "checkout", start, end); err != nil {
+			t.Fatalf("export %s to %s: %v", start, end, err)
+		}
+	}
+
+	if got := len(bq.Rows("test-project", "logs", "unaligned")); got != 5 {
+		t.Errorf("exported %d raw rows, want 5", got)
+	}
+	counts := make(map[time.Time]any)
+	for _, row := range bq.Rows("test-project", "logs", "unaligned_rollups") {
+		bucket := parseTime(row["bucket_start"])
+		if _, ok := counts[bucket]; ok {
+			t.Errorf("bucket %s has more than one rollup row", bucket)
+		}
+		counts[bucket] = row["entry_count"]
+	}
+	want := map[time.Time]any{t0: "4", t0.Add(10 * time.Minute): "1"}
+	if fmt.Sprint(counts) != fmt.Sprint(want) {
+		t.Errorf("entry counts by bucket = %v, want %v", counts, want)
+	}
+}
+
+// TestExportChargesRollupsToBudget checks that rollup rows count against the
+// insert budget and are reported, also when raw rows are not kept.
+func TestExportChargesRollupsToBudget(t *testing.T) {
+	logging, bq, config := newTestExport(t, "rollup_budget")
+	enableRollups(bq, config, "1m")
+	config.Rollup.Raw = false
+	config.Budget.MaxRowsPerRun = 2
+
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := range 3 {
+		logging.Add(checkoutEntry(fmt.Sprint("entry-", i), t0.Add(time.Duration(i)*time.Minute)))
+	}
+	exp, err := newExporter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	result, err := exp.exportServiceLogs(ctx, "checkout", t0.Format(time.RFC3339), t0.Add(time.Minute-time.Second).Format(time.RFC3339))
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if result.Rows != 0 || result.Rollups != 1 {
+		t.Errorf("result = %+v, want 1 rollup row and no raw rows", result)
+	}
+
+	// Two more buckets would take the run to 3 rows.
+	if _, err := exp.exportServiceLogs(ctx, "checkout", t0.Add(time.Minute).Format(time.RFC3339), t0.Add(3*time.Minute-time.Second).Format(time.RFC3339)); err == nil {
+		t.Error("export over the row budget succeeded")
+	}
+	if got := len(bq.Rows("test-project", "logs", "rollup_budget_rollups")); got != 1 {
+		t.Errorf("rollup table has %d rows, want 1", got)
+	}
+	if used := exp.budget.Used(); used.Rows != 1 || used.Bytes == 0 {
+		t.Errorf("budget used = %+v, want the first rollup row", used)
+	}
+}
@@ -0,0 +1,350 @@
+// Package alerting evaluates alert rules on converted rows during export and
+// delivers the resulting alerts to notifiers.
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/bigquery"
+	"github.com/phaserunner03/logging/internal/logger"
+	"github.com/phaserunner03/logging/internal/routing"
+	"github.com/phaserunner03/logging/internal/telemetry"
+)
+
+// Rule types, see configs.AlertRule.
+const (
+	ErrorRate      = "error_rate"
+	Pattern        = "pattern"
+	NewFingerprint = "new_fingerprint"
+)
+
+// Alert is a fired rule as delivered to notifiers.
+type Alert struct {
+	Rule          string    `json:"rule"`
+	Type          string    `json:"type"`
+	Service       string    `json:"service"`
+	Summary       string    `json:"summary"`
+	Value         float64   `json:"value,omitempty"`
+	Threshold     float64   `json:"threshold,omitempty"`
+	WindowStart   time.Time `json:"window_start,omitzero"`
+	WindowEnd     time.Time `json:"window_end,omitzero"`
+	ErrorGroupID  string    `json:"error_group_id,omitempty"`
+	ExceptionType string    `json:"exception_type,omitempty"`
+	Sample        string    `json:"sample,omitempty"`
+	FiredAt       time.Time `json:"fired_at"`
+}
+
+// Evaluator keeps the per-window counts, seen fingerprints and cooldowns of
+// the configured rules across export runs. It is safe for concurrent use.
+type Evaluator struct {
+	rules     []rule
+	notifiers []Notifier
+	stateFile string
+
+	mu      sync.Mutex
+	buckets map[bucketKey]*bucket
+	state   state
+}
+
+type rule struct {
+	configs.AlertRule
+	service  func(string) bool
+	pattern  *regexp.Regexp
+	window   time.Duration
+	cooldown time.Duration
+}
+
+type bucketKey struct {
+	rule    string
+	service string
+	start   time.Time
+}
+
+// bucket holds the counts of one rule and service over one window.
+type bucket struct {
+	total   int
+	errors  int
+	matches int
+	sample  string
+	fired   bool
+}
+
+// state is what the evaluator persists to alerts.state_file.
+type state struct {
+	// Fingerprints maps service to error_group_id to when it was first seen.
+	Fingerprints map[string]map[string]time.Time `json:"fingerprints"`
+	// LastFired maps a deduplication key to when it last fired.
+	LastFired map[string]time.Time `json:"last_fired"`
+}
+
+// New compiles the alert rules and notifiers of config and loads the state
+// file if there is one.
+func New(config *configs.Config) (*Evaluator, error) {
+	e := &Evaluator{
+		stateFile: config.Alerts.StateFile,
+		buckets:   make(map[bucketKey]*bucket),
+		state: state{
+			Fingerprints: make(map[string]map[string]time.Time),
+			LastFired:    make(map[string]time.Time),
+		},
+	}
+	for _, cfg := range config.Alerts.Rules {
+		r := rule{AlertRule: cfg, window: cfg.RuleWindow(), cooldown: config.RuleCooldown(cfg)}
+		var err error
+		if r.service, err = routing.Matcher(cfg.Service); err != nil {
+			return nil, fmt.Errorf("alert rule %s: %v", cfg.Name, err)
+		}
+		if cfg.Pattern != "" {
+			if r.pattern, err = regexp.Compile(cfg.Pattern); err != nil {
+				return nil, fmt.Errorf("alert rule %s: %v", cfg.Name, err)
+			}
+		}
+		e.rules = append(e.rules, r)
+	}
+	for _, cfg := range config.Alerts.Notifiers {
+		n, err := NewNotifier(cfg)
+		if err != nil {
+			return nil, err
+		}
+		e.notifiers = append(e.notifiers, n)
+	}
+
+	if e.stateFile != "" {
+		data, err := os.ReadFile(e.stateFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read alert state: %v", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &e.state); err != nil {
+				return nil, fmt.Errorf("failed to parse alert state %s: %v", e.stateFile, err)
+			}
+		}
+	}
+	return e, nil
+}
+
+// Evaluate feeds the converted rows of service to every matching rule and
+// notifies the alerts that fire. Notification failures are logged, not returned.
+func (e *Evaluator) Evaluate(ctx context.Context, service string, rows []bigquery.BQLogRow) {
+	if len(e.rules) == 0 || len(rows) == 0 {
+		return
+	}
+
+	alerts := e.evaluate(service, rows, time.Now().UTC())
+	for _, alert := range alerts {
+		telemetry.AlertsFired.WithLabelValues(alert.Rule, alert.Service).Inc()
+		slog.WarnContext(ctx, "Alert fired",
+			logger.KeyService, service,
+			"rule", alert.Rule,
+			"summary", alert.Summary,
+		)
+		for _, n := range e.notifiers {
+			if err := n.Notify(ctx, alert); err != nil {
+				slog.ErrorContext(ctx, "Failed to deliver alert",
+					logger.KeyService, service,
+					"rule", alert.Rule,
+					"notifier", n.Name(),
+					"error", err,
+				)
+			}
+		}
+	}
+}
+
+func (e *Evaluator) evaluate(service string, rows []bigquery.BQLogRow, now time.Time) []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var alerts []Alert
+	for _, r := range e.rules {
+		if !r.service(service) {
+			continue
+		}
+		var fired []Alert
+		if r.Type == NewFingerprint {
+			fired = e.newFingerprints(r, service, rows, now)
+		} else {
+			fired = e.windowed(r, service, rows, now)
+		}
+		for _, alert := range fired {
+			key := alert.Rule + "|" + alert.Service + "|" + alert.ErrorGroupID
+			if last, ok := e.state.LastFired[key]; ok && now.Sub(last) < r.cooldown {
+				continue
+			}
+			e.state.LastFired[key] = now
+			alerts = append(alerts, alert)
+		}
+	}
+
+	if e.stateFile != "" {
+		if err := e.saveState(); err != nil {
+			slog.Error("Failed to save alert state", "path", e.stateFile, "error", err)
+		}
+	}
+	return alerts
+}
+
+// windowed updates the window buckets of an error_rate or pattern rule and
+// returns an alert for every bucket that newly meets the condition.
+func (e *Evaluator) windowed(r rule, service string, rows []bigquery.BQLogRow, now time.Time) []Alert {
+	touched := make(map[bucketKey]bool)
+	var latest time.Time
+	for _, row := range rows {
+		k := bucketKey{rule: r.Name, service: service, start: row.Timestamp.UTC().Truncate(r.window)}
+		b, ok := e.buckets[k]
+		if !ok {
+			b = &bucket{}
+			e.buckets[k] = b
+		}
+		touched[k] = true
+		if k.start.After(latest) {
+			latest = k.start
+		}
+
+		b.total++
+		if isError(row.Severity) {
+			b.errors++
+			if b.sample == "" && r.Type == ErrorRate {
+				b.sample = payload(row)
+			}
+		}
+		if r.pattern != nil {
+			if text := payload(row); r.pattern.MatchString(text) {
+				b.matches++
+				if b.sample == "" {
+					b.sample = text
+				}
+			}
+		}
+	}
+
+	keys := make([]bucketKey, 0, len(touched))
+	for k := range touched {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].start.Before(keys[j].start) })
+
+	var alerts []Alert
+	for _, k := range keys {
+		b := e.buckets[k]
+		if b.fired {
+			continue
+		}
+		alert := Alert{
+			Rule:        r.Name,
+			Type:        r.Type,
+			Service:     service,
+			WindowStart: k.start,
+			WindowEnd:   k.start.Add(r.window),
+			Sample:      truncate(b.sample, 500),
+			FiredAt:     now,
+		}
+		switch r.Type {
+		case ErrorRate:
+			rate := float64(b.errors) / float64(b.total)
+			if b.total < r.MinRows || rate <= r.Threshold {
+				continue
+			}
+			alert.Value, alert.Threshold = rate, r.Threshold
+			alert.Summary = fmt.Sprintf("%s: error rate %.1f%% (%d of %d rows) exceeds %.1f%% in %s window starting %s",
+				service, 100*rate, b.errors, b.total, 100*r.Threshold, r.window, k.start.Format(time.RFC3339))
+		case Pattern:
+			if b.matches < r.Count {
+				continue
+			}
+			alert.Value, alert.Threshold = float64(b.matches), float64(r.Count)
+			alert.Summary = fmt.Sprintf("%s: /%s/ matched %d rows in %s window starting %s",
+				service, r.Pattern, b.matches, r.window, k.start.Format(time.RFC3339))
+		}
+		b.fired = true
+		alerts = append(alerts, alert)
+	}
+
+	// Keep the buckets that later runs may still add rows to.
+	for k := range e.buckets {
+		if k.rule == r.Name && k.service == service && k.start.Before(latest.Add(-2*r.window)) {
+			delete(e.buckets, k)
+		}
+	}
+	return alerts
+}
+
+// newFingerprints returns an alert for every error group of service not seen before.
+func (e *Evaluator) newFingerprints(r rule, service string, rows []bigquery.BQLogRow, now time.Time) []Alert {
+	seen := e.state.Fingerprints[service]
+	if seen == nil {
+		seen = make(map[string]time.Time)
+		e.state.Fingerprints[service] = seen
+	}
+
+	var alerts []Alert
+	for _, row := range rows {
+		group := row.ErrorGroupID.StringVal
+		if group == "" {
+			continue
+		}
+		if _, ok := seen[group]; ok {
+			continue
+		}
+		seen[group] = row.Timestamp
+		alerts = append(alerts, Alert{
+			Rule:          r.Name,
+			Type:          r.Type,
+			Service:       service,
+			Summary:       fmt.Sprintf("%s: new error %s (group %s) first seen at %s", service, row.ExceptionType.StringVal, group, row.Timestamp.UTC().Format(time.RFC3339)),
+			ErrorGroupID:  group,
+			ExceptionType: row.ExceptionType.StringVal,
+			Sample:        truncate(payload(row), 500),
+			FiredAt:       now,
+		})
+	}
+	return alerts
+}
+
+func (e *Evaluator) saveState() error {
+	data, err := json.MarshalIndent(e.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := e.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, e.stateFile)
+}
+
+func isError(severity string) bool {
+	switch severity {
+	case "ERROR", "CRITICAL", "ALERT", "EMERGENCY":
+		return true
+	}
+	return false
+}
+
+// payload returns the text or JSON payload of row.
+func payload(row bigquery.BQLogRow) string {
+	if row.TextPayload != "" {
+		return row.TextPayload
+	}
+	if row.JsonPayload == "null" {
+		return ""
+	}
+	return row.JsonPayload
+}
+
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,133 @@
+package alerting
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/bigquery"
+)
+
+var t0 = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func newEvaluator(t *testing.T, alerts configs.AlertsConfig) *Evaluator {
+	t.Helper()
+	e, err := New(&configs.Config{Alerts: alerts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// rows returns n rows at at, the first errors of which have severity ERROR.
+func rows(at time.Time, n, errors int, text string) []bigquery.BQLogRow {
+	out := make([]bigquery.BQLogRow, n)
+	for i := range out {
+		out[i] = bigquery.BQLogRow{Timestamp: at.Add(time.Duration(i) * time.Second), Severity: "INFO", TextPayload: text}
+		if i < errors {
+			out[i].Severity = "ERROR"
+		}
+	}
+	return out
+}
+
+func TestErrorRate(t *testing.T) {
+	rule := configs.AlertRule{Name: "api-errors", Type: ErrorRate, Service: "api", Window: "5m", Threshold: 0.5, MinRows: 4}
+	tests := []struct {
+		name        string
+		total, errs int
+		service     string
+		fire        bool
+	}{
+		{"over threshold", 4, 3, "api", true},
+		{"at threshold", 4, 2, "api", false},
+		{"under min_rows", 3, 3, "api", false},
+		{"other service", 4, 4, "web", false},
+	}
+	for _, tt := range tests {
+		e := newEvaluator(t, configs.AlertsConfig{Rules: []configs.AlertRule{rule}})
+		alerts := e.evaluate(tt.service, rows(t0, tt.total, tt.errs, "x"), t0)
+		if got := len(alerts) == 1; got != tt.fire {
+			t.Errorf("%s: fired %d alerts, want fired = %v", tt.name, len(alerts), tt.fire)
+			continue
+		}
+		if tt.fire && (alerts[0].Value != 0.75 || !alerts[0].WindowStart.Equal(t0) || !alerts[0].WindowEnd.Equal(t0.Add(5*time.Minute))) {
+			t.Errorf("%s: alert = %+v", tt.name, alerts[0])
+		}
+	}
+}
+
+func TestErrorRateFiresOncePerWindow(t *testing.T) {
+	rule := configs.AlertRule{Name: "errors", Type: ErrorRate, Window: "5m", Threshold: 0.1}
+	e := newEvaluator(t, configs.AlertsConfig{Rules: []configs.AlertRule{rule}})
+
+	if got := e.evaluate("api", rows(t0, 2, 2, "x"), t0); len(got) != 1 {
+		t.Fatalf("first run fired %d alerts, want 1", len(got))
+	}
+	// More errors in the same window, past any cooldown.
+	if got := e.evaluate("api", rows(t0.Add(time.Minute), 2, 2, "x"), t0.Add(time.Hour)); len(got) != 0 {
+		t.Errorf("same window fired again: %+v", got)
+	}
+	// Rows across a window boundary: only the new window fires.
+	got := e.evaluate("api", rows(t0.Add(5*time.Minute-time.Second), 3, 3, "x"), t0.Add(2*time.Hour))
+	if len(got) != 1 || !got[0].WindowStart.Equal(t0.Add(5*time.Minute)) {
+		t.Errorf("boundary run fired %+v, want one alert for the next window", got)
+	}
+}
+
+func TestPatternCooldown(t *testing.T) {
+	rule := configs.AlertRule{Name: "oom", Type: Pattern, Window: "1m", Pattern: "out of memory", Count: 2}
+	e := newEvaluator(t, configs.AlertsConfig{Cooldown: "30m", Rules: []configs.AlertRule{rule}})
+
+	if got := e.evaluate("api", rows(t0, 1, 0, "fatal: out of memory"), t0); len(got) != 0 {
+		t.Fatalf("fired below count: %+v", got)
+	}
+	if got := e.evaluate("api", rows(t0.Add(10*time.Second), 1, 0, "fatal: out of memory"), t0); len(got) != 1 || got[0].Value != 2 {
+		t.Fatalf("fired %+v, want one alert with value 2", got)
+	}
+	// A later window meets the count within the cooldown.
+	if got := e.evaluate("api", rows(t0.Add(5*time.Minute), 2, 0, "out of memory"), t0.Add(10*time.Minute)); len(got) != 0 {
+		t.Errorf("fired within cooldown: %+v", got)
+	}
+	// Other services have their own cooldown.
+	if got := e.evaluate("web", rows(t0.Add(5*time.Minute), 2, 0, "out of memory"), t0.Add(10*time.Minute)); len(got) != 1 {
+		t.Errorf("web fired %d alerts, want 1", len(got))
+	}
+	if got := e.evaluate("api", rows(t0.Add(40*time.Minute), 2, 0, "out of memory"), t0.Add(40*time.Minute)); len(got) != 1 {
+		t.Errorf("fired %d alerts after the cooldown, want 1", len(got))
+	}
+	if got := e.evaluate("api", rows(t0.Add(50*time.Minute), 2, 0, "all good"), t0.Add(2*time.Hour)); len(got) != 0 {
+		t.Errorf("fired without a match: %+v", got)
+	}
+}
+
+func TestNewFingerprint(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "alerts.json")
+	cfg := configs.AlertsConfig{StateFile: stateFile, Rules: []configs.AlertRule{{Name: "new-errors", Type: NewFingerprint}}}
+	group := func(id string) bigquery.BQLogRow {
+		return bigquery.BQLogRow{
+			Timestamp:     t0,
+			TextPayload:   "Traceback ...",
+			ErrorGroupID:  bq.NullString{StringVal: id, Valid: true},
+			ExceptionType: bq.NullString{StringVal: "ValueError", Valid: true},
+		}
+	}
+
+	e := newEvaluator(t, cfg)
+	got := e.evaluate("api", []bigquery.BQLogRow{group("a"), group("a"), {Timestamp: t0, TextPayload: "ok"}}, t0)
+	if len(got) != 1 || got[0].ErrorGroupID != "a" || got[0].ExceptionType != "ValueError" {
+		t.Fatalf("fired %+v, want one alert for group a", got)
+	}
+
+	// Seen fingerprints survive a restart through the state file.
+	e = newEvaluator(t, cfg)
+	got = e.evaluate("api", []bigquery.BQLogRow{group("a"), group("b")}, t0.Add(time.Hour))
+	if len(got) != 1 || got[0].ErrorGroupID != "b" {
+		t.Errorf("fired %+v, want one alert for group b", got)
+	}
+	if got := e.evaluate("web", []bigquery.BQLogRow{group("a")}, t0.Add(time.Hour)); len(got) != 1 {
+		t.Errorf("group a of another service fired %d alerts, want 1", len(got))
+	}
+}
@@ -0,0 +1,126 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/phaserunner03/logging/configs"
+)
+
+// Notifier delivers alerts to an output.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// NewNotifier returns the notifier described by cfg.
+func NewNotifier(cfg configs.NotifierConfig) (Notifier, error) {
+	switch cfg.Type {
+	case "webhook":
+		return &webhook{url: cfg.URL, body: json.Marshal}, nil
+	case "slack":
+		return &webhook{url: cfg.URL, body: slackMessage}, nil
+	case "stdout":
+		return &stdout{w: os.Stdout}, nil
+	case "file":
+		return &file{path: cfg.Path}, nil
+	}
+	return nil, fmt.Errorf("unknown notifier type %q", cfg.Type)
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhook posts every alert as JSON to a URL.
+type webhook struct {
+	url  string
+	body func(any) ([]byte, error)
+}
+
+func (w *webhook) Name() string { return "webhook " + w.url }
+
+func (w *webhook) Notify(ctx context.Context, alert Alert) error {
+	data, err := w.body(alert)
+	if err != nil {
+		return fmt.Errorf("failed to encode alert: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// slackMessage formats an alert for Slack-compatible incoming webhooks.
+func slackMessage(v any) ([]byte, error) {
+	alert := v.(Alert)
+	text := fmt.Sprintf(":rotating_light: *%s* (%s)\n%s", alert.Rule, alert.Type, alert.Summary)
+	if alert.Sample != "" {
+		text += "\n```" + truncate(alert.Sample, 300) + "```"
+	}
+	return json.Marshal(map[string]string{"text": text})
+}
+
+// stdout writes alerts as JSON lines to standard output, apart from the
+// exporter's own logs on stderr.
+type stdout struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *stdout) Name() string { return "stdout" }
+
+func (s *stdout) Notify(_ context.Context, alert Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to encode alert: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+// file appends alerts as JSON lines to a local file.
+type file struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (f *file) Name() string { return "file " + f.path }
+
+func (f *file) Notify(_ context.Context, alert Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to encode alert: %v", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(append(data, '\n')); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
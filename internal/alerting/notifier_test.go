@@ -0,0 +1,141 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/phaserunner03/logging/configs"
+)
+
+var testAlert = Alert{
+	Rule:    "api-errors",
+	Type:    ErrorRate,
+	Service: "api",
+	Summary: "api: error rate 75.0% exceeds 50.0%",
+	Sample:  "boom",
+	FiredAt: t0,
+}
+
+// receiver records the requests posted to it and answers with status.
+func receiver(t *testing.T, status int) (*httptest.Server, *[][]byte) {
+	t.Helper()
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %s with Content-Type %q, want a JSON POST", r.Method, r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, body)
+		w.WriteHeader(status)
+		io.WriteString(w, "nope\n")
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &bodies
+}
+
+func TestWebhook(t *testing.T) {
+	srv, bodies := receiver(t, http.StatusNoContent)
+	n, err := NewNotifier(configs.NotifierConfig{Type: "webhook", URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), testAlert); err != nil {
+		t.Fatal(err)
+	}
+	if len(*bodies) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*bodies))
+	}
+	var got Alert
+	if err := json.Unmarshal((*bodies)[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != testAlert {
+		t.Errorf("posted %+v, want %+v", got, testAlert)
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	srv, _ := receiver(t, http.StatusBadGateway)
+	n, err := NewNotifier(configs.NotifierConfig{Type: "webhook", URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n.Notify(context.Background(), testAlert)
+	if err == nil || !strings.Contains(err.Error(), "502") || !strings.Contains(err.Error(), "nope") {
+		t.Errorf("Notify = %v, want an error with the status and body", err)
+	}
+}
+
+func TestSlack(t *testing.T) {
+	srv, bodies := receiver(t, http.StatusOK)
+	n, err := NewNotifier(configs.NotifierConfig{Type: "slack", URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), testAlert); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]string
+	if err := json.Unmarshal((*bodies)[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	want := ":rotating_light: *api-errors* (error_rate)\napi: error rate 75.0% exceeds 50.0%\n```boom```"
+	if len(got) != 1 || got["text"] != want {
+		t.Errorf("posted %q, want text %q", got, want)
+	}
+}
+
+func TestStdout(t *testing.T) {
+	var buf bytes.Buffer
+	n := &stdout{w: &buf}
+	for range 2 {
+		if err := n.Notify(context.Background(), testAlert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertLines(t, buf.Bytes(), 2)
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.jsonl")
+	n, err := NewNotifier(configs.NotifierConfig{Type: "file", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := n.Notify(context.Background(), testAlert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertLines(t, data, 2)
+}
+
+// assertLines checks that data holds n JSON lines, each testAlert.
+func assertLines(t *testing.T, data []byte, n int) {
+	t.Helper()
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != n {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), n, data)
+	}
+	for _, line := range lines {
+		var got Alert
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		if got != testAlert {
+			t.Errorf("line decodes to %+v, want %+v", got, testAlert)
+		}
+	}
+}
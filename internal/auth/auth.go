@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/phaserunner03/logging/configs"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// OAuth scopes requested for each side of the pipeline.
+const (
+	LoggingReadScope = "https://www.googleapis.com/auth/logging.read"
+	BigQueryScope    = "https://www.googleapis.com/auth/bigquery"
+	TraceReadScope   = "https://www.googleapis.com/auth/trace.readonly"
+
+	// CloudPlatformScope is requested for the source credentials of an
+	// impersonation, which the IAM Credentials API requires.
+	CloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+// ClientOptions returns the client options authenticating as id with the given scopes.
+//
+// Without a credentials file, Application Default Credentials are looked up
+// eagerly so a missing identity is reported before any API call. A credentials
+// file may be a service account key, an authorized user or an external account
+// (workload identity federation) configuration. When impersonating, scopes
+// only apply to the impersonated token; the base credentials get the
+// cloud-platform scope.
+func ClientOptions(ctx context.Context, id configs.IdentityConfig, scopes ...string) ([]option.ClientOption, error) {
+	baseScopes := scopes
+	if id.ImpersonateServiceAccount != "" {
+		baseScopes = []string{CloudPlatformScope}
+	}
+
+	base := []option.ClientOption{option.WithScopes(baseScopes...)}
+	if id.Credentials != "" {
+		base = append(base, option.WithCredentialsFile(id.Credentials))
+	} else {
+		creds, err := google.FindDefaultCredentials(ctx, baseScopes...)
+		if err != nil {
+			return nil, fmt.Errorf("no credentials file configured and Application Default Credentials are unavailable "+
+				"(run `gcloud auth application-default login` or set GOOGLE_APPLICATION_CREDENTIALS): %v", err)
+		}
+		base = append(base, option.WithCredentials(creds))
+	}
+
+	if id.ImpersonateServiceAccount == "" {
+		return base, nil
+	}
+
+	ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: id.ImpersonateServiceAccount,
+		Scopes:          scopes,
+		Delegates:       id.Delegates,
+	}, base...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to impersonate %s: %v", id.ImpersonateServiceAccount, err)
+	}
+	return []option.ClientOption{option.WithTokenSource(ts)}, nil
+}
+
+// GRPCEmulatorOptions connect a gRPC client to an emulator at host:port
+// without authentication or TLS.
+func GRPCEmulatorOptions(host string) []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(host),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	}
+}
+
+// HTTPEmulatorOptions connect a REST client to an emulator at host:port
+// without authentication or TLS.
+func HTTPEmulatorOptions(host string) []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint("http://" + host + "/"),
+		option.WithoutAuthentication(),
+	}
+}
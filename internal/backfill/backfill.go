@@ -0,0 +1,230 @@
+// Package backfill plans the export of a historical range as per-service,
+// per-day units and runs them in parallel, recording each unit's status in a
+// state file so an interrupted backfill resumes without redoing finished days.
+package backfill
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Unit statuses.
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// DayLayout is the format of Unit.Day and of the --from and --to flags.
+const DayLayout = "2006-01-02"
+
+// Unit is the export of one service over one UTC day.
+type Unit struct {
+	Service  string    `json:"service"`
+	Day      string    `json:"day"`
+	Status   string    `json:"status"`
+	Rows     int       `json:"rows,omitempty"`
+	Attempts int       `json:"attempts,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Finished time.Time `json:"finished,omitzero"`
+}
+
+// Window returns the unit's export window, from midnight to the last
+// nanosecond of the day, so adjacent days never share an entry.
+func (u *Unit) Window() (start, end string) {
+	day, _ := time.Parse(DayLayout, u.Day)
+	return day.Format(time.RFC3339Nano), day.Add(24*time.Hour - time.Nanosecond).Format(time.RFC3339Nano)
+}
+
+// State is a backfill plan and the progress of its units, as persisted in
+// the state file.
+type State struct {
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Services []string  `json:"services"`
+	Created  time.Time `json:"created"`
+	Units    []*Unit   `json:"units"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// Plan returns a state with one pending unit per service and day from from
+// to to inclusive, to be saved at path.
+func Plan(path string, services []string, from, to time.Time) (*State, error) {
+	from, to = from.UTC().Truncate(24*time.Hour), to.UTC().Truncate(24*time.Hour)
+	if to.Before(from) {
+		return nil, fmt.Errorf("backfill end %s is before start %s", to.Format(DayLayout), from.Format(DayLayout))
+	}
+	if len(services) == 0 {
+		return nil, fmt.Errorf("no services to backfill")
+	}
+	s := &State{
+		From:     from.Format(DayLayout),
+		To:       to.Format(DayLayout),
+		Services: slices.Clone(services),
+		Created:  time.Now().UTC(),
+		path:     path,
+	}
+	// Newest days first, so recent history is available soonest.
+	for day := to; !day.Before(from); day = day.AddDate(0, 0, -1) {
+		for _, service := range services {
+			s.Units = append(s.Units, &Unit{Service: service, Day: day.Format(DayLayout), Status: StatusPending})
+		}
+	}
+	return s, nil
+}
+
+// Load reads the state file at path. The error wraps os.ErrNotExist when
+// there is none.
+func Load(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backfill state: %w", err)
+	}
+	s := &State{path: path}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse backfill state %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// SamePlan reports whether s and other cover the same days and services.
+func (s *State) SamePlan(other *State) bool {
+	return s.From == other.From && s.To == other.To && slices.Equal(s.Services, other.Services)
+}
+
+// Counts returns the number of units in each status.
+func (s *State) Counts() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]int)
+	for _, u := range s.Units {
+		counts[u.Status]++
+	}
+	return counts
+}
+
+// Save writes the state file atomically.
+func (s *State) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+// save writes the state file. s.mu must be held.
+func (s *State) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to save backfill state: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to save backfill state: %v", err)
+	}
+	return nil
+}
+
+// ExportFunc exports one service over a window and returns the rows written.
+// retry is set when an earlier attempt of the unit was started, which may have
+// written part of its rows, so the export must not duplicate them.
+type ExportFunc func(ctx context.Context, service, start, end string, retry bool) (int, error)
+
+// Run exports every unit that is not done with up to workers units in
+// parallel, saving the state after each one. Units interrupted by ctx are
+// left pending for the next run; failed units are retried by the next run.
+func (s *State) Run(ctx context.Context, workers int, export ExportFunc, progress *Progress) error {
+	var todo []*Unit
+	s.mu.Lock()
+	for _, u := range s.Units {
+		if u.Status != StatusDone {
+			u.Status = StatusPending
+			todo = append(todo, u)
+		}
+	}
+	s.mu.Unlock()
+
+	units := make(chan *Unit)
+	var wg sync.WaitGroup
+	var saveErr error
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range units {
+				if err := s.runUnit(ctx, u, export, progress); err != nil {
+					s.mu.Lock()
+					saveErr = cmp.Or(saveErr, err)
+					s.mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, u := range todo {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case units <- u:
+		case <-ctx.Done():
+		}
+	}
+	close(units)
+	wg.Wait()
+
+	if saveErr != nil {
+		return saveErr
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("backfill interrupted: %v", ctx.Err())
+	}
+	if failed := s.Counts()[StatusFailed]; failed > 0 {
+		return fmt.Errorf("%d of %d units failed", failed, len(s.Units))
+	}
+	return nil
+}
+
+// runUnit exports u and records the outcome. It returns only errors saving
+// the state.
+func (s *State) runUnit(ctx context.Context, u *Unit, export ExportFunc, progress *Progress) error {
+	s.mu.Lock()
+	u.Status = StatusRunning
+	retry := u.Attempts > 0
+	u.Attempts++
+	err := s.save()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	start, end := u.Window()
+	rows, exportErr := export(ctx, u.Service, start, end, retry)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case exportErr != nil && ctx.Err() != nil:
+		u.Status = StatusPending
+	case exportErr != nil:
+		u.Status = StatusFailed
+		u.Error = exportErr.Error()
+		progress.Add(0, true)
+	default:
+		u.Status = StatusDone
+		u.Rows = rows
+		u.Error = ""
+		u.Finished = time.Now().UTC()
+		progress.Add(rows, false)
+	}
+	return s.save()
+}
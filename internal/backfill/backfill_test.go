@@ -0,0 +1,96 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPlan(t *testing.T) {
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	s, err := Plan("state.json", []string{"api", "worker"}, from, from.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Units) != 6 {
+		t.Fatalf("Plan made %d units, want 6", len(s.Units))
+	}
+	if u := s.Units[0]; u.Day != "2024-05-03" || u.Service != "api" {
+		t.Errorf("first unit is %s/%s, want api/2024-05-03", u.Service, u.Day)
+	}
+	start, end := s.Units[len(s.Units)-1].Window()
+	if start != "2024-05-01T00:00:00Z" || end != "2024-05-01T23:59:59.999999999Z" {
+		t.Errorf("Window() = %s, %s", start, end)
+	}
+
+	if _, err := Plan("state.json", []string{"api"}, from, from.AddDate(0, 0, -1)); err == nil {
+		t.Error("Plan accepted an end before the start")
+	}
+}
+
+func TestRunResumes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	s, err := Plan(path, []string{"api", "worker"}, from, from.AddDate(0, 0, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first run fails one unit and is interrupted after four exports.
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	retries := make(map[string]bool)
+	ctx, cancel := context.WithCancel(context.Background())
+	export := func(ctx context.Context, service, start, end string, retry bool) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[service+start]++
+		if retry {
+			retries[service+start] = true
+		}
+		if service == "worker" && start == "2024-05-05T00:00:00Z" && calls[service+start] == 1 {
+			return 0, errors.New("boom")
+		}
+		if len(calls) == 4 {
+			cancel()
+			return 0, ctx.Err()
+		}
+		return 10, nil
+	}
+	if err := s.Run(ctx, 1, export, NewProgress(io.Discard, len(s.Units), 0)); err == nil {
+		t.Fatal("interrupted Run returned nil")
+	}
+
+	resumed, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := resumed.Counts()
+	if counts[StatusDone] != 2 || counts[StatusFailed] != 1 || counts[StatusPending] != 7 {
+		t.Errorf("after interruption: %v, want 2 done, 1 failed, 7 pending", counts)
+	}
+
+	if err := resumed.Run(context.Background(), 3, export, nil); err != nil {
+		t.Fatalf("resumed Run: %v", err)
+	}
+	if counts := resumed.Counts(); counts[StatusDone] != 10 {
+		t.Errorf("after resume: %v, want 10 done", counts)
+	}
+	for key, n := range calls {
+		// Only the failed and the interrupted unit are exported twice, and
+		// only their second export is a retry.
+		if n > 1 && key != "worker2024-05-05T00:00:00Z" && key != "worker2024-05-04T00:00:00Z" {
+			t.Errorf("%s exported %d times", key, n)
+		}
+		if retries[key] != (n > 1) {
+			t.Errorf("%s exported %d times, retry = %v", key, n, retries[key])
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package backfill
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// barWidth is the number of characters in the progress bar.
+const barWidth = 30
+
+// Progress draws a single-line progress bar with an ETA based on the units
+// finished in this run. It is safe for concurrent use; a nil Progress draws
+// nothing.
+type Progress struct {
+	w     io.Writer
+	total int
+	start time.Time
+
+	mu      sync.Mutex
+	skipped int // units already done when the run started
+	done    int
+	failed  int
+	rows    int
+}
+
+// NewProgress returns a progress bar for total units of which done were
+// finished by earlier runs.
+func NewProgress(w io.Writer, total, done int) *Progress {
+	p := &Progress{w: w, total: total, skipped: done, start: time.Now()}
+	p.draw()
+	return p
+}
+
+// Add records a finished unit that wrote rows rows, or failed.
+func (p *Progress) Add(rows int, failed bool) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if failed {
+		p.failed++
+	} else {
+		p.done++
+		p.rows += rows
+	}
+	p.draw()
+}
+
+// Finish ends the progress line.
+func (p *Progress) Finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.draw()
+	fmt.Fprintln(p.w)
+}
+
+// draw redraws the line. p.mu must be held.
+func (p *Progress) draw() {
+	finished := p.skipped + p.done + p.failed
+	fraction := 1.0
+	if p.total > 0 {
+		fraction = float64(finished) / float64(p.total)
+	}
+	filled := int(fraction * barWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+
+	elapsed := time.Since(p.start)
+	eta := "--"
+	if ran := p.done + p.failed; ran > 0 {
+		remaining := p.total - finished
+		eta = (elapsed / time.Duration(ran) * time.Duration(remaining)).Round(time.Second).String()
+	}
+	fmt.Fprintf(p.w, "\r[%s] %d/%d units %3.0f%%  %d rows  %d failed  elapsed %s  ETA %s ",
+		bar, finished, p.total, fraction*100, p.rows, p.failed, elapsed.Round(time.Second), eta)
+}
@@ -2,12 +2,21 @@ package bigquery
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"time"
-	"github.com/phaserunner03/logging/configs"
+
 	"cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/auth"
+	"github.com/phaserunner03/logging/internal/logger"
+	"github.com/phaserunner03/logging/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 )
 
 type BQLogRow struct {
@@ -16,6 +25,7 @@ type BQLogRow struct {
 	LogName        string    `bigquery:"log_name"`        // NULLABLE
 	TextPayload    string    `bigquery:"text_payload"`    // NULLABLE
 	JsonPayload    string    `bigquery:"json_payload"`    // NULLABLE (JSON type in BigQuery)
+	ProtoPayload   string    `bigquery:"proto_payload"`   // NULLABLE (JSON type, e.g. audit logs)
 	InsertID       string    `bigquery:"insert_id"`       // NULLABLE
 	ResourceType   string    `bigquery:"resource_type"`   // NULLABLE
 	ResourceLabels string    `bigquery:"resource_labels"` // NULLABLE (JSON type)
@@ -25,38 +35,231 @@ type BQLogRow struct {
 	SourceLocation string    `bigquery:"source_location"` // NULLABLE (JSON type)
 	Labels         string    `bigquery:"labels"`          // NULLABLE (JSON type)
 	ServiceName    string    `bigquery:"service_name"`    // NULLABLE // Added service name field
+	SourceProject  string    `bigquery:"source_project"`  // NULLABLE // Project the entry was read from
+
+	// Flattened from HTTPRequest so dashboards need no JSON parsing.
+	RequestMethod string               `bigquery:"request_method"` // NULLABLE
+	RequestURL    string               `bigquery:"request_url"`    // NULLABLE
+	RequestPath   string               `bigquery:"request_path"`   // NULLABLE
+	RequestQuery  string               `bigquery:"request_query"`  // NULLABLE
+	Status        bigquery.NullInt64   `bigquery:"status"`         // NULLABLE
+	LatencyMS     bigquery.NullFloat64 `bigquery:"latency_ms"`     // NULLABLE
+	ResponseSize  bigquery.NullInt64   `bigquery:"response_size"`  // NULLABLE
+	UserAgent     string               `bigquery:"user_agent"`     // NULLABLE
+	RemoteIP      string               `bigquery:"remote_ip"`      // NULLABLE
+	CacheHit      bigquery.NullBool    `bigquery:"cache_hit"`      // NULLABLE
+	Protocol      string               `bigquery:"protocol"`       // NULLABLE
+
+	// Trace correlation; span_name and span_duration_ms are only set when
+	// trace.enrich looks the span up in Cloud Trace.
+	TraceID        string               `bigquery:"trace_id"`         // NULLABLE
+	TraceSampled   bool                 `bigquery:"trace_sampled"`    // NULLABLE
+	SpanName       string               `bigquery:"span_name"`        // NULLABLE
+	SpanDurationMS bigquery.NullFloat64 `bigquery:"span_duration_ms"` // NULLABLE
+
+	// Set for entries holding a stack trace, see package errorgroup, and
+	// NULL otherwise so "IS NOT NULL" selects errors.
+	ErrorGroupID  bigquery.NullString `bigquery:"error_group_id"` // NULLABLE
+	ExceptionType bigquery.NullString `bigquery:"exception_type"` // NULLABLE
+}
+
+// maxBatchRows caps the rows sent in one insertAll request.
+const maxBatchRows = 500
+
+// Destination identifies a BigQuery table.
+type Destination struct {
+	ProjectID string
+	DatasetID string
+	TableID   string
+}
+
+func (d Destination) String() string {
+	return d.ProjectID + "." + d.DatasetID + "." + d.TableID
 }
 
-// InsertLogs inserts log entries into BigQuery
-func InsertLogs(ctx context.Context, rows []BQLogRow) error {
-	if len(rows) == 0 {
-		return nil
+// DefaultDestination is the table configured by gcp.project_id, bigquery.dataset_id and bigquery.table_id.
+func DefaultDestination(config *configs.Config) Destination {
+	return Destination{
+		ProjectID: config.GCP.ProjectID,
+		DatasetID: config.BigQuery.DatasetID,
+		TableID:   config.BigQuery.TableID,
+	}
+}
+
+// NewClient creates a BigQuery client authenticated as the write identity.
+// Jobs run in gcp.project_id; tables in other projects are addressed explicitly.
+func NewClient(ctx context.Context, config *configs.Config) (*bigquery.Client, error) {
+	var opts []option.ClientOption
+	if config.Emulator.BigQuery != "" {
+		opts = auth.HTTPEmulatorOptions(config.Emulator.BigQuery)
+	} else {
+		var err error
+		if opts, err = auth.ClientOptions(ctx, config.WriteIdentity(), auth.BigQueryScope); err != nil {
+			return nil, err
+		}
 	}
 
-	
-	config, err := configs.LoadConfig()
+	// Authenticate on top of retryCounter so it sees every attempt.
+	transport, err := htransport.NewTransport(ctx, retryCounter{base: http.DefaultTransport}, opts...)
 	if err != nil {
-		log.Fatalf("Error loading configuration: %v", err)
+		return nil, fmt.Errorf("failed to create BigQuery transport: %v", err)
 	}
-	projectID := config.Env.GCP_ProjectID
-	credentialsPath := config.Env.GCP_Credentials
-	datasetID := config.Env.BigQueryDatasetID
-	tableID := config.Env.BigQueryTableID
+	opts = append(opts, option.WithHTTPClient(&http.Client{Transport: transport}))
 
-	if projectID == "" || credentialsPath == "" || datasetID == "" || tableID == "" {
-		return fmt.Errorf("required environment variables are not set")
+	client, err := bigquery.NewClient(ctx, config.GCP.ProjectID, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create BigQuery client: %v", err)
+	}
+	return client, nil
+}
+
+// InsertLogs inserts log entries into the default destination table.
+func InsertLogs(ctx context.Context, config *configs.Config, rows []BQLogRow) error {
+	_, err := InsertBatches(ctx, config, map[Destination][]BQLogRow{DefaultDestination(config): rows})
+	return err
+}
+
+// InsertBatches inserts each batch of rows into its destination table after
+// applying the configured table layout, creating missing tables when enabled.
+// Every destination is attempted; it returns the number of rows written, which
+// is less than the number of rows given when some fail, and an error joining
+// the failures.
+func InsertBatches(ctx context.Context, config *configs.Config, batches map[Destination][]BQLogRow) (int, error) {
+	batches = ApplyLayout(config, batches)
+
+	var total int
+	for _, rows := range batches {
+		total += len(rows)
+	}
+	if total == 0 {
+		return 0, nil
 	}
 
-	client, err := bigquery.NewClient(ctx, projectID, option.WithCredentialsFile(credentialsPath))
+	ctx, span := telemetry.Tracer().Start(ctx, "InsertLogs")
+	defer span.End()
+	span.SetAttributes(attribute.Int("rows", total), attribute.Int("destinations", len(batches)))
+
+	client, err := NewClient(ctx, config)
 	if err != nil {
-		return fmt.Errorf("failed to create BigQuery client: %v", err)
+		return 0, err
 	}
 	defer client.Close()
 
-	inserter := client.Dataset(datasetID).Table(tableID).Inserter()
-	if err := inserter.Put(ctx, rows); err != nil {
-		return fmt.Errorf("failed to insert rows: %v", err)
+	if config.BigQuery.AutoCreate || config.BigQuery.Layout == LayoutPerLogID {
+		schema, err := LoadSchema(config.BigQuery.Schema)
+		if err != nil {
+			return 0, err
+		}
+		dests := make([]Destination, 0, len(batches))
+		for dest := range batches {
+			dests = append(dests, dest)
+		}
+		if err := EnsureTables(ctx, client, config, schema, dests); err != nil {
+			return 0, err
+		}
+	}
+
+	var written int
+	var errs []error
+	for dest, rows := range batches {
+		n, err := insertRows(ctx, client, dest, rows)
+		written += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", dest, err))
+		}
+	}
+	span.SetAttributes(attribute.Int("written", written))
+	if err := errors.Join(errs...); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return written, err
+	}
+	return written, nil
+}
+
+// insertRows streams rows into dest in chunks of at most maxBatchRows,
+// stopping at the first chunk that fails. It returns the number of rows written.
+func insertRows(ctx context.Context, client *bigquery.Client, dest Destination, rows []BQLogRow) (int, error) {
+	inserter := client.DatasetInProject(dest.ProjectID, dest.DatasetID).Table(dest.TableID).Inserter()
+
+	var written int
+	for batch := 0; len(rows) > 0; batch++ {
+		chunk := rows[:min(len(rows), maxBatchRows)]
+		rows = rows[len(chunk):]
+
+		n, err := insertChunk(ctx, inserter, dest, chunk, batch)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// insertChunk streams one chunk of rows and returns the number of rows
+// written: all of them, none, or those a PutMultiError does not reject.
+func insertChunk(ctx context.Context, inserter *bigquery.Inserter, dest Destination, rows []BQLogRow, batch int) (int, error) {
+	ctx, span := telemetry.Tracer().Start(ctx, "InsertLogs.batch")
+	defer span.End()
+	service := batchService(rows)
+	span.SetAttributes(
+		attribute.String("table", dest.String()),
+		attribute.String("service", service),
+		attribute.Int("batch", batch),
+		attribute.Int("rows", len(rows)),
+	)
+
+	start := time.Now()
+	err := inserter.Put(countRetries(ctx, service, telemetry.StageInsert), rows)
+	telemetry.APILatency.WithLabelValues(service, telemetry.StageInsert).Observe(time.Since(start).Seconds())
+	written := recordInsertMetrics(rows, err)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return written, fmt.Errorf("failed to insert rows: %v", err)
 	}
 
-	return nil
+	slog.DebugContext(ctx, "Inserted rows into BigQuery",
+		logger.KeyService, service,
+		logger.KeyStage, telemetry.StageInsert,
+		logger.KeyBatch, batch,
+		"rows", len(rows),
+		"table", dest.String(),
+	)
+	return written, nil
+}
+
+// recordInsertMetrics counts inserted and rejected rows per service and
+// returns the number inserted. A PutMultiError only rejects the rows it
+// lists; any other error rejects the batch.
+func recordInsertMetrics(rows []BQLogRow, err error) int {
+	rejected := make(map[int]bool)
+	var multiErr bigquery.PutMultiError
+	if errors.As(err, &multiErr) {
+		for _, rowErr := range multiErr {
+			rejected[rowErr.RowIndex] = true
+		}
+	}
+
+	var inserted int
+	for i, row := range rows {
+		if err != nil && (multiErr == nil || rejected[i]) {
+			telemetry.RowsRejected.WithLabelValues(row.ServiceName, telemetry.StageInsert).Inc()
+			continue
+		}
+		telemetry.RowsInserted.WithLabelValues(row.ServiceName, telemetry.StageInsert).Inc()
+		inserted++
+	}
+	return inserted
+}
+
+// batchService returns the service shared by all rows, or "mixed".
+func batchService(rows []BQLogRow) string {
+	service := rows[0].ServiceName
+	for _, row := range rows[1:] {
+		if row.ServiceName != service {
+			return "mixed"
+		}
+	}
+	return service
 }
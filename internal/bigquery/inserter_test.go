@@ -0,0 +1,156 @@
+package bigquery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/fakes"
+	"github.com/phaserunner03/logging/internal/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func newFakeBigQuery(t *testing.T, table string) (*fakes.BigQuery, *configs.Config) {
+	t.Helper()
+	f := fakes.NewBigQuery()
+	t.Cleanup(f.Close)
+
+	config := &configs.Config{}
+	config.GCP.ProjectID = "test-project"
+	config.BigQuery.DatasetID = "logs"
+	// Tables known to exist are cached per process, so every test uses its own.
+	config.BigQuery.TableID = table
+	config.BigQuery.Schema = "../../schema.json"
+	config.BigQuery.Layout = LayoutSingle
+	config.BigQuery.Partitioning = Partitioned
+	config.Emulator.BigQuery = f.Host()
+	return f, config
+}
+
+func testRows(n int) []BQLogRow {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	rows := make([]BQLogRow, n)
+	for i := range rows {
+		rows[i] = BQLogRow{
+			Timestamp:   start.Add(time.Duration(i) * time.Second),
+			Severity:    "INFO",
+			LogName:     "projects/test-project/logs/run.googleapis.com%2Frequests",
+			InsertID:    fmt.Sprintf("id-%d", i),
+			JsonPayload: "null",
+			ServiceName: "api",
+			Status:      bq.NullInt64{Int64: 200, Valid: true},
+		}
+	}
+	return rows
+}
+
+func TestInsertLogsCreatesTableAndChunks(t *testing.T) {
+	f, config := newFakeBigQuery(t, "insert_chunks")
+	config.BigQuery.AutoCreate = true
+
+	if err := InsertLogs(context.Background(), config, testRows(1200)); err != nil {
+		t.Fatalf("InsertLogs: %v", err)
+	}
+
+	meta := f.Table("test-project", "logs", "insert_chunks")
+	if meta == nil {
+		t.Fatal("table was not created")
+	}
+	if meta.TimePartitioning == nil || meta.TimePartitioning.Field != "timestamp" {
+		t.Errorf("table partitioning = %+v, want daily on timestamp", meta.TimePartitioning)
+	}
+	if got := f.InsertCalls(); got != 3 {
+		t.Errorf("insertAll calls = %d, want 3 chunks of at most %d rows", got, maxBatchRows)
+	}
+
+	rows := f.Rows("test-project", "logs", "insert_chunks")
+	if len(rows) != 1200 {
+		t.Fatalf("table has %d rows, want 1200", len(rows))
+	}
+	if got := rows[0]["insert_id"]; got != "id-0" {
+		t.Errorf("insert_id = %v, want id-0", got)
+	}
+	if got := rows[0]["status"]; got != float64(200) {
+		t.Errorf("status = %v (%T), want 200", got, got)
+	}
+}
+
+func TestInsertLogsPerLogIDLayout(t *testing.T) {
+	f, config := newFakeBigQuery(t, "unused")
+	config.BigQuery.Layout = LayoutPerLogID
+	config.BigQuery.Partitioning = Sharded
+
+	if err := InsertLogs(context.Background(), config, testRows(3)); err != nil {
+		t.Fatalf("InsertLogs: %v", err)
+	}
+	if got := len(f.Rows("test-project", "logs", "run_googleapis_com_requests_20240501")); got != 3 {
+		t.Errorf("sharded table has %d rows, want 3 (tables: %v)", got, f.Tables())
+	}
+}
+
+func TestInsertLogsReportsFailures(t *testing.T) {
+	f, config := newFakeBigQuery(t, "insert_failure")
+	f.CreateTable("test-project", "logs", "insert_failure", nil)
+	f.FailInserts(http.StatusForbidden)
+
+	if err := InsertLogs(context.Background(), config, testRows(10)); err == nil {
+		t.Fatal("InsertLogs succeeded, want error")
+	}
+}
+
+func TestInsertLogsMissingTable(t *testing.T) {
+	_, config := newFakeBigQuery(t, "missing")
+
+	if err := InsertLogs(context.Background(), config, testRows(1)); err == nil {
+		t.Fatal("InsertLogs succeeded without a table or auto_create")
+	}
+}
+
+func TestInsertBatchesReportsWrittenRows(t *testing.T) {
+	f, config := newFakeBigQuery(t, "partial")
+	f.CreateTable("test-project", "logs", "partial", nil)
+	batches := map[Destination][]BQLogRow{
+		{ProjectID: "test-project", DatasetID: "logs", TableID: "partial"}:         testRows(3),
+		{ProjectID: "test-project", DatasetID: "logs", TableID: "partial_missing"}: testRows(2),
+	}
+
+	written, err := InsertBatches(context.Background(), config, batches)
+	if err == nil {
+		t.Fatal("InsertBatches succeeded with a missing table")
+	}
+	if written != 3 {
+		t.Errorf("InsertBatches wrote %d rows, want 3", written)
+	}
+}
+
+func TestInsertLogsCountsRetries(t *testing.T) {
+	f, config := newFakeBigQuery(t, "insert_retry")
+	f.CreateTable("test-project", "logs", "insert_retry", nil)
+	f.FailInserts(http.StatusServiceUnavailable)
+
+	retries := telemetry.APIRetries.WithLabelValues("api", telemetry.StageInsert)
+	before := counterValue(t, retries)
+	if err := InsertLogs(context.Background(), config, testRows(2)); err != nil {
+		t.Fatalf("InsertLogs: %v", err)
+	}
+	if got := f.InsertCalls(); got != 2 {
+		t.Errorf("insertAll calls = %d, want 2", got)
+	}
+	if got := counterValue(t, retries) - before; got != 1 {
+		t.Errorf("counted %v retries, want 1", got)
+	}
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
@@ -0,0 +1,132 @@
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/configs"
+	"google.golang.org/api/googleapi"
+)
+
+// Table layouts and partitioning modes, see configs.BigQueryConfig.
+const (
+	LayoutSingle   = "single"
+	LayoutPerLogID = "per_log_id"
+
+	Partitioned = "partitioned"
+	Sharded     = "sharded"
+)
+
+// LogID returns the URL-decoded log ID of a log name, e.g.
+// "run.googleapis.com/requests" for "projects/p/logs/run.googleapis.com%2Frequests".
+func LogID(logName string) string {
+	if i := strings.LastIndex(logName, "/logs/"); i >= 0 {
+		logName = logName[i+len("/logs/"):]
+	}
+	if decoded, err := url.PathUnescape(logName); err == nil {
+		return decoded
+	}
+	return logName
+}
+
+// LogTableName returns the table Cloud Logging's BigQuery sink uses for a log:
+// the log ID with every character other than letters, digits and underscores
+// replaced by "_", e.g. "projects/p/logs/run.googleapis.com%2Frequests"
+// becomes "run_googleapis_com_requests".
+func LogTableName(logName string) string {
+	logID := LogID(logName)
+	if logID == "" {
+		return "unknown_log"
+	}
+
+	var b strings.Builder
+	for _, r := range logID {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// ApplyLayout rewrites the destination tables of batches according to
+// bigquery.layout. With the per_log_id layout rows keep their routed project
+// and dataset but are split into one table per log ID, suffixed with the
+// row's UTC date when tables are sharded.
+func ApplyLayout(config *configs.Config, batches map[Destination][]BQLogRow) map[Destination][]BQLogRow {
+	if config.BigQuery.Layout != LayoutPerLogID {
+		return batches
+	}
+
+	out := make(map[Destination][]BQLogRow)
+	for dest, rows := range batches {
+		for _, row := range rows {
+			d := dest
+			d.TableID = LogTableName(row.LogName)
+			if config.BigQuery.Partitioning == Sharded {
+				d.TableID += "_" + row.Timestamp.UTC().Format("20060102")
+			}
+			out[d] = append(out[d], row)
+		}
+	}
+	return out
+}
+
+// createdTables remembers tables known to exist so each is checked once per process.
+var createdTables sync.Map
+
+// EnsureTables creates every destination table that does not exist yet, using
+// schema and daily partitioning on timestamp unless tables are sharded.
+func EnsureTables(ctx context.Context, client *bigquery.Client, config *configs.Config, schema bigquery.Schema, dests []Destination) error {
+	for _, dest := range dests {
+		meta := &bigquery.TableMetadata{Schema: schema}
+		if config.BigQuery.Partitioning != Sharded {
+			meta.TimePartitioning = &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: "timestamp"}
+		}
+		if err := ensureTable(ctx, client, dest, meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureTable creates dest with meta unless it already exists.
+func ensureTable(ctx context.Context, client *bigquery.Client, dest Destination, meta *bigquery.TableMetadata) error {
+	if _, ok := createdTables.Load(dest); ok {
+		return nil
+	}
+
+	table := client.DatasetInProject(dest.ProjectID, dest.DatasetID).Table(dest.TableID)
+	_, err := table.Metadata(ctx)
+	if isNotFound(err) {
+		err = table.Create(ctx, meta)
+		if isAlreadyExists(err) {
+			err = nil
+		} else if err == nil {
+			slog.InfoContext(ctx, "Created BigQuery table", "table", dest.String(), "partitioned", meta.TimePartitioning != nil)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to ensure table %s: %v", dest, err)
+	}
+	createdTables.Store(dest, true)
+	return nil
+}
+
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+func isAlreadyExists(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict
+}
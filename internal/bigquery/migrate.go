@@ -0,0 +1,111 @@
+package bigquery
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/configs"
+	"google.golang.org/api/iterator"
+)
+
+// MigrateSchema adds the columns of schema missing from the existing table
+// dest and returns their names. BigQuery only allows appending NULLABLE or
+// REPEATED columns, so a missing REQUIRED column is an error. Tables that do
+// not exist yet are skipped; they get the full schema when created.
+func MigrateSchema(ctx context.Context, client *bigquery.Client, dest Destination, schema bigquery.Schema) ([]string, error) {
+	table := client.DatasetInProject(dest.ProjectID, dest.DatasetID).Table(dest.TableID)
+	meta, err := table.Metadata(ctx)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read table %s: %v", dest, err)
+	}
+
+	existing := make(map[string]bool, len(meta.Schema))
+	for _, col := range meta.Schema {
+		existing[col.Name] = true
+	}
+
+	updated := append(bigquery.Schema{}, meta.Schema...)
+	var added []string
+	for _, col := range schema {
+		if existing[col.Name] {
+			continue
+		}
+		if col.Required {
+			return nil, fmt.Errorf("table %s: cannot add REQUIRED column %s to an existing table", dest, col.Name)
+		}
+		updated = append(updated, col)
+		added = append(added, col.Name)
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	if _, err := table.Update(ctx, bigquery.TableMetadataToUpdate{Schema: updated}, meta.ETag); err != nil {
+		return nil, fmt.Errorf("failed to update schema of %s: %v", dest, err)
+	}
+	slog.InfoContext(ctx, "Migrated BigQuery table schema", "table", dest.String(), "added", added)
+	return added, nil
+}
+
+// LogTables lists the tables of dataset dest that hold exported log rows,
+// recognised by their insert_id column, skipping replace staging tables. It
+// is used to find the tables created by the per_log_id layout.
+func LogTables(ctx context.Context, client *bigquery.Client, dest Destination) ([]Destination, error) {
+	var tables []Destination
+	it := client.DatasetInProject(dest.ProjectID, dest.DatasetID).Tables(ctx)
+	for {
+		t, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tables of %s.%s: %v", dest.ProjectID, dest.DatasetID, err)
+		}
+		if strings.Contains(t.TableID, stagingInfix) {
+			continue
+		}
+		meta, err := t.Metadata(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read table %s: %v", t.FullyQualifiedName(), err)
+		}
+		for _, col := range meta.Schema {
+			if col.Name == "insert_id" {
+				tables = append(tables, Destination{ProjectID: dest.ProjectID, DatasetID: dest.DatasetID, TableID: t.TableID})
+				break
+			}
+		}
+	}
+	return tables, nil
+}
+
+// ExportTables returns the tables rows routed to dests end up in: dests
+// themselves, or with the per_log_id layout every log table of their datasets.
+func ExportTables(ctx context.Context, client *bigquery.Client, config *configs.Config, dests []Destination) ([]Destination, error) {
+	if config.BigQuery.Layout != LayoutPerLogID {
+		return dests, nil
+	}
+
+	// Per-log tables are named after log IDs, so find them by listing each
+	// routed dataset once.
+	seen := make(map[Destination]bool)
+	var tables []Destination
+	for _, dest := range dests {
+		dataset := Destination{ProjectID: dest.ProjectID, DatasetID: dest.DatasetID}
+		if seen[dataset] {
+			continue
+		}
+		seen[dataset] = true
+		found, err := LogTables(ctx, client, dataset)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, found...)
+	}
+	return tables, nil
+}
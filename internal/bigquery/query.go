@@ -0,0 +1,126 @@
+package bigquery
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/configs"
+)
+
+// Query runs a GoogleSQL query with named parameters (@name) and returns an
+// iterator over its results. Table references must be fully qualified. The
+// query fails rather than bill more than maxBytesBilled bytes, which is
+// usually config.MaxBytesBilled(); 0 means unlimited.
+func Query(ctx context.Context, client *bigquery.Client, sql string, params map[string]any, maxBytesBilled int64) (*bigquery.RowIterator, error) {
+	q := newQuery(client, sql, params)
+	q.MaxBytesBilled = maxBytesBilled
+	it, err := q.Read(ctx)
+	if err != nil {
+		if strings.Contains(err.Error(), "bytesBilledLimitExceeded") {
+			return nil, fmt.Errorf("query would bill more than budget.max_bytes_billed (%d bytes): %v", q.MaxBytesBilled, err)
+		}
+		return nil, fmt.Errorf("query failed: %v", err)
+	}
+	return it, nil
+}
+
+// EstimateBytes dry-runs a query and returns the bytes it would process.
+func EstimateBytes(ctx context.Context, client *bigquery.Client, sql string, params map[string]any) (int64, error) {
+	q := newQuery(client, sql, params)
+	q.DryRun = true
+	job, err := q.Run(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("query dry run failed: %v", err)
+	}
+	status := job.LastStatus()
+	if err := status.Err(); err != nil {
+		return 0, fmt.Errorf("query dry run failed: %v", err)
+	}
+	return status.Statistics.TotalBytesProcessed, nil
+}
+
+// CountRows counts the rows of service within [start, end] in the tables rows
+// routed to dests end up in. Tables that do not exist yet hold no rows.
+func CountRows(ctx context.Context, config *configs.Config, dests []Destination, service string, start, end time.Time) (int64, error) {
+	client, err := NewClient(ctx, config)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	tables, err := ExportTables(ctx, client, config, dests)
+	if err != nil {
+		return 0, err
+	}
+	if tables, err = existingTables(ctx, client, config, tables, start, end); err != nil {
+		return 0, err
+	}
+	if len(tables) == 0 {
+		return 0, nil
+	}
+
+	selects := make([]string, len(tables))
+	for i, table := range tables {
+		selects[i] = fmt.Sprintf("SELECT COUNT(*) AS n FROM %s WHERE service_name = @service AND timestamp BETWEEN @start AND @end", TableRef(table))
+	}
+	sql := "SELECT SUM(n) AS row_count FROM (\n" + strings.Join(selects, "\nUNION ALL\n") + "\n)"
+	it, err := Query(ctx, client, sql, map[string]any{"service": service, "start": start, "end": end}, config.MaxBytesBilled())
+	if err != nil {
+		return 0, err
+	}
+	var row struct {
+		RowCount bigquery.NullInt64 `bigquery:"row_count"`
+	}
+	if err := it.Next(&row); err != nil {
+		return 0, fmt.Errorf("failed to read row count: %v", err)
+	}
+	return row.RowCount.Int64, nil
+}
+
+func newQuery(client *bigquery.Client, sql string, params map[string]any) *bigquery.Query {
+	q := client.Query(sql)
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		q.Parameters = append(q.Parameters, bigquery.QueryParameter{Name: name, Value: params[name]})
+	}
+	return q
+}
+
+// SelectColumns returns a select list of the columns of schema that loads
+// into BQLogRow. Columns whose BQLogRow field cannot hold NULL, which rows
+// written before a migration added the column have, default to the empty
+// value.
+func SelectColumns(schema bigquery.Schema) string {
+	fields := rowFields(BQLogRow{})
+	columns := make([]string, len(schema))
+	for i, col := range schema {
+		columns[i] = col.Name
+		f, ok := fields[col.Name]
+		if !ok || col.Required {
+			continue
+		}
+		switch {
+		case col.Type == bigquery.JSONFieldType:
+			columns[i] = fmt.Sprintf("IFNULL(TO_JSON_STRING(%s), 'null') AS %s", col.Name, col.Name)
+		case f.Kind() == reflect.String:
+			columns[i] = fmt.Sprintf("IFNULL(%s, '') AS %s", col.Name, col.Name)
+		case f.Kind() == reflect.Bool:
+			columns[i] = fmt.Sprintf("IFNULL(%s, FALSE) AS %s", col.Name, col.Name)
+		}
+	}
+	return strings.Join(columns, ", ")
+}
+
+// TableRef returns the quoted GoogleSQL reference to dest.
+func TableRef(dest Destination) string {
+	return fmt.Sprintf("`%s.%s.%s`", dest.ProjectID, dest.DatasetID, dest.TableID)
+}
@@ -0,0 +1,321 @@
+package bigquery
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/api/iterator"
+)
+
+// stagingInfix marks the staging tables ReplaceBatches loads rows into; they
+// expire after stagingTTL should the process die before deleting them.
+const (
+	stagingInfix = "__staging_"
+	stagingTTL   = 6 * time.Hour
+)
+
+// timestampLayout formats TIMESTAMP values in load job JSON.
+const timestampLayout = "2006-01-02 15:04:05.999999-07:00"
+
+// tableDay is one UTC day of one destination table, the unit ReplaceBatches
+// swaps atomically.
+type tableDay struct {
+	dest Destination
+	day  time.Time
+}
+
+// ReplaceBatches makes batches the only rows of service within [start, end]
+// in BigQuery. For every table and UTC day holding old or new rows it loads
+// the new rows into a staging table, then deletes the service's rows of that
+// day within the window and inserts the staged rows in a single transaction,
+// so rewriting a window never duplicates rows. dests are the routed
+// destinations; their tables lose the service's old rows even when no new
+// rows go to them. It returns the number of new rows written by the days
+// that were swapped successfully.
+func ReplaceBatches(ctx context.Context, config *configs.Config, service string, start, end time.Time, dests []Destination, batches map[Destination][]BQLogRow) (int, error) {
+	batches = ApplyLayout(config, batches)
+
+	ctx, span := telemetry.Tracer().Start(ctx, "ReplaceLogs")
+	defer span.End()
+	span.SetAttributes(attribute.String("service", service))
+
+	client, err := NewClient(ctx, config)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	schema, err := LoadSchema(config.BigQuery.Schema)
+	if err != nil {
+		return 0, err
+	}
+	if config.BigQuery.AutoCreate || config.BigQuery.Layout == LayoutPerLogID {
+		created := make([]Destination, 0, len(batches))
+		for dest := range batches {
+			created = append(created, dest)
+		}
+		if err := EnsureTables(ctx, client, config, schema, created); err != nil {
+			return 0, err
+		}
+	}
+
+	tables, err := ExportTables(ctx, client, config, dests)
+	if err != nil {
+		return 0, err
+	}
+	if tables, err = existingTables(ctx, client, config, tables, start, end); err != nil {
+		return 0, err
+	}
+	work, err := daysWithRows(ctx, client, tables, service, start, end, config.MaxBytesBilled())
+	if err != nil {
+		return 0, err
+	}
+	for dest, rows := range batches {
+		for _, row := range rows {
+			k := tableDay{dest, row.Timestamp.UTC().Truncate(24 * time.Hour)}
+			work[k] = append(work[k], row)
+		}
+	}
+
+	keys := make([]tableDay, 0, len(work))
+	for k := range work {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b tableDay) int {
+		return cmp.Or(a.day.Compare(b.day), strings.Compare(a.dest.String(), b.dest.String()))
+	})
+
+	var written int
+	var errs []error
+	for _, k := range keys {
+		from := maxTime(start, k.day)
+		to := minTime(end, k.day.Add(24*time.Hour-time.Nanosecond))
+		if err := replaceDay(ctx, client, schema, k.dest, service, from, to, work[k], config.MaxBytesBilled()); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %v", k.dest, k.day.Format("2006-01-02"), err))
+			continue
+		}
+		written += len(work[k])
+	}
+	if err := errors.Join(errs...); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return written, err
+	}
+	return written, nil
+}
+
+// existingTables drops the tables that do not exist and, when tables are
+// sharded, the shards of days outside [start, end].
+func existingTables(ctx context.Context, client *bigquery.Client, config *configs.Config, tables []Destination, start, end time.Time) ([]Destination, error) {
+	var out []Destination
+	for _, dest := range tables {
+		if config.BigQuery.Partitioning == Sharded {
+			i := strings.LastIndex(dest.TableID, "_")
+			day, err := time.Parse("20060102", dest.TableID[i+1:])
+			if err != nil || day.Before(start.UTC().Truncate(24*time.Hour)) || day.After(end) {
+				continue
+			}
+		}
+		_, err := client.DatasetInProject(dest.ProjectID, dest.DatasetID).Table(dest.TableID).Metadata(ctx)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read table %s: %v", dest, err)
+		}
+		out = append(out, dest)
+	}
+	return out, nil
+}
+
+// daysWithRows finds the tables and days holding rows of service within
+// [start, end], in one query billing at most maxBytesBilled bytes.
+func daysWithRows(ctx context.Context, client *bigquery.Client, tables []Destination, service string, start, end time.Time, maxBytesBilled int64) (map[tableDay][]BQLogRow, error) {
+	work := make(map[tableDay][]BQLogRow)
+	if len(tables) == 0 {
+		return work, nil
+	}
+
+	selects := make([]string, len(tables))
+	for i, table := range tables {
+		selects[i] = fmt.Sprintf(`SELECT %d AS table_index, TIMESTAMP_TRUNC(timestamp, DAY, "UTC") AS day
+FROM %s
+WHERE service_name = @service AND timestamp BETWEEN @start AND @end
+GROUP BY day`, i, TableRef(table))
+	}
+	it, err := Query(ctx, client, strings.Join(selects, "\nUNION ALL\n"), map[string]any{"service": service, "start": start, "end": end}, maxBytesBilled)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var row struct {
+			TableIndex int64     `bigquery:"table_index"`
+			Day        time.Time `bigquery:"day"`
+		}
+		err := it.Next(&row)
+		if err == iterator.Done {
+			return work, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read existing days: %v", err)
+		}
+		work[tableDay{tables[row.TableIndex], row.Day.UTC()}] = nil
+	}
+}
+
+// replaceDay swaps the rows of service within [start, end] in dest for rows.
+func replaceDay(ctx context.Context, client *bigquery.Client, schema bigquery.Schema, dest Destination, service string, start, end time.Time, rows []BQLogRow, maxBytesBilled int64) error {
+	params := map[string]any{"service": service, "start": start, "end": end}
+	del := fmt.Sprintf("DELETE FROM %s WHERE service_name = @service AND timestamp BETWEEN @start AND @end", TableRef(dest))
+	records := make([]map[string]any, len(rows))
+	for i, row := range rows {
+		records[i] = loadRecord(schema, row)
+	}
+	if err := swapRows(ctx, client, schema, dest, del, params, records, maxBytesBilled); err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		slog.InfoContext(ctx, "Deleted replaced rows", "table", dest.String(), "service", service, "start", start, "end", end)
+	} else {
+		slog.InfoContext(ctx, "Replaced rows", "table", dest.String(), "service", service, "start", start, "end", end, "rows", len(rows))
+	}
+	return nil
+}
+
+// swapRows runs the DELETE statement del on dest and inserts records in a
+// single transaction, loading them into a staging table first. Without
+// records only del runs. Each query bills at most maxBytesBilled bytes.
+func swapRows(ctx context.Context, client *bigquery.Client, schema bigquery.Schema, dest Destination, del string, params map[string]any, records []map[string]any, maxBytesBilled int64) error {
+	if len(records) == 0 {
+		_, err := Query(ctx, client, del, params, maxBytesBilled)
+		return dmlError(dest, err)
+	}
+
+	staging, err := loadStaging(ctx, client, schema, dest, records)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		table := client.DatasetInProject(staging.ProjectID, staging.DatasetID).Table(staging.TableID)
+		if err := table.Delete(context.WithoutCancel(ctx)); err != nil {
+			slog.WarnContext(ctx, "Failed to delete staging table", "table", staging.String(), "error", err)
+		}
+	}()
+
+	columns := make([]string, len(schema))
+	for i, col := range schema {
+		columns[i] = col.Name
+	}
+	cols := strings.Join(columns, ", ")
+	script := fmt.Sprintf(`BEGIN TRANSACTION;
+%s;
+INSERT INTO %s (%s)
+SELECT %s FROM %s;
+COMMIT TRANSACTION;`, del, TableRef(dest), cols, cols, TableRef(staging))
+	_, err = Query(ctx, client, script, params, maxBytesBilled)
+	return dmlError(dest, err)
+}
+
+// dmlError explains err when a statement on dest failed because some of the
+// rows it deletes were streamed recently: BigQuery keeps them in the
+// streaming buffer, where DML cannot change them, for up to about 90 minutes.
+func dmlError(dest Destination, err error) error {
+	if err != nil && strings.Contains(err.Error(), "streaming buffer") {
+		return fmt.Errorf("%s has rows in the window that are still in the streaming buffer, which DML cannot delete; "+
+			"retry once they were inserted more than 90 minutes ago: %v", dest, err)
+	}
+	return err
+}
+
+// loadStaging creates a staging table next to dest and loads records into it
+// with a load job, which unlike streaming inserts makes them available to DML
+// immediately.
+func loadStaging(ctx context.Context, client *bigquery.Client, schema bigquery.Schema, dest Destination, records []map[string]any) (Destination, error) {
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	staging := Destination{ProjectID: dest.ProjectID, DatasetID: dest.DatasetID, TableID: dest.TableID + stagingInfix + hex.EncodeToString(suffix)}
+	table := client.DatasetInProject(staging.ProjectID, staging.DatasetID).Table(staging.TableID)
+	if err := table.Create(ctx, &bigquery.TableMetadata{Schema: schema, ExpirationTime: time.Now().Add(stagingTTL)}); err != nil {
+		return staging, fmt.Errorf("failed to create staging table %s: %v", staging, err)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return staging, fmt.Errorf("failed to encode staged row: %v", err)
+		}
+	}
+	src := bigquery.NewReaderSource(&buf)
+	src.SourceFormat = bigquery.JSON
+	loader := table.LoaderFrom(src)
+	loader.WriteDisposition = bigquery.WriteTruncate
+
+	job, err := loader.Run(ctx)
+	if err == nil {
+		var status *bigquery.JobStatus
+		if status, err = job.Wait(ctx); err == nil {
+			err = status.Err()
+		}
+	}
+	if err != nil {
+		return staging, fmt.Errorf("failed to load staging table %s: %v", staging, err)
+	}
+	return staging, nil
+}
+
+// loadRecord returns row, a BQLogRow or RollupRow, as a load job JSON object.
+// JSON columns are embedded as JSON values rather than strings, and empty ones
+// are omitted.
+func loadRecord(schema bigquery.Schema, row any) map[string]any {
+	fields := rowFields(row)
+	rec := make(map[string]any, len(schema))
+	for _, col := range schema {
+		v, ok := fields[col.Name]
+		if !ok {
+			continue
+		}
+		switch val := v.Interface().(type) {
+		case time.Time:
+			rec[col.Name] = val.UTC().Format(timestampLayout)
+		case string:
+			if col.Type != bigquery.JSONFieldType {
+				rec[col.Name] = val
+			} else if json.Valid([]byte(val)) {
+				rec[col.Name] = json.RawMessage(val)
+			}
+		default:
+			rec[col.Name] = val
+		}
+	}
+	return rec
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
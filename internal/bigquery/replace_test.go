@@ -0,0 +1,119 @@
+package bigquery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/internal/fakes"
+	bqv2 "google.golang.org/api/bigquery/v2"
+)
+
+func TestLoadRecord(t *testing.T) {
+	schema, err := LoadSchema("../../schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := BQLogRow{
+		Timestamp:   time.Date(2024, 5, 1, 12, 0, 0, 123456000, time.FixedZone("CEST", 2*3600)),
+		Severity:    "ERROR",
+		InsertID:    "abc",
+		JsonPayload: `{"message":"boom","n":1}`,
+		Status:      bigquery.NullInt64{Int64: 502, Valid: true},
+	}
+	data, err := json.Marshal(loadRecord(schema, row))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got["timestamp"] != "2024-05-01 10:00:00.123456+00:00" {
+		t.Errorf("timestamp = %v", got["timestamp"])
+	}
+	if payload, ok := got["json_payload"].(map[string]any); !ok || payload["message"] != "boom" {
+		t.Errorf("json_payload = %#v, want an embedded JSON object", got["json_payload"])
+	}
+	if _, ok := got["http_request"]; ok {
+		t.Errorf("empty JSON column http_request was written as %#v", got["http_request"])
+	}
+	if got["status"] != 502.0 || got["latency_ms"] != nil {
+		t.Errorf("status = %v, latency_ms = %v; want 502 and null", got["status"], got["latency_ms"])
+	}
+	if got["severity"] != "ERROR" || got["text_payload"] != "" {
+		t.Errorf("severity = %v, text_payload = %#v", got["severity"], got["text_payload"])
+	}
+}
+
+func TestLoadRecordRollup(t *testing.T) {
+	schema, err := LoadSchema("../../rollup_schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := RollupRow{
+		BucketStart:   time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		BucketSeconds: 60,
+		ServiceName:   "api",
+		EntryCount:    3,
+		LatencyP50Ms:  bigquery.NullFloat64{Float64: 12.5, Valid: true},
+	}
+	data, err := json.Marshal(loadRecord(schema, row))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["bucket_start"] != "2024-05-01 12:00:00+00:00" || got["service_name"] != "api" || got["entry_count"] != 3.0 {
+		t.Errorf("record = %v", got)
+	}
+	if got["latency_p50_ms"] != 12.5 || got["latency_p99_ms"] != nil {
+		t.Errorf("latency_p50_ms = %v, latency_p99_ms = %v; want 12.5 and null", got["latency_p50_ms"], got["latency_p99_ms"])
+	}
+}
+
+// TestReplaceBatchesStreamingBuffer checks that replacing rows BigQuery still
+// holds in the streaming buffer fails with an explanation.
+func TestReplaceBatchesStreamingBuffer(t *testing.T) {
+	f, config := newFakeBigQuery(t, "replace_streamed")
+	config.BigQuery.AutoCreate = true
+	dest := DefaultDestination(config)
+	ctx := context.Background()
+
+	rows := testRows(2)
+	if _, err := InsertBatches(ctx, config, map[Destination][]BQLogRow{dest: rows}); err != nil {
+		t.Fatalf("InsertBatches: %v", err)
+	}
+	f.HandleQueries(func(sql string, params map[string]any) (*fakes.QueryResult, error) {
+		if strings.Contains(sql, "AS table_index") {
+			day := rows[0].Timestamp.Truncate(24 * time.Hour)
+			return &fakes.QueryResult{
+				Schema: &bqv2.TableSchema{Fields: []*bqv2.TableFieldSchema{{Name: "table_index", Type: "INTEGER"}, {Name: "day", Type: "TIMESTAMP"}}},
+				Rows:   [][]any{{int64(0), day}},
+			}, nil
+		}
+		// What BigQuery answers while streamed rows are buffered.
+		return nil, fmt.Errorf("UPDATE or DELETE statement over table %s would affect rows in the streaming buffer, which is not supported", dest)
+	})
+
+	start, end := rows[0].Timestamp, rows[1].Timestamp
+	_, err := ReplaceBatches(ctx, config, "api", start, end, []Destination{dest}, map[Destination][]BQLogRow{dest: rows})
+	if err == nil {
+		t.Fatal("ReplaceBatches succeeded over rows in the streaming buffer")
+	}
+	for _, want := range []string{dest.String(), "still in the streaming buffer", "90 minutes"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+	if got := len(f.Rows(dest.ProjectID, dest.DatasetID, dest.TableID)); got != 2 {
+		t.Errorf("table has %d rows after the failed replace, want the 2 streamed rows", got)
+	}
+}
@@ -0,0 +1,227 @@
+package bigquery
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/configs"
+	"google.golang.org/api/iterator"
+)
+
+// Retention holds the cutoff of each service: its rows with an earlier
+// timestamp have expired. Services without a cutoff use Default. A zero
+// cutoff keeps rows forever.
+type Retention struct {
+	Cutoffs map[string]time.Time
+	Default time.Time
+	// Days is the longest retention in days, or 0 when some rows are kept
+	// forever.
+	Days int
+	// MaxBytesBilled caps the queries finding and deleting expired rows; 0
+	// means unlimited.
+	MaxBytesBilled int64
+}
+
+// NewRetention returns the cutoffs of retention in config as of now.
+func NewRetention(config *configs.Config, now time.Time) Retention {
+	cutoff := func(days int) time.Time {
+		if days == 0 {
+			return time.Time{}
+		}
+		return now.UTC().AddDate(0, 0, -days)
+	}
+	r := Retention{Cutoffs: make(map[string]time.Time), Default: cutoff(config.Retention.Days), Days: config.Retention.Days, MaxBytesBilled: config.MaxBytesBilled()}
+	for service, days := range config.Retention.Services {
+		r.Cutoffs[service] = cutoff(days)
+		if days == 0 || r.Days == 0 {
+			r.Days = 0
+		} else {
+			r.Days = max(r.Days, days)
+		}
+	}
+	return r
+}
+
+// Empty reports whether r keeps every row.
+func (r Retention) Empty() bool {
+	if !r.Default.IsZero() {
+		return false
+	}
+	for _, cutoff := range r.Cutoffs {
+		if !cutoff.IsZero() {
+			return false
+		}
+	}
+	return true
+}
+
+// latest returns the latest cutoff, which bounds the partitions holding
+// expired rows.
+func (r Retention) latest() time.Time {
+	latest := r.Default
+	for _, cutoff := range r.Cutoffs {
+		latest = maxTime(latest, cutoff)
+	}
+	return latest
+}
+
+// condition returns a condition matching expired rows and its parameters.
+func (r Retention) condition() (string, map[string]any) {
+	params := map[string]any{"default_cutoff": r.Default}
+	services := make([]string, 0, len(r.Cutoffs))
+	for service := range r.Cutoffs {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	if len(services) == 0 {
+		return "timestamp < @default_cutoff", params
+	}
+	params["latest_cutoff"] = r.latest()
+
+	var b strings.Builder
+	b.WriteString("timestamp < @latest_cutoff AND timestamp < CASE service_name")
+	for i, service := range services {
+		fmt.Fprintf(&b, " WHEN @service_%d THEN @cutoff_%d", i, i)
+		params[fmt.Sprintf("service_%d", i)] = service
+		params[fmt.Sprintf("cutoff_%d", i)] = r.Cutoffs[service]
+	}
+	b.WriteString(" ELSE @default_cutoff END")
+	return b.String(), params
+}
+
+// ExpiredDay counts the expired rows of one UTC day of a table, by service.
+type ExpiredDay struct {
+	Table Destination
+	Day   time.Time
+	Rows  map[string]int64
+}
+
+// ExpiredRows finds the days of tables holding rows past r, in one query,
+// ordered by table and day.
+func ExpiredRows(ctx context.Context, client *bigquery.Client, tables []Destination, r Retention) ([]ExpiredDay, error) {
+	if len(tables) == 0 || r.Empty() {
+		return nil, nil
+	}
+	cond, params := r.condition()
+	selects := make([]string, len(tables))
+	for i, table := range tables {
+		selects[i] = fmt.Sprintf(`SELECT %d AS table_index, TIMESTAMP_TRUNC(timestamp, DAY, "UTC") AS day, IFNULL(service_name, '') AS service, COUNT(*) AS row_count
+FROM %s
+WHERE %s
+GROUP BY day, service`, i, TableRef(table), cond)
+	}
+	it, err := Query(ctx, client, strings.Join(selects, "\nUNION ALL\n"), params, r.MaxBytesBilled)
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		table int64
+		day   time.Time
+	}
+	days := make(map[key]*ExpiredDay)
+	for {
+		var row struct {
+			TableIndex int64     `bigquery:"table_index"`
+			Day        time.Time `bigquery:"day"`
+			Service    string    `bigquery:"service"`
+			RowCount   int64     `bigquery:"row_count"`
+		}
+		err := it.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read expired rows: %v", err)
+		}
+		k := key{row.TableIndex, row.Day.UTC()}
+		if days[k] == nil {
+			days[k] = &ExpiredDay{Table: tables[row.TableIndex], Day: k.day, Rows: make(map[string]int64)}
+		}
+		days[k].Rows[row.Service] += row.RowCount
+	}
+
+	keys := make([]key, 0, len(days))
+	for k := range days {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].table != keys[j].table {
+			return keys[i].table < keys[j].table
+		}
+		return keys[i].day.Before(keys[j].day)
+	})
+	out := make([]ExpiredDay, len(keys))
+	for i, k := range keys {
+		out[i] = *days[k]
+	}
+	return out, nil
+}
+
+// DeleteExpired deletes the rows of day past r. The statement only touches
+// the day's partition.
+func DeleteExpired(ctx context.Context, client *bigquery.Client, day ExpiredDay, r Retention) error {
+	cond, params := r.condition()
+	params["day_start"] = day.Day
+	params["day_end"] = day.Day.Add(24 * time.Hour)
+	del := fmt.Sprintf("DELETE FROM %s WHERE timestamp >= @day_start AND timestamp < @day_end AND %s", TableRef(day.Table), cond)
+	_, err := Query(ctx, client, del, params, r.MaxBytesBilled)
+	return err
+}
+
+// SetExpiration makes BigQuery drop the data of dest once all of it is older
+// than days: daily partitions and the shards of sharded tables expire days
+// after their day ends. It returns a description of the change, or "" when
+// dest already expires so or is neither partitioned nor sharded. With dry
+// set, the change is described but not made.
+func SetExpiration(ctx context.Context, client *bigquery.Client, config *configs.Config, dest Destination, days int, dry bool) (string, error) {
+	table := client.DatasetInProject(dest.ProjectID, dest.DatasetID).Table(dest.TableID)
+	meta, err := table.Metadata(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to read table %s: %v", dest, err)
+	}
+
+	var update bigquery.TableMetadataToUpdate
+	var change string
+	switch {
+	case config.BigQuery.Partitioning == Sharded && meta.TimePartitioning == nil:
+		i := strings.LastIndex(dest.TableID, "_")
+		day, err := time.Parse("20060102", dest.TableID[i+1:])
+		if err != nil {
+			return "", nil
+		}
+		expires := day.AddDate(0, 0, days+1)
+		if meta.ExpirationTime.Equal(expires) {
+			return "", nil
+		}
+		update.ExpirationTime = expires
+		change = fmt.Sprintf("table expires %s", expires.Format(time.RFC3339))
+	case meta.TimePartitioning != nil:
+		// Partition expiration counts from the start of the partition's day.
+		expiration := time.Duration(days+1) * 24 * time.Hour
+		if meta.TimePartitioning.Expiration == expiration {
+			return "", nil
+		}
+		partitioning := *meta.TimePartitioning
+		partitioning.Expiration = expiration
+		update.TimePartitioning = &partitioning
+		change = fmt.Sprintf("partition expiration %d days", days+1)
+		if old := meta.TimePartitioning.Expiration; old > 0 {
+			change += fmt.Sprintf(" (was %d days)", int(old/(24*time.Hour)))
+		}
+	default:
+		return "", nil
+	}
+
+	if dry {
+		return change, nil
+	}
+	if _, err := table.Update(ctx, update, meta.ETag); err != nil {
+		return "", fmt.Errorf("failed to set expiration of %s: %v", dest, err)
+	}
+	return change, nil
+}
@@ -0,0 +1,47 @@
+package bigquery
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/phaserunner03/logging/configs"
+)
+
+func TestRetention(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	config := &configs.Config{}
+	config.Retention.Days = 90
+	config.Retention.Services = map[string]int{"payments": 400, "debug": 30}
+
+	r := NewRetention(config, now)
+	if r.Days != 400 {
+		t.Errorf("Days = %d, want 400", r.Days)
+	}
+	cond, params := r.condition()
+	wantCond := "timestamp < @latest_cutoff AND timestamp < CASE service_name WHEN @service_0 THEN @cutoff_0 WHEN @service_1 THEN @cutoff_1 ELSE @default_cutoff END"
+	if cond != wantCond {
+		t.Errorf("condition = %s, want %s", cond, wantCond)
+	}
+	wantParams := map[string]any{
+		"latest_cutoff":  now.AddDate(0, 0, -30),
+		"default_cutoff": now.AddDate(0, 0, -90),
+		"service_0":      "debug",
+		"cutoff_0":       now.AddDate(0, 0, -30),
+		"service_1":      "payments",
+		"cutoff_1":       now.AddDate(0, 0, -400),
+	}
+	if !reflect.DeepEqual(params, wantParams) {
+		t.Errorf("params = %v, want %v", params, wantParams)
+	}
+
+	// A service kept forever rules out table expiration.
+	config.Retention.Services["audit"] = 0
+	if r := NewRetention(config, now); r.Days != 0 || !r.Cutoffs["audit"].IsZero() {
+		t.Errorf("with a service kept forever: Days = %d, audit cutoff = %v", r.Days, r.Cutoffs["audit"])
+	}
+
+	if !NewRetention(&configs.Config{}, now).Empty() {
+		t.Error("retention without days is not empty")
+	}
+}
@@ -0,0 +1,42 @@
+package bigquery
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/phaserunner03/logging/internal/telemetry"
+)
+
+// The BigQuery client retries insertAll requests internally without a hook
+// like the gax retryers of the Logging client, so retries are counted by the
+// HTTP transport: every request of a call after its first is a retry.
+
+type attemptsKey struct{}
+
+// attempts counts the requests made for one API call of service at stage.
+type attempts struct {
+	service string
+	stage   string
+	n       atomic.Int32
+}
+
+// countRetries returns a context whose requests through a retryCounter are
+// counted as attempts of a single call; all but the first increment
+// telemetry.APIRetries.
+func countRetries(ctx context.Context, service, stage string) context.Context {
+	return context.WithValue(ctx, attemptsKey{}, &attempts{service: service, stage: stage})
+}
+
+// retryCounter is an http.RoundTripper counting the retries of calls made
+// with a context from countRetries.
+type retryCounter struct {
+	base http.RoundTripper
+}
+
+func (t retryCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if a, ok := req.Context().Value(attemptsKey{}).(*attempts); ok && a.n.Add(1) > 1 {
+		telemetry.APIRetries.WithLabelValues(a.service, a.stage).Inc()
+	}
+	return t.base.RoundTrip(req)
+}
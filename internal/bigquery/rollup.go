@@ -0,0 +1,100 @@
+package bigquery
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/configs"
+)
+
+// RollupRow holds aggregated counts and latencies of one service over one time bucket.
+type RollupRow struct {
+	BucketStart   time.Time            `bigquery:"bucket_start"`   // REQUIRED
+	BucketSeconds int64                `bigquery:"bucket_seconds"` // REQUIRED
+	ServiceName   string               `bigquery:"service_name"`   // NULLABLE
+	EntryCount    int64                `bigquery:"entry_count"`    // REQUIRED
+	DefaultCount  int64                `bigquery:"default_count"`  // REQUIRED
+	DebugCount    int64                `bigquery:"debug_count"`    // REQUIRED
+	InfoCount     int64                `bigquery:"info_count"`     // REQUIRED
+	NoticeCount   int64                `bigquery:"notice_count"`   // REQUIRED
+	WarningCount  int64                `bigquery:"warning_count"`  // REQUIRED
+	ErrorCount    int64                `bigquery:"error_count"`    // REQUIRED
+	CriticalCount int64                `bigquery:"critical_count"` // REQUIRED (CRITICAL, ALERT and EMERGENCY)
+	RequestCount  int64                `bigquery:"request_count"`  // REQUIRED (entries with an HTTP status)
+	Status1xx     int64                `bigquery:"status_1xx"`     // REQUIRED
+	Status2xx     int64                `bigquery:"status_2xx"`     // REQUIRED
+	Status3xx     int64                `bigquery:"status_3xx"`     // REQUIRED
+	Status4xx     int64                `bigquery:"status_4xx"`     // REQUIRED
+	Status5xx     int64                `bigquery:"status_5xx"`     // REQUIRED
+	LatencyP50Ms  bigquery.NullFloat64 `bigquery:"latency_p50_ms"` // NULLABLE
+	LatencyP90Ms  bigquery.NullFloat64 `bigquery:"latency_p90_ms"` // NULLABLE
+	LatencyP99Ms  bigquery.NullFloat64 `bigquery:"latency_p99_ms"` // NULLABLE
+	LatencyMaxMs  bigquery.NullFloat64 `bigquery:"latency_max_ms"` // NULLABLE
+}
+
+// RollupDestination is the table configured in the rollup section, with unset
+// fields falling back to the default destination and a "log_rollups" table.
+func RollupDestination(config *configs.Config) Destination {
+	dest := Destination{
+		ProjectID: config.Rollup.ProjectID,
+		DatasetID: config.Rollup.DatasetID,
+		TableID:   config.Rollup.TableID,
+	}
+	fallback := DefaultDestination(config)
+	if dest.ProjectID == "" {
+		dest.ProjectID = fallback.ProjectID
+	}
+	if dest.DatasetID == "" {
+		dest.DatasetID = fallback.DatasetID
+	}
+	if dest.TableID == "" {
+		dest.TableID = "log_rollups"
+	}
+	return dest
+}
+
+// ReplaceRollups makes rows the only rollup rows of service whose bucket
+// starts within [start, end], deleting the old ones and inserting rows in a
+// single transaction like ReplaceBatches. Buckets are replaced whole, so rows
+// must cover every bucket starting within the window. Rollups are only
+// written this way: unlike streamed rows, loaded ones can be replaced at once.
+func ReplaceRollups(ctx context.Context, config *configs.Config, service string, start, end time.Time, rows []RollupRow) error {
+	client, err := NewClient(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	dest, schema, err := rollupTable(ctx, client, config)
+	if err != nil {
+		return err
+	}
+	records := make([]map[string]any, len(rows))
+	for i, row := range rows {
+		records[i] = loadRecord(schema, row)
+	}
+	del := fmt.Sprintf("DELETE FROM %s WHERE service_name = @service AND bucket_start BETWEEN @start AND @end", TableRef(dest))
+	params := map[string]any{"service": service, "start": start, "end": end}
+	if err := swapRows(ctx, client, schema, dest, del, params, records, config.MaxBytesBilled()); err != nil {
+		return fmt.Errorf("failed to replace rollup rows in %s: %v", dest, err)
+	}
+	return nil
+}
+
+// rollupTable returns the rollup destination and schema, creating the table
+// from the rollup schema (partitioned daily on bucket_start) when it does not
+// exist.
+func rollupTable(ctx context.Context, client *bigquery.Client, config *configs.Config) (Destination, bigquery.Schema, error) {
+	dest := RollupDestination(config)
+	schema, err := LoadSchema(config.Rollup.Schema)
+	if err != nil {
+		return dest, nil, err
+	}
+	err = ensureTable(ctx, client, dest, &bigquery.TableMetadata{
+		Schema:           schema,
+		TimePartitioning: &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: "bucket_start"},
+	})
+	return dest, schema, err
+}
@@ -0,0 +1,122 @@
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/fakes"
+)
+
+var insertFrom = regexp.MustCompile("SELECT .* FROM `([^`]+)`;")
+
+func TestRollupDestination(t *testing.T) {
+	config := &configs.Config{}
+	config.GCP.ProjectID = "p"
+	config.BigQuery.DatasetID = "logs"
+	if got, want := RollupDestination(config), (Destination{"p", "logs", "log_rollups"}); got != want {
+		t.Errorf("default RollupDestination = %v, want %v", got, want)
+	}
+
+	config.Rollup.ProjectID = "metrics-project"
+	config.Rollup.DatasetID = "metrics"
+	config.Rollup.TableID = "rollups"
+	if got, want := RollupDestination(config), (Destination{"metrics-project", "metrics", "rollups"}); got != want {
+		t.Errorf("configured RollupDestination = %v, want %v", got, want)
+	}
+}
+
+func testRollups(n int) []RollupRow {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	rows := make([]RollupRow, n)
+	for i := range rows {
+		rows[i] = RollupRow{
+			BucketStart:   start.Add(time.Duration(i) * time.Minute),
+			BucketSeconds: 60,
+			ServiceName:   "api",
+			EntryCount:    2,
+			InfoCount:     2,
+			RequestCount:  1,
+			Status2xx:     1,
+			LatencyP50Ms:  bq.NullFloat64{Float64: 12.5, Valid: true},
+		}
+	}
+	return rows
+}
+
+func TestReplaceRollups(t *testing.T) {
+	f, config := newFakeBigQuery(t, "unused")
+	config.Rollup.Schema = "../../rollup_schema.json"
+	config.Rollup.TableID = "rollups_replace"
+	var params map[string]any
+	var loaded []map[string]any
+	f.HandleQueries(func(sql string, p map[string]any) (*fakes.QueryResult, error) {
+		params = p
+		if staging := insertFrom.FindStringSubmatch(sql); staging != nil {
+			ref := strings.Split(staging[1], ".")
+			loaded = f.Rows(ref[0], ref[1], ref[2])
+		}
+		return nil, nil
+	})
+
+	rows := testRollups(3)
+	start, end := rows[0].BucketStart, rows[2].BucketStart
+	if err := ReplaceRollups(context.Background(), config, "api", start, end, rows); err != nil {
+		t.Fatalf("ReplaceRollups: %v", err)
+	}
+
+	meta := f.Table("test-project", "logs", "rollups_replace")
+	if meta == nil {
+		t.Fatal("rollup table was not created")
+	}
+	if meta.TimePartitioning == nil || meta.TimePartitioning.Field != "bucket_start" {
+		t.Errorf("rollup table partitioning = %+v, want daily on bucket_start", meta.TimePartitioning)
+	}
+	queries := f.Queries()
+	if len(queries) != 1 || !strings.Contains(queries[0], "DELETE FROM `test-project.logs.rollups_replace` WHERE service_name = @service AND bucket_start BETWEEN @start AND @end") {
+		t.Fatalf("queries = %q, want one transaction replacing the buckets", queries)
+	}
+	if params["service"] != "api" || !params["start"].(time.Time).Equal(start) || !params["end"].(time.Time).Equal(end) {
+		t.Errorf("params = %v, want api from %s to %s", params, start, end)
+	}
+	if len(loaded) != 3 || loaded[0]["service_name"] != "api" {
+		t.Errorf("transaction inserted %v, want the 3 rollup rows", loaded)
+	}
+	if tables := f.Tables(); len(tables) != 1 {
+		t.Errorf("tables = %v, want the staging table deleted", tables)
+	}
+}
+
+func TestReplaceRollupsEmpty(t *testing.T) {
+	f, config := newFakeBigQuery(t, "unused")
+	config.Rollup.Schema = "../../rollup_schema.json"
+	config.Rollup.TableID = "rollups_empty"
+	f.HandleQueries(func(string, map[string]any) (*fakes.QueryResult, error) { return nil, nil })
+
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	if err := ReplaceRollups(context.Background(), config, "api", start, start.Add(time.Hour), nil); err != nil {
+		t.Fatalf("ReplaceRollups: %v", err)
+	}
+	if queries := f.Queries(); len(queries) != 1 || !strings.HasPrefix(queries[0], "DELETE FROM") {
+		t.Errorf("queries = %q, want only the DELETE", queries)
+	}
+}
+
+func TestReplaceRollupsReportsFailures(t *testing.T) {
+	f, config := newFakeBigQuery(t, "unused")
+	config.Rollup.Schema = "../../rollup_schema.json"
+	config.Rollup.TableID = "rollups_failure"
+	f.HandleQueries(func(string, map[string]any) (*fakes.QueryResult, error) {
+		return nil, errors.New("transaction aborted")
+	})
+
+	rows := testRollups(1)
+	if err := ReplaceRollups(context.Background(), config, "api", rows[0].BucketStart, rows[0].BucketStart, rows); err == nil {
+		t.Fatal("ReplaceRollups succeeded, want error")
+	}
+}
@@ -0,0 +1,125 @@
+package bigquery
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// LoadSchema reads a table schema in the bq JSON format (see schema.json).
+func LoadSchema(path string) (bigquery.Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %v", err)
+	}
+	schema, err := bigquery.SchemaFromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema %s: %v", path, err)
+	}
+	return schema, nil
+}
+
+// ValidateRow checks row against schema: every column must map to a field of
+// BQLogRow with a compatible type, REQUIRED columns must be set and JSON
+// columns must hold valid JSON.
+func ValidateRow(schema bigquery.Schema, row BQLogRow) []error {
+	fields := rowFields(row)
+	var errs []error
+
+	for _, col := range schema {
+		v, ok := fields[col.Name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("column %s has no matching BQLogRow field", col.Name))
+			continue
+		}
+		delete(fields, col.Name)
+
+		if col.Required && v.IsZero() {
+			errs = append(errs, fmt.Errorf("column %s is REQUIRED but empty", col.Name))
+		}
+		if err := checkType(col, v); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for name := range fields {
+		errs = append(errs, fmt.Errorf("field %s is not a column of the schema", name))
+	}
+	return errs
+}
+
+func checkType(col *bigquery.FieldSchema, v reflect.Value) error {
+	switch col.Type {
+	case bigquery.JSONFieldType:
+		s, ok := v.Interface().(string)
+		if !ok {
+			return fmt.Errorf("column %s is JSON but field is %s", col.Name, v.Type())
+		}
+		if s != "" && !json.Valid([]byte(s)) {
+			return fmt.Errorf("column %s holds invalid JSON: %.80q", col.Name, s)
+		}
+	case bigquery.StringFieldType:
+		if _, ok := v.Interface().(bigquery.NullString); !ok && v.Kind() != reflect.String {
+			return fmt.Errorf("column %s is STRING but field is %s", col.Name, v.Type())
+		}
+	case bigquery.TimestampFieldType:
+		if _, ok := v.Interface().(time.Time); !ok {
+			return fmt.Errorf("column %s is TIMESTAMP but field is %s", col.Name, v.Type())
+		}
+	case bigquery.IntegerFieldType:
+		if _, ok := v.Interface().(bigquery.NullInt64); !ok && v.Kind() != reflect.Int64 {
+			return fmt.Errorf("column %s is INTEGER but field is %s", col.Name, v.Type())
+		}
+	case bigquery.FloatFieldType:
+		if _, ok := v.Interface().(bigquery.NullFloat64); !ok && v.Kind() != reflect.Float64 {
+			return fmt.Errorf("column %s is FLOAT but field is %s", col.Name, v.Type())
+		}
+	case bigquery.BooleanFieldType:
+		if _, ok := v.Interface().(bigquery.NullBool); !ok && v.Kind() != reflect.Bool {
+			return fmt.Errorf("column %s is BOOLEAN but field is %s", col.Name, v.Type())
+		}
+	}
+	return nil
+}
+
+// EstimateRowBytes approximates the logical size BigQuery bills for row, a
+// BQLogRow or RollupRow, following https://cloud.google.com/bigquery/pricing#data.
+func EstimateRowBytes(row any) int {
+	var size int
+	for _, v := range rowFields(row) {
+		switch val := v.Interface().(type) {
+		case string:
+			size += 2 + len(val)
+		case bigquery.NullString:
+			size += 2 + len(val.StringVal)
+		case time.Time:
+			size += 8
+		case bool, bigquery.NullBool:
+			size++
+		default:
+			size += 8
+		}
+	}
+	return size
+}
+
+// rowFields maps the bigquery column name of each field of the row struct to
+// its value.
+func rowFields(row any) map[string]reflect.Value {
+	v := reflect.ValueOf(row)
+	t := v.Type()
+	fields := make(map[string]reflect.Value, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("bigquery"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = v.Field(i)
+	}
+	return fields
+}
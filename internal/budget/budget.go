@@ -0,0 +1,113 @@
+// Package budget enforces the insert limits of budget in the configuration.
+package budget
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/phaserunner03/logging/configs"
+)
+
+// ErrExceeded is wrapped by the errors of inserts that would go over budget.
+var ErrExceeded = errors.New("insert budget exceeded")
+
+// Budget counts the rows and bytes inserted during one run against the
+// per-run and per-service limits. It is safe for concurrent use; a nil
+// Budget allows everything.
+type Budget struct {
+	limits limits
+
+	mu       sync.Mutex
+	run      Usage
+	services map[string]Usage
+}
+
+// Usage is an amount of inserted rows and bytes.
+type Usage struct {
+	Rows  int64
+	Bytes int64
+}
+
+type limits struct {
+	run, service Usage
+}
+
+// New returns an empty Budget with the limits of config.
+func New(config *configs.Config) *Budget {
+	b := config.Budget
+	runBytes, _ := configs.ParseBytes(b.MaxBytesPerRun)
+	serviceBytes, _ := configs.ParseBytes(b.MaxBytesPerService)
+	return &Budget{
+		limits: limits{
+			run:     Usage{Rows: b.MaxRowsPerRun, Bytes: runBytes},
+			service: Usage{Rows: b.MaxRowsPerService, Bytes: serviceBytes},
+		},
+		services: make(map[string]Usage),
+	}
+}
+
+// Limited reports whether any insert limit is set.
+func (b *Budget) Limited() bool {
+	return b != nil && b.limits != limits{}
+}
+
+// Reserve records an insert of u for service. When that would exceed a
+// limit it records nothing and returns an error wrapping ErrExceeded.
+func (b *Budget) Reserve(service string, u Usage) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	run := b.run.add(u)
+	svc := b.services[service].add(u)
+	if err := check("run", run, b.limits.run); err != nil {
+		return err
+	}
+	if err := check("service "+service, svc, b.limits.service); err != nil {
+		return err
+	}
+	b.run = run
+	b.services[service] = svc
+	return nil
+}
+
+// Release gives back u of an earlier Reserve for service, for rows that were
+// not inserted after all.
+func (b *Budget) Release(service string, u Usage) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	neg := Usage{Rows: -u.Rows, Bytes: -u.Bytes}
+	b.run = b.run.add(neg)
+	b.services[service] = b.services[service].add(neg)
+}
+
+// Used returns the rows and bytes recorded for the run.
+func (b *Budget) Used() Usage {
+	if b == nil {
+		return Usage{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.run
+}
+
+func (u Usage) add(v Usage) Usage {
+	return Usage{Rows: u.Rows + v.Rows, Bytes: u.Bytes + v.Bytes}
+}
+
+func check(scope string, got, limit Usage) error {
+	if limit.Rows > 0 && got.Rows > limit.Rows {
+		return fmt.Errorf("%w: %s would insert %d rows, limit %d", ErrExceeded, scope, got.Rows, limit.Rows)
+	}
+	if limit.Bytes > 0 && got.Bytes > limit.Bytes {
+		return fmt.Errorf("%w: %s would insert %d bytes, limit %d", ErrExceeded, scope, got.Bytes, limit.Bytes)
+	}
+	return nil
+}
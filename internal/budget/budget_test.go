@@ -0,0 +1,51 @@
+package budget
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/phaserunner03/logging/configs"
+)
+
+func TestReserve(t *testing.T) {
+	config := &configs.Config{}
+	config.Budget.MaxRowsPerRun = 10
+	config.Budget.MaxBytesPerService = "1KB"
+	b := New(config)
+
+	steps := []struct {
+		service string
+		usage   Usage
+		ok      bool
+	}{
+		{"api", Usage{Rows: 4, Bytes: 600}, true},
+		{"api", Usage{Rows: 1, Bytes: 500}, false}, // api over 1000 bytes
+		{"web", Usage{Rows: 6, Bytes: 900}, true},
+		{"web", Usage{Rows: 1, Bytes: 1}, false}, // run over 10 rows
+	}
+	for i, s := range steps {
+		err := b.Reserve(s.service, s.usage)
+		if s.ok && err != nil {
+			t.Errorf("step %d: Reserve: %v", i, err)
+		}
+		if !s.ok && !errors.Is(err, ErrExceeded) {
+			t.Errorf("step %d: Reserve = %v, want ErrExceeded", i, err)
+		}
+	}
+	if got, want := b.Used(), (Usage{Rows: 10, Bytes: 1500}); got != want {
+		t.Errorf("Used = %+v, want %+v", got, want)
+	}
+
+	b.Release("api", Usage{Rows: 4, Bytes: 600})
+	if err := b.Reserve("api", Usage{Rows: 4, Bytes: 1000}); err != nil {
+		t.Errorf("Reserve after Release: %v", err)
+	}
+	if got, want := b.Used(), (Usage{Rows: 10, Bytes: 1900}); got != want {
+		t.Errorf("Used after Release = %+v, want %+v", got, want)
+	}
+
+	var unlimited *Budget
+	if err := unlimited.Reserve("api", Usage{Rows: 1 << 40}); err != nil {
+		t.Errorf("nil Budget: %v", err)
+	}
+}
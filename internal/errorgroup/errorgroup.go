@@ -0,0 +1,248 @@
+// Package errorgroup detects stack traces in log rows and groups them by a
+// normalized fingerprint, in the spirit of Cloud Error Reporting.
+package errorgroup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/internal/bigquery"
+)
+
+// Languages recognized by Detect.
+const (
+	Go     = "go"
+	Java   = "java"
+	Python = "python"
+	Node   = "nodejs"
+)
+
+// maxFrames is the number of innermost frames that make up a fingerprint, so
+// deep recursion or differing callers further up still group together.
+const maxFrames = 10
+
+// Error is a stack trace found in a log entry.
+type Error struct {
+	Language string
+	Type     string   // exception class, e.g. "java.lang.NullPointerException"
+	Message  string   // first line of the error message
+	Frames   []string // normalized frames, innermost first
+}
+
+// GroupID returns the fingerprint of e: a hash of its type and normalized
+// frames. Messages are left out as they typically embed request-specific values.
+func (e Error) GroupID() string {
+	h := sha256.New()
+	h.Write([]byte(e.Language + "\n" + e.Type + "\n"))
+	frames := e.Frames
+	if len(frames) > maxFrames {
+		frames = frames[:maxFrames]
+	}
+	for _, f := range frames {
+		h.Write([]byte(f + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// jsonFields are the json_payload fields searched for stack traces, in order.
+var jsonFields = []string{"stack_trace", "stack", "exception", "error", "message"}
+
+// Classify detects a stack trace in row's text or JSON payload and sets its
+// ErrorGroupID and ExceptionType. It reports whether a stack trace was found.
+func Classify(row *bigquery.BQLogRow) bool {
+	for _, text := range payloadTexts(row) {
+		if e, ok := Detect(text); ok {
+			row.ErrorGroupID = bq.NullString{StringVal: e.GroupID(), Valid: true}
+			row.ExceptionType = bq.NullString{StringVal: e.Type, Valid: e.Type != ""}
+			return true
+		}
+	}
+	return false
+}
+
+func payloadTexts(row *bigquery.BQLogRow) []string {
+	if row.TextPayload != "" {
+		return []string{row.TextPayload}
+	}
+	if row.JsonPayload == "" || row.JsonPayload == "null" {
+		return nil
+	}
+	var payload map[string]any
+	if err := json.Unmarshal([]byte(row.JsonPayload), &payload); err != nil {
+		return nil
+	}
+	var texts []string
+	for _, field := range jsonFields {
+		if s, ok := payload[field].(string); ok && s != "" {
+			texts = append(texts, s)
+		}
+	}
+	return texts
+}
+
+// Detect looks for a Go, Java, Python or Node.js stack trace in text.
+func Detect(text string) (Error, bool) {
+	if !strings.Contains(text, "\n") {
+		return Error{}, false
+	}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for _, detect := range []func([]string) (Error, bool){detectGo, detectJava, detectPython, detectNode} {
+		if e, ok := detect(lines); ok {
+			return e, true
+		}
+	}
+	return Error{}, false
+}
+
+var (
+	goPanic     = regexp.MustCompile(`^panic: (.*)$`)
+	goGoroutine = regexp.MustCompile(`^goroutine \d+ \[.*\]:$`)
+	goFile      = regexp.MustCompile(`^\s+\S+\.go:\d+`)
+
+	javaHeader = regexp.MustCompile(`^(?:Exception in thread "[^"]*" )?([A-Za-z_$][\w$]*(?:\.[A-Za-z_$][\w$]*)+)(?::\s*(.*))?$`)
+	javaFrame  = regexp.MustCompile(`^\s+at ([\w$.<>/]+)\(`)
+
+	pyHeader = "Traceback (most recent call last):"
+	pyFile   = regexp.MustCompile(`^\s+File "([^"]+)", line \d+, in (\S+)`)
+	pyError  = regexp.MustCompile(`^([A-Za-z_][\w.]*)(?::\s*(.*))?$`)
+
+	nodeHeader = regexp.MustCompile(`^(?:Uncaught )?([A-Z]\w*(?:Error|Exception)|Error)(?:\s*\[\w+\])?(?::\s*(.*))?$`)
+	nodeFrame  = regexp.MustCompile(`^\s+at (?:async )?(?:(.+?) \((.*)\)|(.*))$`)
+)
+
+func detectGo(lines []string) (Error, bool) {
+	e := Error{Language: Go}
+	inStack := false
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if m := goPanic.FindStringSubmatch(line); m != nil && e.Type == "" {
+			e.Message = m[1]
+			e.Type = "panic"
+			if strings.HasPrefix(m[1], "runtime error:") {
+				e.Type = "runtime.Error"
+			}
+			continue
+		}
+		if goGoroutine.MatchString(line) {
+			if inStack {
+				break // only the first goroutine is the failing one
+			}
+			inStack = true
+			continue
+		}
+		// Frames are a function line followed by an indented file line.
+		if inStack && i+1 < len(lines) && goFile.MatchString(lines[i+1]) && !strings.HasPrefix(line, "\t") {
+			fn := line
+			if j := strings.LastIndex(fn, "("); j > 0 {
+				fn = fn[:j]
+			}
+			e.Frames = append(e.Frames, normalize(fn+" "+strings.Fields(lines[i+1])[0]))
+			i++
+		}
+	}
+	if !inStack || len(e.Frames) == 0 {
+		return Error{}, false
+	}
+	if e.Type == "" {
+		e.Type = "goroutine dump"
+	}
+	return e, true
+}
+
+func detectJava(lines []string) (Error, bool) {
+	for i, line := range lines {
+		m := javaHeader.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil || i+1 >= len(lines) || !javaFrame.MatchString(lines[i+1]) {
+			continue
+		}
+		e := Error{Language: Java, Type: m[1], Message: m[2]}
+		for _, frame := range lines[i+1:] {
+			f := javaFrame.FindStringSubmatch(frame)
+			if f == nil {
+				break
+			}
+			e.Frames = append(e.Frames, normalize(f[1]))
+		}
+		return e, true
+	}
+	return Error{}, false
+}
+
+func detectPython(lines []string) (Error, bool) {
+	start := -1
+	for i, line := range lines {
+		if strings.TrimSpace(line) == pyHeader {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return Error{}, false
+	}
+
+	e := Error{Language: Python}
+	for _, line := range lines[start+1:] {
+		if f := pyFile.FindStringSubmatch(line); f != nil {
+			e.Frames = append(e.Frames, normalize(f[1]+" "+f[2]))
+			continue
+		}
+		if strings.HasPrefix(line, " ") || line == "" {
+			continue // source line of the previous frame
+		}
+		if m := pyError.FindStringSubmatch(line); m != nil {
+			e.Type, e.Message = m[1], m[2]
+		}
+		break
+	}
+	if e.Type == "" {
+		return Error{}, false
+	}
+	// Python prints the innermost frame last.
+	for i, j := 0, len(e.Frames)-1; i < j; i, j = i+1, j-1 {
+		e.Frames[i], e.Frames[j] = e.Frames[j], e.Frames[i]
+	}
+	return e, true
+}
+
+func detectNode(lines []string) (Error, bool) {
+	for i, line := range lines {
+		m := nodeHeader.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil || i+1 >= len(lines) || !nodeFrame.MatchString(lines[i+1]) {
+			continue
+		}
+		e := Error{Language: Node, Type: m[1], Message: m[2]}
+		for _, frame := range lines[i+1:] {
+			f := nodeFrame.FindStringSubmatch(frame)
+			if f == nil {
+				break
+			}
+			e.Frames = append(e.Frames, normalize(strings.TrimSpace(f[1]+" "+f[2]+f[3])))
+		}
+		return e, true
+	}
+	return Error{}, false
+}
+
+var normalizers = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`), "<uuid>"},
+	{regexp.MustCompile(`0x[0-9a-fA-F]+`), "<addr>"},
+	{regexp.MustCompile(`(\.\w+):\d+(:\d+)?`), "$1"}, // file.go:12, file.js:3:14
+	{regexp.MustCompile(`\$\$Lambda\$\d+/\S+`), "$$$$Lambda"},
+	{regexp.MustCompile(`\d+`), "<n>"},
+}
+
+// normalize strips line numbers, addresses and IDs from a frame so the same
+// code location maps to the same string across builds and requests.
+func normalize(frame string) string {
+	for _, n := range normalizers {
+		frame = n.re.ReplaceAllString(frame, n.repl)
+	}
+	return frame
+}
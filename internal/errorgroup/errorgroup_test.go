@@ -0,0 +1,169 @@
+package errorgroup
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/phaserunner03/logging/internal/bigquery"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		// a and b are the same failure seen in two runs, differing only in
+		// line numbers, addresses, goroutine and request IDs.
+		a, b     string
+		language string
+		typ      string
+		frames   int
+	}{
+		{
+			name: "go panic",
+			a: `panic: runtime error: index out of range [5] with length 3
+
+goroutine 17 [running]:
+main.(*Handler).serve(0xc000123450, {0x7ffe1234, 0x3})
+	/app/handler.go:42 +0x1d
+main.main()
+	/app/main.go:12 +0x85`,
+			b: `panic: runtime error: index out of range [9] with length 2
+
+goroutine 341 [running]:
+main.(*Handler).serve(0xc000fedcb0, {0x7ffe9876, 0x5})
+	/app/handler.go:57 +0x2f
+main.main()
+	/app/main.go:13 +0x91`,
+			language: Go,
+			typ:      "runtime.Error",
+			frames:   2,
+		},
+		{
+			name: "java exception",
+			a: `Exception in thread "main" java.lang.NullPointerException: user 7d444840-9dc0-11d1-b245-5ffdce74fad2 not found
+	at com.example.Cart.total(Cart.java:31)
+	at com.example.Cart$$Lambda$14/0x0000000800c03000.apply(Unknown Source)
+	at com.example.Main.main(Main.java:8)`,
+			b: `Exception in thread "main" java.lang.NullPointerException: user 0b7c5f40-1a2b-4c3d-8e9f-000000000001 not found
+	at com.example.Cart.total(Cart.java:35)
+	at com.example.Cart$$Lambda$27/0x0000000800c91000.apply(Unknown Source)
+	at com.example.Main.main(Main.java:9)`,
+			language: Java,
+			typ:      "java.lang.NullPointerException",
+			frames:   3,
+		},
+		{
+			name: "python traceback",
+			a: `Traceback (most recent call last):
+  File "/app/main.py", line 10, in <module>
+    main()
+  File "/app/cart.py", line 22, in pay
+    raise ValueError("bad amount 12")
+ValueError: bad amount 12`,
+			b: `Traceback (most recent call last):
+  File "/app/main.py", line 11, in <module>
+    main()
+  File "/app/cart.py", line 25, in pay
+    raise ValueError("bad amount 99")
+ValueError: bad amount 99`,
+			language: Python,
+			typ:      "ValueError",
+			frames:   2,
+		},
+		{
+			name: "node error",
+			a: `TypeError: Cannot read properties of undefined (reading 'id')
+    at getUser (/app/src/users.js:14:21)
+    at async Server.handle (/app/src/server.js:88:5)`,
+			b: `TypeError: Cannot read properties of undefined (reading 'name')
+    at getUser (/app/src/users.js:16:9)
+    at async Server.handle (/app/src/server.js:91:12)`,
+			language: Node,
+			typ:      "TypeError",
+			frames:   2,
+		},
+	}
+	for _, tt := range tests {
+		a, ok := Detect(tt.a)
+		if !ok {
+			t.Errorf("%s: no stack trace detected", tt.name)
+			continue
+		}
+		if a.Language != tt.language || a.Type != tt.typ || len(a.Frames) != tt.frames {
+			t.Errorf("%s: Detect = %s %s with %d frames, want %s %s with %d", tt.name, a.Language, a.Type, len(a.Frames), tt.language, tt.typ, tt.frames)
+		}
+		b, ok := Detect(tt.b)
+		if !ok {
+			t.Errorf("%s: no stack trace detected in second run", tt.name)
+			continue
+		}
+		if a.GroupID() != b.GroupID() {
+			t.Errorf("%s: group %s != %s\nframes %q\nframes %q", tt.name, a.GroupID(), b.GroupID(), a.Frames, b.Frames)
+		}
+	}
+}
+
+func TestDetectNone(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"panic: boom",
+		"GET /health 200",
+		"starting server\nlistening on :8080",
+	} {
+		if e, ok := Detect(text); ok {
+			t.Errorf("Detect(%q) = %+v, want no stack trace", text, e)
+		}
+	}
+}
+
+func TestGroupIDDistinguishes(t *testing.T) {
+	base := `Traceback (most recent call last):
+  File "/app/cart.py", line 22, in pay
+    pay()
+%s`
+	a, _ := Detect(fmt.Sprintf(base, "ValueError: bad"))
+	b, _ := Detect(fmt.Sprintf(base, "KeyError: 'id'"))
+	if a.GroupID() == b.GroupID() {
+		t.Errorf("ValueError and KeyError share group %s", a.GroupID())
+	}
+
+	c, _ := Detect(`Traceback (most recent call last):
+  File "/app/orders.py", line 22, in pay
+    pay()
+ValueError: bad`)
+	if a.GroupID() == c.GroupID() {
+		t.Errorf("traces from different files share group %s", a.GroupID())
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		frame, want string
+	}{
+		{"main.serve /app/handler.go:42", "main.serve /app/handler.go"},
+		{"getUser /app/src/users.js:14:21", "getUser /app/src/users.js"},
+		{"main.(*T).run(0xc000123450)", "main.(*T).run(<addr>)"},
+		{"load 7d444840-9dc0-11d1-b245-5ffdce74fad2", "load <uuid>"},
+		{"com.example.Cart$$Lambda$14/0x0000000800c03000.apply", "com.example.Cart$$Lambda"},
+		{"worker-17.run", "worker-<n>.run"},
+	}
+	for _, tt := range tests {
+		if got := normalize(tt.frame); got != tt.want {
+			t.Errorf("normalize(%q) = %q, want %q", tt.frame, got, tt.want)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	row := bigquery.BQLogRow{JsonPayload: `{"message":"failed","stack_trace":"TypeError: x is undefined\n    at f (/app/a.js:1:2)"}`}
+	if !Classify(&row) {
+		t.Fatal("Classify found no stack trace in json_payload.stack_trace")
+	}
+	if !row.ErrorGroupID.Valid || row.ExceptionType.StringVal != "TypeError" {
+		t.Errorf("Classify set group %+v, type %+v", row.ErrorGroupID, row.ExceptionType)
+	}
+
+	plain := bigquery.BQLogRow{TextPayload: "GET /health 200"}
+	if Classify(&plain) || plain.ErrorGroupID.Valid || plain.ExceptionType.Valid {
+		t.Errorf("Classify set group %+v, type %+v for a row without a stack trace, want NULL", plain.ErrorGroupID, plain.ExceptionType)
+	}
+}
@@ -0,0 +1,475 @@
+package fakes
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bq "google.golang.org/api/bigquery/v2"
+)
+
+// BigQuery is an httptest fake of the BigQuery REST API covering tables
+// (get, list, insert, patch/update, delete), tabledata.insertAll, load jobs
+// of newline-delimited JSON and jobs.query. Inserted and loaded rows are
+// recorded per table; queries are answered by the QueryFunc set with
+// HandleQueries.
+type BigQuery struct {
+	server *httptest.Server
+
+	mu          sync.Mutex
+	tables      map[string]*fakeTable
+	insertCalls int
+	insertErrs  []int
+	jobs        map[string]*bq.Job
+	queries     []string
+	query       QueryFunc
+}
+
+// QueryFunc answers a query job. params holds the named parameters as
+// strings, time.Time (TIMESTAMP), int64, float64, bool or []any (ARRAY).
+// It runs without locks held, so it may read and write the tables of f.
+type QueryFunc func(sql string, params map[string]any) (*QueryResult, error)
+
+// QueryResult is the result of a query: rows of values in the order of the
+// schema's fields, each a string, time.Time, int64, float64, bool or nil.
+type QueryResult struct {
+	Schema *bq.TableSchema
+	Rows   [][]any
+}
+
+type fakeTable struct {
+	meta *bq.Table
+	rows []map[string]any
+}
+
+// NewBigQuery starts a fake BigQuery API on a local port.
+func NewBigQuery() *BigQuery {
+	f := &BigQuery{tables: make(map[string]*fakeTable), jobs: make(map[string]*bq.Job)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /projects/{project}/datasets/{dataset}/tables", f.listTables)
+	mux.HandleFunc("POST /projects/{project}/datasets/{dataset}/tables", f.insertTable)
+	mux.HandleFunc("GET /projects/{project}/datasets/{dataset}/tables/{table}", f.getTable)
+	mux.HandleFunc("PATCH /projects/{project}/datasets/{dataset}/tables/{table}", f.updateTable)
+	mux.HandleFunc("PUT /projects/{project}/datasets/{dataset}/tables/{table}", f.updateTable)
+	mux.HandleFunc("DELETE /projects/{project}/datasets/{dataset}/tables/{table}", f.deleteTable)
+	mux.HandleFunc("POST /projects/{project}/datasets/{dataset}/tables/{table}/insertAll", f.insertAll)
+	mux.HandleFunc("POST /upload/projects/{project}/jobs", f.insertLoadJob)
+	mux.HandleFunc("GET /projects/{project}/jobs/{job}", f.getJob)
+	mux.HandleFunc("POST /projects/{project}/queries", f.runQuery)
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Accept paths with the API's base path as well.
+		r.URL.Path = strings.Replace(r.URL.Path, "/bigquery/v2", "", 1)
+		r.URL.RawPath = ""
+		mux.ServeHTTP(w, r)
+	}))
+	return f
+}
+
+// Host returns the host:port to use as emulator.bigquery.
+func (f *BigQuery) Host() string {
+	return strings.TrimPrefix(f.server.URL, "http://")
+}
+
+func (f *BigQuery) Close() {
+	f.server.Close()
+}
+
+// CreateTable adds an empty table with schema, as if created beforehand.
+func (f *BigQuery) CreateTable(project, dataset, table string, schema *bq.TableSchema) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tables[tableKey(project, dataset, table)] = &fakeTable{meta: &bq.Table{
+		TableReference: &bq.TableReference{ProjectId: project, DatasetId: dataset, TableId: table},
+		Schema:         schema,
+		Etag:           "1",
+	}}
+}
+
+// Table returns the metadata of a table, or nil if it does not exist.
+func (f *BigQuery) Table(project, dataset, table string) *bq.Table {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if t, ok := f.tables[tableKey(project, dataset, table)]; ok {
+		return t.meta
+	}
+	return nil
+}
+
+// Tables returns the "project.dataset.table" names of every table, sorted.
+func (f *BigQuery) Tables() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := make([]string, 0, len(f.tables))
+	for name := range f.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Rows returns the rows inserted into a table as decoded JSON objects.
+func (f *BigQuery) Rows(project, dataset, table string) []map[string]any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if t, ok := f.tables[tableKey(project, dataset, table)]; ok {
+		return append([]map[string]any(nil), t.rows...)
+	}
+	return nil
+}
+
+// InsertCalls returns the number of insertAll requests received.
+func (f *BigQuery) InsertCalls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.insertCalls
+}
+
+// SetRows replaces the rows of a table, creating it without a schema when it
+// does not exist. Rows hold decoded JSON values like those returned by Rows.
+func (f *BigQuery) SetRows(project, dataset, table string, rows []map[string]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := tableKey(project, dataset, table)
+	t, ok := f.tables[key]
+	if !ok {
+		t = &fakeTable{meta: &bq.Table{
+			TableReference: &bq.TableReference{ProjectId: project, DatasetId: dataset, TableId: table},
+			Etag:           "1",
+		}}
+		f.tables[key] = t
+	}
+	t.rows = append([]map[string]any(nil), rows...)
+}
+
+// HandleQueries sets the function answering query jobs. Without one, every
+// query fails.
+func (f *BigQuery) HandleQueries(fn QueryFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.query = fn
+}
+
+// Queries returns the SQL of every query job received, in order.
+func (f *BigQuery) Queries() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.queries...)
+}
+
+// FailInserts makes the next len(codes) insertAll requests fail with the
+// given HTTP status codes. The client retries 5xx responses, so tests of
+// permanent failures should use 4xx codes.
+func (f *BigQuery) FailInserts(codes ...int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.insertErrs = append(f.insertErrs, codes...)
+}
+
+func tableKey(project, dataset, table string) string {
+	return project + "." + dataset + "." + table
+}
+
+func (f *BigQuery) getTable(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.tables[tableKey(r.PathValue("project"), r.PathValue("dataset"), r.PathValue("table"))]
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", "Not found: Table "+r.PathValue("table"))
+		return
+	}
+	writeJSON(w, t.meta)
+}
+
+func (f *BigQuery) listTables(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	prefix := r.PathValue("project") + "." + r.PathValue("dataset") + "."
+	list := &bq.TableList{}
+	for name, t := range f.tables {
+		if strings.HasPrefix(name, prefix) {
+			list.Tables = append(list.Tables, &bq.TableListTables{TableReference: t.meta.TableReference, Type: "TABLE"})
+		}
+	}
+	sort.Slice(list.Tables, func(i, j int) bool {
+		return list.Tables[i].TableReference.TableId < list.Tables[j].TableReference.TableId
+	})
+	list.TotalItems = int64(len(list.Tables))
+	writeJSON(w, list)
+}
+
+func (f *BigQuery) insertTable(w http.ResponseWriter, r *http.Request) {
+	var meta bq.Table
+	if err := json.NewDecoder(r.Body).Decode(&meta); err != nil || meta.TableReference == nil {
+		writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("invalid table: %v", err))
+		return
+	}
+	meta.TableReference.ProjectId = r.PathValue("project")
+	meta.TableReference.DatasetId = r.PathValue("dataset")
+	meta.Etag = "1"
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := tableKey(meta.TableReference.ProjectId, meta.TableReference.DatasetId, meta.TableReference.TableId)
+	if _, ok := f.tables[key]; ok {
+		writeError(w, http.StatusConflict, "duplicate", "Already Exists: Table "+key)
+		return
+	}
+	f.tables[key] = &fakeTable{meta: &meta}
+	writeJSON(w, &meta)
+}
+
+func (f *BigQuery) updateTable(w http.ResponseWriter, r *http.Request) {
+	var update bq.Table
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("invalid table: %v", err))
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.tables[tableKey(r.PathValue("project"), r.PathValue("dataset"), r.PathValue("table"))]
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", "Not found: Table "+r.PathValue("table"))
+		return
+	}
+	if match := r.Header.Get("If-Match"); match != "" && match != t.meta.Etag {
+		writeError(w, http.StatusPreconditionFailed, "conditionNotMet", "Precondition check failed.")
+		return
+	}
+	if update.Schema != nil {
+		t.meta.Schema = update.Schema
+	}
+	if update.TimePartitioning != nil {
+		t.meta.TimePartitioning = update.TimePartitioning
+	}
+	t.meta.Etag = fmt.Sprint(len(t.meta.Etag) + 1)
+	writeJSON(w, t.meta)
+}
+
+func (f *BigQuery) insertAll(w http.ResponseWriter, r *http.Request) {
+	var req bq.TableDataInsertAllRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.insertCalls++
+	if len(f.insertErrs) > 0 {
+		code := f.insertErrs[0]
+		f.insertErrs = f.insertErrs[1:]
+		writeError(w, code, "injected", "injected failure")
+		return
+	}
+	t, ok := f.tables[tableKey(r.PathValue("project"), r.PathValue("dataset"), r.PathValue("table"))]
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", "Not found: Table "+r.PathValue("table"))
+		return
+	}
+	for _, row := range req.Rows {
+		values := make(map[string]any, len(row.Json))
+		for k, v := range row.Json {
+			values[k] = v
+		}
+		t.rows = append(t.rows, values)
+	}
+	writeJSON(w, &bq.TableDataInsertAllResponse{Kind: "bigquery#tableDataInsertAllResponse"})
+}
+
+func (f *BigQuery) deleteTable(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := tableKey(r.PathValue("project"), r.PathValue("dataset"), r.PathValue("table"))
+	if _, ok := f.tables[key]; !ok {
+		writeError(w, http.StatusNotFound, "notFound", "Not found: Table "+r.PathValue("table"))
+		return
+	}
+	delete(f.tables, key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// insertLoadJob runs a load job sent as a multipart upload: the job
+// configuration followed by newline-delimited JSON rows. Jobs complete
+// immediately.
+func (f *BigQuery) insertLoadJob(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || r.URL.Query().Get("uploadType") != "multipart" {
+		writeError(w, http.StatusBadRequest, "invalid", "only multipart uploads are supported")
+		return
+	}
+	parts := multipart.NewReader(r.Body, params["boundary"])
+	var job bq.Job
+	part, err := parts.NextPart()
+	if err == nil {
+		err = json.NewDecoder(part).Decode(&job)
+	}
+	if err != nil || job.Configuration == nil || job.Configuration.Load == nil {
+		writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("invalid load job: %v", err))
+		return
+	}
+	var rows []map[string]any
+	if part, err = parts.NextPart(); err == nil {
+		dec := json.NewDecoder(part)
+		for dec.More() {
+			var row map[string]any
+			if err = dec.Decode(&row); err != nil {
+				break
+			}
+			rows = append(rows, row)
+		}
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("invalid load data: %v", err))
+		return
+	}
+
+	load := job.Configuration.Load
+	ref := load.DestinationTable
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.tables[tableKey(ref.ProjectId, ref.DatasetId, ref.TableId)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", "Not found: Table "+ref.TableId)
+		return
+	}
+	if load.WriteDisposition == "WRITE_TRUNCATE" {
+		t.rows = nil
+	}
+	t.rows = append(t.rows, rows...)
+
+	if job.JobReference == nil {
+		job.JobReference = &bq.JobReference{JobId: fmt.Sprint("load-", len(f.jobs))}
+	}
+	job.JobReference.ProjectId = r.PathValue("project")
+	job.Status = &bq.JobStatus{State: "DONE"}
+	f.jobs[job.JobReference.JobId] = &job
+	writeJSON(w, &job)
+}
+
+func (f *BigQuery) getJob(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	job, ok := f.jobs[r.PathValue("job")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", "Not found: Job "+r.PathValue("job"))
+		return
+	}
+	writeJSON(w, job)
+}
+
+// runQuery answers jobs.query with the QueryFunc. Results are returned in
+// full without a job reference, so clients never page through them.
+func (f *BigQuery) runQuery(w http.ResponseWriter, r *http.Request) {
+	var req bq.QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	params := make(map[string]any, len(req.QueryParameters))
+	for _, p := range req.QueryParameters {
+		v, err := paramValue(p.ParameterType, p.ParameterValue)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalidQuery", fmt.Sprintf("parameter %s: %v", p.Name, err))
+			return
+		}
+		params[p.Name] = v
+	}
+
+	f.mu.Lock()
+	f.queries = append(f.queries, req.Query)
+	query := f.query
+	f.mu.Unlock()
+	if query == nil {
+		writeError(w, http.StatusBadRequest, "invalidQuery", "queries are not supported by this fake")
+		return
+	}
+	result, err := query(req.Query, params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalidQuery", err.Error())
+		return
+	}
+
+	res := &bq.QueryResponse{Kind: "bigquery#queryResponse", JobComplete: true}
+	if result != nil {
+		res.Schema = result.Schema
+		res.TotalRows = uint64(len(result.Rows))
+		for _, values := range result.Rows {
+			row := &bq.TableRow{}
+			for _, v := range values {
+				row.F = append(row.F, &bq.TableCell{V: cellValue(v)})
+			}
+			res.Rows = append(res.Rows, row)
+		}
+	}
+	writeJSON(w, res)
+}
+
+// paramValue decodes a query parameter.
+func paramValue(typ *bq.QueryParameterType, value *bq.QueryParameterValue) (any, error) {
+	if typ == nil || value == nil {
+		return nil, nil
+	}
+	if typ.Type == "ARRAY" {
+		values := make([]any, len(value.ArrayValues))
+		for i, v := range value.ArrayValues {
+			var err error
+			if values[i], err = paramValue(typ.ArrayType, v); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	switch typ.Type {
+	case "TIMESTAMP":
+		return time.Parse("2006-01-02 15:04:05.999999-07:00", value.Value)
+	case "INT64":
+		return strconv.ParseInt(value.Value, 10, 64)
+	case "FLOAT64":
+		return strconv.ParseFloat(value.Value, 64)
+	case "BOOL":
+		return strconv.ParseBool(value.Value)
+	default:
+		return value.Value, nil
+	}
+}
+
+// cellValue encodes a result value as the REST API does, with timestamps
+// in microseconds since the epoch.
+func cellValue(v any) any {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		return strconv.FormatInt(v.UnixMicro(), 10)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error in the format of Google APIs.
+func writeError(w http.ResponseWriter, code int, reason, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"code":    code,
+			"message": message,
+			"errors":  []map[string]string{{"reason": reason, "message": message}},
+		},
+	})
+}
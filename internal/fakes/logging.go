@@ -0,0 +1,212 @@
+// Package fakes provides in-process fakes of Cloud Logging and BigQuery so
+// the export pipeline can be tested without network access or credentials.
+// Point a configuration at them through its emulator section.
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/phaserunner03/logging/internal/filter"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Default and maximum ListLogEntries page sizes of the real service.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+// Logging is a fake of the Cloud Logging v2 ListLogEntries and
+// TailLogEntries RPCs serving entries added with Add.
+type Logging struct {
+	logpb.UnimplementedLoggingServiceV2Server
+
+	server   *grpc.Server
+	listener net.Listener
+
+	mu       sync.Mutex
+	entries  []*logpb.LogEntry
+	errs     []error
+	requests []*logpb.ListLogEntriesRequest
+	tails    map[chan *logpb.LogEntry]bool
+}
+
+// NewLogging starts a fake Logging service on a local port.
+func NewLogging() (*Logging, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %v", err)
+	}
+	f := &Logging{
+		server:   grpc.NewServer(),
+		listener: lis,
+		tails:    make(map[chan *logpb.LogEntry]bool),
+	}
+	logpb.RegisterLoggingServiceV2Server(f.server, f)
+	go f.server.Serve(lis)
+	return f, nil
+}
+
+// Addr returns the host:port to use as emulator.logging.
+func (f *Logging) Addr() string {
+	return f.listener.Addr().String()
+}
+
+// Close stops the server, ending open tails.
+func (f *Logging) Close() {
+	f.server.Stop()
+}
+
+// Add stores entries and streams them to open tails.
+func (f *Logging) Add(entries ...*logpb.LogEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries = append(f.entries, entries...)
+	for ch := range f.tails {
+		for _, e := range entries {
+			select {
+			case ch <- e:
+			default: // a tail that stopped reading loses entries
+			}
+		}
+	}
+}
+
+// FailNext makes the next len(errs) calls fail with errs in order, e.g.
+// status.Error(codes.Unavailable, "try again").
+func (f *Logging) FailNext(errs ...error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errs = append(f.errs, errs...)
+}
+
+// Requests returns every ListLogEntries request received, including failed ones.
+func (f *Logging) Requests() []*logpb.ListLogEntriesRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*logpb.ListLogEntriesRequest(nil), f.requests...)
+}
+
+// injectedError pops the next injected error. f.mu must be held.
+func (f *Logging) injectedError() error {
+	if len(f.errs) == 0 {
+		return nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+func (f *Logging) ListLogEntries(_ context.Context, req *logpb.ListLogEntriesRequest) (*logpb.ListLogEntriesResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, req)
+	if err := f.injectedError(); err != nil {
+		return nil, err
+	}
+
+	if len(req.GetResourceNames()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "resource_names is required")
+	}
+	expr, err := filter.Parse(req.GetFilter())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid filter: %v", err)
+	}
+
+	var matched []*logpb.LogEntry
+	for _, e := range f.entries {
+		if inResources(e, req.GetResourceNames()) && filter.Match(expr, e) {
+			matched = append(matched, e)
+		}
+	}
+	desc := strings.HasSuffix(strings.TrimSpace(req.GetOrderBy()), " desc")
+	sort.SliceStable(matched, func(i, j int) bool {
+		ti, tj := matched[i].GetTimestamp().AsTime(), matched[j].GetTimestamp().AsTime()
+		if desc {
+			return ti.After(tj)
+		}
+		return ti.Before(tj)
+	})
+
+	size := int(req.GetPageSize())
+	if size <= 0 {
+		size = defaultPageSize
+	}
+	size = min(size, maxPageSize)
+	offset := 0
+	if token := req.GetPageToken(); token != "" {
+		if offset, err = strconv.Atoi(token); err != nil || offset < 0 || offset > len(matched) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page token %q", token)
+		}
+	}
+
+	resp := &logpb.ListLogEntriesResponse{Entries: matched[offset:min(offset+size, len(matched))]}
+	if offset+size < len(matched) {
+		resp.NextPageToken = strconv.Itoa(offset + size)
+	}
+	return resp, nil
+}
+
+// TailLogEntries streams entries added after the first request that match
+// its resource names and filter, one entry per response.
+func (f *Logging) TailLogEntries(stream logpb.LoggingServiceV2_TailLogEntriesServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	expr, err := filter.Parse(req.GetFilter())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid filter: %v", err)
+	}
+
+	ch := make(chan *logpb.LogEntry, 1024)
+	f.mu.Lock()
+	if err := f.injectedError(); err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	f.tails[ch] = true
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		delete(f.tails, ch)
+		f.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e := <-ch:
+			if !inResources(e, req.GetResourceNames()) || !filter.Match(expr, e) {
+				continue
+			}
+			if err := stream.Send(&logpb.TailLogEntriesResponse{Entries: []*logpb.LogEntry{e}}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// inResources reports whether e belongs to one of the resource names. Only
+// projects are checked; other resource names match every entry.
+func inResources(e *logpb.LogEntry, names []string) bool {
+	for _, name := range names {
+		if !strings.HasPrefix(name, "projects/") || strings.Contains(name, "/locations/") {
+			return true
+		}
+		if strings.HasPrefix(e.GetLogName(), name+"/logs/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package fakes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	logging "cloud.google.com/go/logging/apiv2"
+	"github.com/phaserunner03/logging/internal/auth"
+	logtypepb "google.golang.org/genproto/googleapis/logging/type"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+)
+
+func TestTailLogEntries(t *testing.T) {
+	f, err := NewLogging()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := logging.NewClient(ctx, auth.GRPCEmulatorOptions(f.Addr())...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	stream, err := client.TailLogEntries(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stream.Send(&logpb.TailLogEntriesRequest{ResourceNames: []string{"projects/p"}, Filter: `severity>=ERROR`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Entries are only streamed once the tail is registered.
+	for registered := false; !registered; time.Sleep(10 * time.Millisecond) {
+		f.mu.Lock()
+		registered = len(f.tails) > 0
+		f.mu.Unlock()
+	}
+
+	f.Add(
+		&logpb.LogEntry{LogName: "projects/p/logs/app", InsertId: "info", Severity: logtypepb.LogSeverity_INFO},
+		&logpb.LogEntry{LogName: "projects/p/logs/app", InsertId: "error", Severity: logtypepb.LogSeverity_ERROR},
+	)
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetEntries()) != 1 || resp.GetEntries()[0].GetInsertId() != "error" {
+		t.Errorf("tail received %v, want only the ERROR entry", resp.GetEntries())
+	}
+}
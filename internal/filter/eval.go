@@ -0,0 +1,343 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logtypepb "google.golang.org/genproto/googleapis/logging/type"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Match reports whether entry satisfies e. A nil expression matches everything.
+func Match(e Expr, entry *logpb.LogEntry) bool {
+	if e == nil {
+		return true
+	}
+	return e.match(&view{entry: entry})
+}
+
+func (a AndExpr) match(v *view) bool {
+	for _, e := range a {
+		if !e.match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (o OrExpr) match(v *view) bool {
+	for _, e := range o {
+		if e.match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n NotExpr) match(v *view) bool { return !n.Expr.match(v) }
+
+func (t Term) match(v *view) bool {
+	needle := strings.ToLower(t.Value)
+	var found bool
+	walk(v.generic(), func(s string) {
+		found = found || strings.Contains(strings.ToLower(s), needle)
+	})
+	return found
+}
+
+func (c Comparison) match(v *view) bool {
+	values, ok := v.lookup(splitPath(c.Field))
+	if !ok {
+		return c.Op == OpNe || c.Op == OpNotRegex
+	}
+	if c.Op == OpHas && c.Value == "*" {
+		return true
+	}
+	// A negated operator must hold for every value of a repeated field, the
+	// others for at least one.
+	if c.Op == OpNe || c.Op == OpNotRegex {
+		for _, got := range values {
+			if !compare(c.Field, got, c.Op, c.Value) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, got := range values {
+		if compare(c.Field, got, c.Op, c.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+func compare(field, got, op, want string) bool {
+	switch op {
+	case OpHas:
+		return strings.Contains(strings.ToLower(got), strings.ToLower(want))
+	case OpRegex, OpNotRegex:
+		re, err := compileRegex(want)
+		if err != nil {
+			return false
+		}
+		return re.MatchString(got) == (op == OpRegex)
+	}
+
+	c, ok := order(field, got, want)
+	if !ok {
+		return op == OpNe
+	}
+	switch op {
+	case OpEq:
+		return c == 0
+	case OpNe:
+		return c != 0
+	case OpLt:
+		return c < 0
+	case OpLe:
+		return c <= 0
+	case OpGt:
+		return c > 0
+	}
+	return c >= 0
+}
+
+// order compares got with want as severities, timestamps, numbers or strings,
+// depending on the field and values.
+func order(field, got, want string) (int, bool) {
+	switch field {
+	case "severity":
+		g, ok1 := severityRank(got)
+		w, ok2 := severityRank(want)
+		return g - w, ok1 && ok2
+	case "timestamp", "receiveTimestamp":
+		g, err1 := parseTime(got)
+		w, err2 := parseTime(want)
+		if err1 != nil || err2 != nil {
+			return 0, false
+		}
+		return g.Compare(w), true
+	}
+	if g, err := strconv.ParseFloat(got, 64); err == nil {
+		if w, err := strconv.ParseFloat(want, 64); err == nil {
+			switch {
+			case g < w:
+				return -1, true
+			case g > w:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	return strings.Compare(got, want), true
+}
+
+func severityRank(s string) (int, bool) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, true
+	}
+	n, ok := logtypepb.LogSeverity_value[strings.ToUpper(s)]
+	return int(n), ok
+}
+
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+var regexCache sync.Map
+
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexCache.Store(pattern, re)
+	return re, nil
+}
+
+func checkRegex(pattern string) error {
+	_, err := compileRegex(pattern)
+	return err
+}
+
+// splitPath splits a field path on dots outside quoted segments.
+func splitPath(field string) []string {
+	var parts []string
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(field); i++ {
+		switch c := field[i]; {
+		case c == '"':
+			quoted = !quoted
+		case c == '\\' && quoted && i+1 < len(field):
+			i++
+			b.WriteByte(field[i])
+		case c == '.' && !quoted:
+			parts = append(parts, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return append(parts, b.String())
+}
+
+// view resolves field paths of one entry, converting it to a generic JSON
+// value only for fields without a direct accessor.
+type view struct {
+	entry  *logpb.LogEntry
+	json   map[string]any
+	loaded bool
+}
+
+// lookup returns the string forms of the value at path; repeated fields yield
+// one value per element.
+func (v *view) lookup(path []string) ([]string, bool) {
+	e := v.entry
+	one := func(s string, ok bool) ([]string, bool) { return []string{s}, ok }
+
+	switch path[0] {
+	case "timestamp":
+		if len(path) == 1 {
+			return one(e.GetTimestamp().AsTime().Format(time.RFC3339Nano), e.GetTimestamp() != nil)
+		}
+	case "receiveTimestamp":
+		if len(path) == 1 {
+			return one(e.GetReceiveTimestamp().AsTime().Format(time.RFC3339Nano), e.GetReceiveTimestamp() != nil)
+		}
+	case "severity":
+		if len(path) == 1 {
+			return one(e.GetSeverity().String(), true)
+		}
+	case "logName":
+		return one(e.GetLogName(), e.GetLogName() != "")
+	case "insertId":
+		return one(e.GetInsertId(), e.GetInsertId() != "")
+	case "trace":
+		return one(e.GetTrace(), e.GetTrace() != "")
+	case "spanId":
+		return one(e.GetSpanId(), e.GetSpanId() != "")
+	case "textPayload":
+		return one(e.GetTextPayload(), e.GetTextPayload() != "")
+	case "resource":
+		if len(path) == 2 && path[1] == "type" {
+			return one(e.GetResource().GetType(), e.GetResource() != nil)
+		}
+		if len(path) == 3 && path[1] == "labels" {
+			l, ok := e.GetResource().GetLabels()[path[2]]
+			return one(l, ok)
+		}
+	case "labels":
+		if len(path) == 2 {
+			l, ok := e.GetLabels()[path[1]]
+			return one(l, ok)
+		}
+	case "jsonPayload":
+		if e.GetJsonPayload() == nil {
+			return nil, false
+		}
+		return leaves(structValue(e.GetJsonPayload(), path[1:]))
+	}
+	return leaves(walkPath(v.generic(), path))
+}
+
+// generic returns the entry as decoded protojson, with proto field names in
+// their JSON (camelCase) form.
+func (v *view) generic() map[string]any {
+	if v.loaded {
+		return v.json
+	}
+	v.loaded = true
+	data, err := protojson.Marshal(v.entry)
+	if err == nil {
+		json.Unmarshal(data, &v.json)
+	}
+	return v.json
+}
+
+func structValue(s *structpb.Struct, path []string) (any, bool) {
+	if len(path) == 0 {
+		return s.AsMap(), true
+	}
+	v, ok := s.GetFields()[path[0]]
+	if !ok {
+		return nil, false
+	}
+	if len(path) == 1 {
+		return v.AsInterface(), true
+	}
+	if inner := v.GetStructValue(); inner != nil {
+		return structValue(inner, path[1:])
+	}
+	return nil, false
+}
+
+func walkPath(m map[string]any, path []string) (any, bool) {
+	var cur any = m
+	for _, key := range path {
+		obj, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// leaves converts a JSON value to the string forms comparisons work on.
+func leaves(v any, ok bool) ([]string, bool) {
+	if !ok || v == nil {
+		return nil, false
+	}
+	switch val := v.(type) {
+	case string:
+		return []string{val}, true
+	case []any:
+		var out []string
+		for _, item := range val {
+			if s, ok := leaves(item, true); ok {
+				out = append(out, s...)
+			}
+		}
+		return out, len(out) > 0
+	case map[string]any:
+		data, _ := json.Marshal(val)
+		return []string{string(data)}, true
+	case float64:
+		return []string{strconv.FormatFloat(val, 'f', -1, 64)}, true
+	}
+	return []string{fmt.Sprint(v)}, true
+}
+
+// walk calls fn for every string and number in v.
+func walk(v any, fn func(string)) {
+	switch val := v.(type) {
+	case string:
+		fn(val)
+	case float64:
+		fn(strconv.FormatFloat(val, 'f', -1, 64))
+	case []any:
+		for _, item := range val {
+			walk(item, fn)
+		}
+	case map[string]any:
+		for _, item := range val {
+			walk(item, fn)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Package filter builds, parses and evaluates Cloud Logging query language
+// filters (https://cloud.google.com/logging/docs/view/logging-query-language).
+//
+// Expressions built with the helpers in this file render to filter strings
+// accepted by the Logging API, and Parse turns such strings back into
+// expressions. Match evaluates an expression locally against a
+// *logpb.LogEntry, so the same filter selects entries from the API, from
+// files and in tests.
+package filter
+
+import (
+	"strings"
+	"time"
+)
+
+// Comparison operators.
+const (
+	OpEq       = "="
+	OpNe       = "!="
+	OpLt       = "<"
+	OpLe       = "<="
+	OpGt       = ">"
+	OpGe       = ">="
+	OpHas      = ":"
+	OpRegex    = "=~"
+	OpNotRegex = "!~"
+)
+
+// Expr is a filter expression. Use Match to evaluate it.
+type Expr interface {
+	// String renders the expression in the Logging query language.
+	String() string
+	match(v *view) bool
+}
+
+// Comparison compares the value of a field path such as "resource.type" or
+// "jsonPayload.user.id" with a value using Op.
+type Comparison struct {
+	Field string
+	Op    string
+	Value string
+}
+
+// Path returns the segments of c.Field, with quoted segments unquoted.
+func (c Comparison) Path() []string { return splitPath(c.Field) }
+
+func (c Comparison) String() string {
+	value := Quote(c.Value)
+	if c.Op == OpHas && c.Value == "*" {
+		value = "*"
+	}
+	return c.Field + c.Op + value
+}
+
+// Term is a bare value searched for in every field of an entry.
+type Term struct {
+	Value string
+}
+
+func (t Term) String() string { return Quote(t.Value) }
+
+// AndExpr matches when all of its operands match.
+type AndExpr []Expr
+
+func (a AndExpr) String() string { return join(a, " AND ") }
+
+// OrExpr matches when any of its operands matches.
+type OrExpr []Expr
+
+func (o OrExpr) String() string { return join(o, " OR ") }
+
+// NotExpr matches when its operand does not.
+type NotExpr struct {
+	Expr Expr
+}
+
+func (n NotExpr) String() string { return "NOT " + group(n.Expr) }
+
+func join(exprs []Expr, sep string) string {
+	parts := make([]string, len(exprs))
+	for i, e := range exprs {
+		parts[i] = group(e)
+	}
+	return strings.Join(parts, sep)
+}
+
+// group parenthesizes nested AND and OR expressions. Only OR operands of an
+// AND strictly need it, but explicit grouping is easier to read.
+func group(e Expr) string {
+	switch e.(type) {
+	case AndExpr, OrExpr:
+		return "(" + e.String() + ")"
+	}
+	return e.String()
+}
+
+// And combines exprs so that all must match, flattening nested ANDs and
+// dropping nil operands. And() matches everything.
+func And(exprs ...Expr) Expr {
+	return combine(exprs, func(e Expr) ([]Expr, bool) { a, ok := e.(AndExpr); return a, ok }, func(es []Expr) Expr { return AndExpr(es) })
+}
+
+// Or combines exprs so that any must match, flattening nested ORs and
+// dropping nil operands.
+func Or(exprs ...Expr) Expr {
+	return combine(exprs, func(e Expr) ([]Expr, bool) { o, ok := e.(OrExpr); return o, ok }, func(es []Expr) Expr { return OrExpr(es) })
+}
+
+func combine(exprs []Expr, flatten func(Expr) ([]Expr, bool), build func([]Expr) Expr) Expr {
+	var out []Expr
+	for _, e := range exprs {
+		if e == nil {
+			continue
+		}
+		if inner, ok := flatten(e); ok {
+			out = append(out, inner...)
+			continue
+		}
+		out = append(out, e)
+	}
+	if len(out) == 1 {
+		return out[0]
+	}
+	return build(out)
+}
+
+// Not negates e.
+func Not(e Expr) Expr { return NotExpr{Expr: e} }
+
+func Eq(field, value string) Expr { return Comparison{field, OpEq, value} }
+func Ne(field, value string) Expr { return Comparison{field, OpNe, value} }
+func Lt(field, value string) Expr { return Comparison{field, OpLt, value} }
+func Le(field, value string) Expr { return Comparison{field, OpLe, value} }
+func Gt(field, value string) Expr { return Comparison{field, OpGt, value} }
+func Ge(field, value string) Expr { return Comparison{field, OpGe, value} }
+
+// Has matches fields containing value as a case-insensitive substring, or,
+// with value "*", fields that are present.
+func Has(field, value string) Expr { return Comparison{field, OpHas, value} }
+
+// Regex matches fields containing a match of the RE2 pattern.
+func Regex(field, pattern string) Expr { return Comparison{field, OpRegex, pattern} }
+
+// AnyOf matches entries whose field equals one of values.
+func AnyOf(field string, values ...string) Expr {
+	exprs := make([]Expr, len(values))
+	for i, v := range values {
+		exprs[i] = Eq(field, v)
+	}
+	return Or(exprs...)
+}
+
+// TimeRange matches entries with start <= timestamp <= end. Zero bounds are
+// left open.
+func TimeRange(start, end time.Time) Expr {
+	var exprs []Expr
+	if !start.IsZero() {
+		exprs = append(exprs, Ge("timestamp", start.UTC().Format(time.RFC3339Nano)))
+	}
+	if !end.IsZero() {
+		exprs = append(exprs, Le("timestamp", end.UTC().Format(time.RFC3339Nano)))
+	}
+	return And(exprs...)
+}
+
+// Quote returns s as a double-quoted filter string, escaping quotes and
+// backslashes.
+func Quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,131 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+	logtypepb "google.golang.org/genproto/googleapis/logging/type"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func testEntry(t *testing.T) *logpb.LogEntry {
+	t.Helper()
+	payload, err := structpb.NewStruct(map[string]any{
+		"user":    map[string]any{"id": "u1"},
+		"latency": 250,
+		"tags":    []any{"checkout", "beta"},
+		"message": "Payment declined for order 42",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &logpb.LogEntry{
+		LogName:   "projects/p/logs/app",
+		Resource:  &mrpb.MonitoredResource{Type: "cloud_run_revision", Labels: map[string]string{"service_name": "api"}},
+		Labels:    map[string]string{"k8s-pod/app": "web"},
+		Timestamp: timestamppb.New(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)),
+		Severity:  logtypepb.LogSeverity_WARNING,
+		HttpRequest: &logtypepb.HttpRequest{
+			RequestMethod: "POST",
+			Status:        502,
+			RequestUrl:    "https://example.com/checkout?id=1",
+		},
+		Payload: &logpb.LogEntry_JsonPayload{JsonPayload: payload},
+	}
+}
+
+func TestMatch(t *testing.T) {
+	e := testEntry(t)
+	for f, want := range map[string]bool{
+		``:                                   true,
+		`resource.type="cloud_run_revision"`: true,
+		`resource.labels.service_name="api" AND timestamp >= "2024-05-01T00:00:00Z" AND timestamp <= "2024-05-02T00:00:00Z"`: true,
+		`timestamp > "2024-05-01T12:00:00Z"`: false,
+		`timestamp >= "2024-05-01"`:          true,
+		`severity>=WARNING`:                  true,
+		`severity>=ERROR`:                    false,
+		`severity=(ERROR OR WARNING)`:        true,
+		`(resource.type="gce_instance" OR resource.type="cloud_run_revision") AND NOT severity=DEBUG`: true,
+		`jsonPayload.user.id="u1"`:                true,
+		`-jsonPayload.user.id="u1"`:               false,
+		`jsonPayload.latency > 100`:               true,
+		`jsonPayload.latency < 99.5`:              false,
+		`jsonPayload.tags="beta"`:                 true,
+		`jsonPayload.tags!="beta"`:                false,
+		`jsonPayload.message:"DECLINED"`:          true,
+		`jsonPayload.message=~"order \d+$"`:       true,
+		`jsonPayload.message!~"^Payment"`:         false,
+		`jsonPayload.user:*`:                      true,
+		`jsonPayload.missing:*`:                   false,
+		`httpRequest.status>=500`:                 true,
+		`httpRequest.requestMethod="GET"`:         false,
+		`labels."k8s-pod/app"="web"`:              true,
+		`logName:"app"`:                           true,
+		`resource.labels.missing="x"`:             false,
+		`resource.labels.missing!="x"`:            true,
+		`declined`:                                true,
+		`"order 42" severity=WARNING`:             true,
+		`refunded OR jsonPayload.user.id=u2`:      false,
+		`NOT (severity=INFO OR severity=WARNING)`: false,
+	} {
+		expr, err := Parse(f)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", f, err)
+			continue
+		}
+		if got := Match(expr, e); got != want {
+			t.Errorf("filter %q matched = %v, want %v", f, got, want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for f, want := range map[string]string{
+		`severity=`:                 "missing value",
+		`(severity=ERROR`:           "missing )",
+		`severity=ERROR)`:           "unexpected",
+		`textPayload="unterminated`: "unterminated string",
+		`a == b`:                    "unknown operator",
+		`jsonPayload.x=~"("`:        "jsonPayload.x",
+		`severity=ERROR AND`:        "unexpected end",
+		`severity=(ERROR severity)`: "value list",
+		`textPayload<>"x"`:          "missing value",
+	} {
+		_, err := Parse(f)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Parse(%q) error = %v, want one containing %q", f, err, want)
+		}
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	exprs := []Expr{
+		And(
+			AnyOf("resource.type", "cloud_run_revision", "k8s_container"),
+			Eq("resource.labels.service_name", `say "hi"\now`),
+			TimeRange(start, start.Add(time.Hour)),
+		),
+		Or(Not(Has("textPayload", "timeout")), Regex("jsonPayload.path", `^/api/v\d+`), Has("trace", "*")),
+		And(Gt("httpRequest.status", "499"), Ne("severity", "DEBUG"), Term{Value: "checkout"}),
+	}
+	for _, e := range exprs {
+		parsed, err := Parse(e.String())
+		if err != nil {
+			t.Errorf("Parse(%q): %v", e, err)
+			continue
+		}
+		if parsed.String() != e.String() {
+			t.Errorf("round trip changed filter:\n got  %s\n want %s", parsed, e)
+		}
+	}
+
+	want := `(resource.type="a" OR resource.type="b") AND timestamp>="2024-05-01T00:00:00Z"`
+	if got := And(AnyOf("resource.type", "a", "b"), TimeRange(start, time.Time{}), nil).String(); got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+}
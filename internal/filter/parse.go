@@ -0,0 +1,282 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokLParen
+	tokRParen
+	tokOp
+	tokNot // "-" prefix
+	tokString
+	tokWord
+)
+
+type token struct {
+	kind tokenKind
+	text string // unquoted for strings
+	pos  int
+}
+
+// Parse parses a filter in the Logging query language. It supports
+// comparisons (=, !=, <, <=, >, >=, :, =~, !~) of field paths with quoted or
+// bare values, value lists such as severity=(ERROR OR CRITICAL), bare search
+// terms, AND, OR, NOT, "-" negation, implicit AND and parentheses. The empty
+// filter matches every entry.
+func Parse(s string) (Expr, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	if p.peek().kind == tokEOF {
+		return And(), nil
+	}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", t.text, t.pos)
+	}
+	return e, nil
+}
+
+// Validate reports whether s is a filter Parse accepts.
+func Validate(s string) error {
+	_, err := Parse(s)
+	return err
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func isKeyword(t token, kw string) bool { return t.kind == tokWord && t.text == kw }
+
+func (p *parser) or() (Expr, error) {
+	exprs := []Expr{}
+	for {
+		e, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+		if !isKeyword(p.peek(), "OR") {
+			return Or(exprs...), nil
+		}
+		p.next()
+	}
+}
+
+// and parses terms joined by AND or by juxtaposition.
+func (p *parser) and() (Expr, error) {
+	exprs := []Expr{}
+	for {
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+
+		t := p.peek()
+		if isKeyword(t, "AND") {
+			p.next()
+			continue
+		}
+		if t.kind == tokEOF || t.kind == tokRParen || isKeyword(t, "OR") {
+			return And(exprs...), nil
+		}
+	}
+}
+
+func (p *parser) unary() (Expr, error) {
+	if t := p.peek(); t.kind == tokNot || isKeyword(t, "NOT") {
+		p.next()
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return Not(e), nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (Expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if r := p.next(); r.kind != tokRParen {
+			return nil, fmt.Errorf("missing ) for ( at offset %d", t.pos)
+		}
+		return e, nil
+	case tokString:
+		return Term{Value: t.text}, nil
+	case tokWord:
+		if isKeyword(t, "AND") || isKeyword(t, "OR") {
+			return nil, fmt.Errorf("unexpected %s at offset %d", t.text, t.pos)
+		}
+		if p.peek().kind != tokOp {
+			return Term{Value: t.text}, nil
+		}
+		op := p.next()
+		return p.value(t.text, op)
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of filter")
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", t.text, t.pos)
+}
+
+// value parses the right-hand side of a comparison: a value or a
+// parenthesized list of values joined by OR or AND.
+func (p *parser) value(field string, op token) (Expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString, tokWord:
+		if op.text == OpRegex || op.text == OpNotRegex {
+			if err := checkRegex(t.text); err != nil {
+				return nil, fmt.Errorf("%s at offset %d: %v", field, t.pos, err)
+			}
+		}
+		return Comparison{Field: field, Op: op.text, Value: t.text}, nil
+	case tokLParen:
+		var exprs []Expr
+		combine := Or
+		for {
+			e, err := p.value(field, op)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, e)
+			next := p.next()
+			switch {
+			case next.kind == tokRParen:
+				return combine(exprs...), nil
+			case isKeyword(next, "OR"):
+			case isKeyword(next, "AND"):
+				combine = And
+			default:
+				return nil, fmt.Errorf("expected OR, AND or ) in value list at offset %d", next.pos)
+			}
+		}
+	}
+	return nil, fmt.Errorf("missing value after %s%s at offset %d", field, op.text, op.pos)
+}
+
+// tokenize splits a filter into tokens, ending with tokEOF.
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	r := []rune(s)
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case strings.ContainsRune("=<>!:", c):
+			j := i + 1
+			if j < len(r) && (r[j] == '=' || r[j] == '~') && c != ':' {
+				j++
+			}
+			op := string(r[i:j])
+			switch op {
+			case OpEq, OpNe, OpLt, OpLe, OpGt, OpGe, OpHas, OpRegex, OpNotRegex:
+			default:
+				return nil, fmt.Errorf("unknown operator %q at offset %d", op, i)
+			}
+			tokens = append(tokens, token{tokOp, op, i})
+			i = j
+		case c == '"':
+			text, j, err := readQuoted(r, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{tokString, text, i})
+			i = j
+		case c == '-' && i+1 < len(r) && !unicode.IsSpace(r[i+1]) && (len(tokens) == 0 || tokens[len(tokens)-1].kind != tokOp):
+			tokens = append(tokens, token{tokNot, "-", i})
+			i++
+		default:
+			// A word, which may be a field path with quoted segments
+			// such as labels."k8s-pod/app".
+			var b strings.Builder
+			j := i
+			for j < len(r) && !unicode.IsSpace(r[j]) && !strings.ContainsRune("()=<>!:", r[j]) {
+				if r[j] == '"' {
+					if j == i || r[j-1] != '.' {
+						break
+					}
+					text, k, err := readQuoted(r, j)
+					if err != nil {
+						return nil, err
+					}
+					b.WriteString(Quote(text))
+					j = k
+					continue
+				}
+				b.WriteRune(r[j])
+				j++
+			}
+			tokens = append(tokens, token{tokWord, b.String(), i})
+			i = j
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(r)}), nil
+}
+
+// readQuoted reads the string literal starting at r[i] and returns its
+// unescaped text and the offset after the closing quote.
+func readQuoted(r []rune, i int) (string, int, error) {
+	var b strings.Builder
+	for j := i + 1; j < len(r); j++ {
+		switch r[j] {
+		case '"':
+			return b.String(), j + 1, nil
+		case '\\':
+			j++
+			if j == len(r) {
+				break
+			}
+			switch r[j] {
+			case 'n':
+				b.WriteRune('\n')
+			case 't':
+				b.WriteRune('\t')
+			case '"', '\\':
+				b.WriteRune(r[j])
+			default: // keep other escapes, such as \d in regular expressions
+				b.WriteRune('\\')
+				b.WriteRune(r[j])
+			}
+		default:
+			b.WriteRune(r[j])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string at offset %d", i)
+}
@@ -0,0 +1,184 @@
+// Package logger configures log/slog to emit JSON understood by Cloud Logging's
+// structured logging agent: severity, message, logging.googleapis.com/labels and trace fields.
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attribute keys used consistently across the exporter. They are emitted as
+// Cloud Logging labels rather than as payload fields.
+const (
+	KeyService = "service"
+	KeyWindow  = "window"
+	KeyBatch   = "batch"
+	KeyStage   = "stage"
+)
+
+const (
+	labelsKey       = "logging.googleapis.com/labels"
+	traceKey        = "logging.googleapis.com/trace"
+	spanIDKey       = "logging.googleapis.com/spanId"
+	traceSampledKey = "logging.googleapis.com/trace_sampled"
+)
+
+var labelKeys = map[string]bool{
+	KeyService: true,
+	KeyWindow:  true,
+	KeyBatch:   true,
+	KeyStage:   true,
+}
+
+// LevelCritical maps to Cloud Logging's CRITICAL severity.
+const LevelCritical = slog.Level(12)
+
+// ParseLevel parses debug, info, warning/warn, error or critical (case-insensitive).
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	case "critical":
+		return LevelCritical, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// Setup installs a Cloud Logging compatible JSON logger as the slog default.
+// projectID is used to build fully qualified trace names and may be empty.
+func Setup(level, projectID string) error {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(New(os.Stderr, lvl, projectID))
+	return nil
+}
+
+// New returns a logger writing Cloud Logging structured JSON to w.
+func New(w io.Writer, level slog.Leveler, projectID string) *slog.Logger {
+	inner := slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: replaceAttr,
+	})
+	return slog.New(&cloudHandler{inner: inner, projectID: projectID})
+}
+
+// Window formats an export window for the KeyWindow attribute.
+func Window(startDate, endDate string) slog.Attr {
+	return slog.String(KeyWindow, startDate+"/"+endDate)
+}
+
+func replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	switch a.Key {
+	case slog.LevelKey:
+		return slog.String("severity", severity(a.Value.Any().(slog.Level)))
+	case slog.MessageKey:
+		a.Key = "message"
+	}
+	return a
+}
+
+func severity(level slog.Level) string {
+	switch {
+	case level >= LevelCritical:
+		return "CRITICAL"
+	case level >= slog.LevelError:
+		return "ERROR"
+	case level >= slog.LevelWarn:
+		return "WARNING"
+	case level >= slog.LevelInfo:
+		return "INFO"
+	}
+	return "DEBUG"
+}
+
+// cloudHandler moves label attributes into logging.googleapis.com/labels and
+// adds the trace of the active span, if any.
+type cloudHandler struct {
+	inner     slog.Handler
+	projectID string
+	labels    []slog.Attr
+}
+
+func (h *cloudHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *cloudHandler) Handle(ctx context.Context, r slog.Record) error {
+	labels := append([]slog.Attr(nil), h.labels...)
+	out := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		if labelKeys[a.Key] {
+			labels = append(labels, labelAttr(a))
+		} else {
+			out.AddAttrs(a)
+		}
+		return true
+	})
+
+	if len(labels) > 0 {
+		args := make([]any, len(labels))
+		for i, a := range labels {
+			args[i] = a
+		}
+		out.AddAttrs(slog.Group(labelsKey, args...))
+	}
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		traceName := sc.TraceID().String()
+		if h.projectID != "" {
+			traceName = "projects/" + h.projectID + "/traces/" + traceName
+		}
+		out.AddAttrs(
+			slog.String(traceKey, traceName),
+			slog.String(spanIDKey, sc.SpanID().String()),
+			slog.Bool(traceSampledKey, sc.IsSampled()),
+		)
+	}
+
+	return h.inner.Handle(ctx, out)
+}
+
+func (h *cloudHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.labels = append([]slog.Attr(nil), h.labels...)
+	var rest []slog.Attr
+	for _, a := range attrs {
+		if labelKeys[a.Key] {
+			clone.labels = append(clone.labels, labelAttr(a))
+		} else {
+			rest = append(rest, a)
+		}
+	}
+	if len(rest) > 0 {
+		clone.inner = h.inner.WithAttrs(rest)
+	}
+	return &clone
+}
+
+func (h *cloudHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.inner = h.inner.WithGroup(name)
+	return &clone
+}
+
+// labelAttr converts a to a string attribute, as Cloud Logging labels are string-valued.
+func labelAttr(a slog.Attr) slog.Attr {
+	return slog.String(a.Key, a.Value.Resolve().String())
+}
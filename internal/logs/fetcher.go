@@ -3,63 +3,260 @@ package logs
 import (
 	"context"
 	"fmt"
-	"log"
-	"github.com/phaserunner03/logging/configs"
+	"log/slog"
+	"time"
+
 	logging "cloud.google.com/go/logging/apiv2"
+	"github.com/googleapis/gax-go/v2"
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/auth"
+	"github.com/phaserunner03/logging/internal/filter"
+	"github.com/phaserunner03/logging/internal/logger"
+	"github.com/phaserunner03/logging/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	logpb "google.golang.org/genproto/googleapis/logging/v2"
+	grpccodes "google.golang.org/grpc/codes"
 )
 
-func FetchLogs(ctx context.Context, services []string, startDate, endDate string) ([]*logpb.LogEntry, error) {
-	
-	config, err := configs.LoadConfig()
-	
+// pageSize is the number of entries requested per ListLogEntries page.
+const pageSize = 1000
+
+func FetchLogs(ctx context.Context, config *configs.Config, services []string, startDate, endDate string) ([]*logpb.LogEntry, error) {
+	ctx, span := telemetry.Tracer().Start(ctx, "FetchLogs")
+	defer span.End()
+
+	var entries []*logpb.LogEntry
+	if config.Source.Input != "" {
+		all, err := readInput(config.Source.Input)
+		if err != nil {
+			return nil, err
+		}
+		for _, service := range services {
+			batch, err := matchInput(all, config, service, startDate, endDate)
+			if err != nil {
+				return nil, err
+			}
+			telemetry.EntriesFetched.WithLabelValues(service, telemetry.StageFetch).Add(float64(len(batch)))
+			entries = append(entries, batch...)
+		}
+		span.SetAttributes(attribute.Int("entries", len(entries)))
+		return entries, nil
+	}
+
+	logClient, err := newClient(ctx, config)
 	if err != nil {
-		log.Fatalf("Error loading configuration: %v", err)
+		return nil, err
 	}
-	credentials := config.Env.GCP_Credentials
-	projectID := config.Env.GCP_ProjectID
+	defer logClient.Close()
 
+	for _, service := range services {
+		req := listRequest(config, service, startDate, endDate)
+		it := logClient.ListLogEntries(ctx, req, retryOption(service))
+		pager := iterator.NewPager(it, pageSize, "")
 
+		for page := 1; ; page++ {
+			var batch []*logpb.LogEntry
+			next, err := fetchPage(ctx, pager, &batch, service, page)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				return nil, fmt.Errorf("error iterating log entries: %v", err)
+			}
+			entries = append(entries, batch...)
+			slog.DebugContext(ctx, "Fetched log entries page",
+				logger.KeyService, service,
+				logger.Window(startDate, endDate),
+				logger.KeyStage, telemetry.StageFetch,
+				logger.KeyBatch, page,
+				"entries", len(batch),
+			)
+			if next == "" {
+				break
+			}
+		}
+	}
 
-	if credentials == "" || projectID == "" {
-		return nil, fmt.Errorf("GCP_CREDENTIALS and GCP_PROJECT_ID environment variables must be set")
+	span.SetAttributes(attribute.Int("entries", len(entries)))
+	return entries, nil
+}
+
+// SamplePage fetches at most size of the newest entries matching the filter
+// FetchLogs would use for service. more reports whether further entries exist.
+func SamplePage(ctx context.Context, config *configs.Config, service, startDate, endDate string, size int) (entries []*logpb.LogEntry, more bool, err error) {
+	if config.Source.Input != "" {
+		all, err := readInput(config.Source.Input)
+		if err != nil {
+			return nil, false, err
+		}
+		if entries, err = matchInput(all, config, service, startDate, endDate); err != nil {
+			return nil, false, err
+		}
+		if len(entries) > size {
+			return entries[:size], true, nil
+		}
+		return entries, false, nil
 	}
 
-	logClient, err := logging.NewClient(ctx, option.WithCredentialsFile(credentials))
+	logClient, err := newClient(ctx, config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create logging client: %v", err)
+		return nil, false, err
 	}
 	defer logClient.Close()
 
-	var entries []*logpb.LogEntry
+	req := listRequest(config, service, startDate, endDate)
+	it := logClient.ListLogEntries(ctx, req, retryOption(service))
+	next, err := iterator.NewPager(it, size, "").NextPage(&entries)
+	if err != nil {
+		return nil, false, fmt.Errorf("error sampling log entries: %v", err)
+	}
+	return entries, next != "", nil
+}
 
-	for _, service := range services {
-		filter := fmt.Sprintf(
-			`resource.type="cloud_run_revision" AND resource.labels.service_name="%s" AND timestamp >= "%s" AND timestamp <= "%s"`,
-			service, startDate, endDate,
-		)
-
-		req := &logpb.ListLogEntriesRequest{
-			ResourceNames: []string{"projects/" + projectID},
-			Filter:        filter,
-			OrderBy:       "timestamp desc",
+// CountByHour counts the entries FetchLogs would return for service within
+// the window, per UTC hour, without keeping them in memory.
+func CountByHour(ctx context.Context, config *configs.Config, service, startDate, endDate string) (map[time.Time]int64, error) {
+	counts := make(map[time.Time]int64)
+	if config.Source.Input != "" {
+		all, err := readInput(config.Source.Input)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := matchInput(all, config, service, startDate, endDate)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			counts[e.GetTimestamp().AsTime().Truncate(time.Hour)]++
 		}
+		return counts, nil
+	}
 
-		it := logClient.ListLogEntries(ctx, req)
+	logClient, err := newClient(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	defer logClient.Close()
 
-		for {
-			entry, err := it.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				return nil, fmt.Errorf("error iterating log entries: %v", err)
-			}
-			entries = append(entries, entry)
+	it := logClient.ListLogEntries(ctx, listRequest(config, service, startDate, endDate), retryOption(service))
+	pager := iterator.NewPager(it, pageSize, "")
+	for page := 1; ; page++ {
+		var batch []*logpb.LogEntry
+		next, err := fetchPage(ctx, pager, &batch, service, page)
+		if err != nil {
+			return nil, fmt.Errorf("error iterating log entries: %v", err)
+		}
+		for _, e := range batch {
+			counts[e.GetTimestamp().AsTime().Truncate(time.Hour)]++
+		}
+		if next == "" {
+			return counts, nil
 		}
 	}
+}
 
-	return entries, nil
+// BuildFilter returns the Cloud Logging filter used to fetch a service's logs
+// within a window, including source.filter when set.
+func BuildFilter(config *configs.Config, service, startDate, endDate string) string {
+	f := filter.And(
+		filter.AnyOf("resource.type", config.Resource.Type...),
+		filter.Eq("resource.labels.service_name", service),
+		filter.Ge("timestamp", startDate),
+		filter.Le("timestamp", endDate),
+	).String()
+	if config.Source.Filter != "" {
+		f += " AND (" + config.Source.Filter + ")"
+	}
+	return f
+}
+
+// ResourceNames returns the resources FetchLogs queries: source.resource_names,
+// or the configured project when none are listed.
+func ResourceNames(config *configs.Config) []string {
+	if len(config.Source.ResourceNames) > 0 {
+		return config.Source.ResourceNames
+	}
+	return []string{"projects/" + config.GCP.ProjectID}
+}
+
+func listRequest(config *configs.Config, service, startDate, endDate string) *logpb.ListLogEntriesRequest {
+	return &logpb.ListLogEntriesRequest{
+		ResourceNames: ResourceNames(config),
+		Filter:        BuildFilter(config, service, startDate, endDate),
+		OrderBy:       "timestamp desc",
+	}
+}
+
+func newClient(ctx context.Context, config *configs.Config) (*logging.Client, error) {
+	var opts []option.ClientOption
+	if config.Emulator.Logging != "" {
+		opts = auth.GRPCEmulatorOptions(config.Emulator.Logging)
+	} else {
+		var err error
+		if opts, err = auth.ClientOptions(ctx, config.ReadIdentity(), auth.LoggingReadScope); err != nil {
+			return nil, err
+		}
+	}
+
+	logClient, err := logging.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create logging client: %v", err)
+	}
+	return logClient, nil
+}
+
+// fetchPage reads a single page of results, recording a span and latency for it.
+func fetchPage(ctx context.Context, pager *iterator.Pager, batch *[]*logpb.LogEntry, service string, page int) (string, error) {
+	_, span := telemetry.Tracer().Start(ctx, "FetchLogs.page")
+	defer span.End()
+	span.SetAttributes(attribute.String("service", service), attribute.Int("page", page))
+
+	start := time.Now()
+	next, err := pager.NextPage(batch)
+	telemetry.APILatency.WithLabelValues(service, telemetry.StageFetch).Observe(time.Since(start).Seconds())
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+
+	telemetry.EntriesFetched.WithLabelValues(service, telemetry.StageFetch).Add(float64(len(*batch)))
+	span.SetAttributes(attribute.Int("entries", len(*batch)))
+	return next, nil
+}
+
+// retryOption mirrors the client's default ListLogEntries retry policy while
+// counting every retry attempt.
+func retryOption(service string) gax.CallOption {
+	return gax.WithRetry(func() gax.Retryer {
+		return &countingRetryer{
+			service: service,
+			stage:   telemetry.StageFetch,
+			retryer: gax.OnCodes([]grpccodes.Code{
+				grpccodes.DeadlineExceeded,
+				grpccodes.Internal,
+				grpccodes.Unavailable,
+			}, gax.Backoff{
+				Initial:    100 * time.Millisecond,
+				Max:        60 * time.Second,
+				Multiplier: 1.30,
+			}),
+		}
+	})
+}
+
+type countingRetryer struct {
+	service string
+	stage   string
+	retryer gax.Retryer
+}
+
+func (r *countingRetryer) Retry(err error) (time.Duration, bool) {
+	pause, retry := r.retryer.Retry(err)
+	if retry {
+		telemetry.APIRetries.WithLabelValues(r.service, r.stage).Inc()
+	}
+	return pause, retry
 }
@@ -0,0 +1,178 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/fakes"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+	logtypepb "google.golang.org/genproto/googleapis/logging/type"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var windowStart = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+func newFakeLogging(t *testing.T) (*fakes.Logging, *configs.Config) {
+	t.Helper()
+	f, err := fakes.NewLogging()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(f.Close)
+
+	config := &configs.Config{}
+	config.GCP.ProjectID = "test-project"
+	config.Resource.Type = []string{"cloud_run_revision"}
+	config.Emulator.Logging = f.Addr()
+	return f, config
+}
+
+func entry(service string, ts time.Time, i int) *logpb.LogEntry {
+	return &logpb.LogEntry{
+		LogName:   "projects/test-project/logs/run.googleapis.com%2Fstdout",
+		Resource:  &mrpb.MonitoredResource{Type: "cloud_run_revision", Labels: map[string]string{"service_name": service}},
+		Timestamp: timestamppb.New(ts),
+		Severity:  logtypepb.LogSeverity_INFO,
+		InsertId:  fmt.Sprintf("%s-%d", service, i),
+		Payload:   &logpb.LogEntry_TextPayload{TextPayload: fmt.Sprintf("line %d", i)},
+	}
+}
+
+func TestFetchLogsPaginatesAndFilters(t *testing.T) {
+	f, config := newFakeLogging(t)
+
+	const want = 2500
+	for i := range want {
+		f.Add(entry("api", windowStart.Add(time.Duration(i)*time.Second), i))
+	}
+	// Entries of another service, outside the window, of another resource
+	// type or from another project must not be returned.
+	f.Add(entry("worker", windowStart.Add(time.Minute), 0))
+	f.Add(entry("api", windowStart.Add(-time.Minute), -1))
+	other := entry("api", windowStart.Add(time.Minute), -2)
+	other.Resource.Type = "gce_instance"
+	f.Add(other)
+	foreign := entry("api", windowStart.Add(time.Minute), -3)
+	foreign.LogName = "projects/other/logs/stdout"
+	f.Add(foreign)
+
+	start := windowStart.Format(time.RFC3339)
+	end := windowStart.Add(24 * time.Hour).Format(time.RFC3339)
+	entries, err := FetchLogs(context.Background(), config, []string{"api"}, start, end)
+	if err != nil {
+		t.Fatalf("FetchLogs: %v", err)
+	}
+	if len(entries) != want {
+		t.Fatalf("FetchLogs returned %d entries, want %d", len(entries), want)
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].GetTimestamp().AsTime().After(entries[i-1].GetTimestamp().AsTime()) {
+			t.Fatalf("entries not in descending timestamp order at %d", i)
+		}
+	}
+	if got := len(f.Requests()); got != 3 {
+		t.Errorf("FetchLogs made %d requests, want 3 pages of %d", got, pageSize)
+	}
+}
+
+func TestFetchLogsRetriesTransientErrors(t *testing.T) {
+	f, config := newFakeLogging(t)
+	f.Add(entry("api", windowStart, 0))
+	f.FailNext(status.Error(codes.Unavailable, "try again"))
+
+	start := windowStart.Format(time.RFC3339)
+	end := windowStart.Add(time.Hour).Format(time.RFC3339)
+	entries, err := FetchLogs(context.Background(), config, []string{"api"}, start, end)
+	if err != nil {
+		t.Fatalf("FetchLogs: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("FetchLogs returned %d entries, want 1", len(entries))
+	}
+	if got := len(f.Requests()); got != 2 {
+		t.Errorf("FetchLogs made %d requests, want 2", got)
+	}
+}
+
+func TestFetchLogsFailsOnPermanentErrors(t *testing.T) {
+	f, config := newFakeLogging(t)
+	f.FailNext(status.Error(codes.PermissionDenied, "no access"))
+
+	start := windowStart.Format(time.RFC3339)
+	end := windowStart.Add(time.Hour).Format(time.RFC3339)
+	if _, err := FetchLogs(context.Background(), config, []string{"api"}, start, end); err == nil {
+		t.Fatal("FetchLogs succeeded, want PermissionDenied error")
+	}
+	if got := len(f.Requests()); got != 1 {
+		t.Errorf("FetchLogs made %d requests, want 1", got)
+	}
+}
+
+func TestSamplePage(t *testing.T) {
+	f, config := newFakeLogging(t)
+	for i := range 10 {
+		f.Add(entry("api", windowStart.Add(time.Duration(i)*time.Second), i))
+	}
+
+	start := windowStart.Format(time.RFC3339)
+	end := windowStart.Add(time.Hour).Format(time.RFC3339)
+	entries, more, err := SamplePage(context.Background(), config, "api", start, end, 4)
+	if err != nil {
+		t.Fatalf("SamplePage: %v", err)
+	}
+	if len(entries) != 4 || !more {
+		t.Errorf("SamplePage returned %d entries, more=%v; want 4, true", len(entries), more)
+	}
+	if got := entries[0].GetInsertId(); got != "api-9" {
+		t.Errorf("first sampled entry is %s, want the newest (api-9)", got)
+	}
+}
+
+func TestFetchLogsFromInput(t *testing.T) {
+	config := &configs.Config{}
+	config.Resource.Type = []string{"cloud_run_revision"}
+	config.Source.Filter = `severity>=WARNING`
+
+	var lines []string
+	for i := range 6 {
+		e := entry("api", windowStart.Add(time.Duration(i)*time.Minute), i)
+		if i%2 == 1 {
+			e.Severity = logtypepb.LogSeverity_ERROR
+		}
+		data, err := protojson.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(data))
+	}
+	data, _ := protojson.Marshal(entry("worker", windowStart, 0))
+	lines = append(lines, string(data), "")
+	config.Source.Input = filepath.Join(t.TempDir(), "entries.ndjson")
+	if err := os.WriteFile(config.Source.Input, []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	start := windowStart.Format(time.RFC3339)
+	end := windowStart.Add(time.Hour).Format(time.RFC3339)
+	entries, err := FetchLogs(context.Background(), config, []string{"api"}, start, end)
+	if err != nil {
+		t.Fatalf("FetchLogs: %v", err)
+	}
+	var ids []string
+	for _, e := range entries {
+		ids = append(ids, e.GetInsertId())
+	}
+	if got, want := strings.Join(ids, ","), "api-5,api-3,api-1"; got != want {
+		t.Errorf("FetchLogs returned %s, want %s", got, want)
+	}
+}
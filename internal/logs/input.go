@@ -0,0 +1,75 @@
+package logs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/filter"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	// Registers the audit log payload type so protoPayload fields decode.
+	_ "google.golang.org/genproto/googleapis/cloud/audit"
+)
+
+// readInput reads LogEntry objects in their JSON form from path, either one
+// per line (NDJSON, as written by gcloud logging read --format=json | jq -c)
+// or as a single JSON array.
+func readInput(path string) ([]*logpb.LogEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %v", err)
+	}
+
+	var raw []json.RawMessage
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse input %s: %v", path, err)
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(nil, 16<<20)
+		for scanner.Scan() {
+			if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+				raw = append(raw, append(json.RawMessage(nil), line...))
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read input %s: %v", path, err)
+		}
+	}
+
+	entries := make([]*logpb.LogEntry, len(raw))
+	opts := protojson.UnmarshalOptions{DiscardUnknown: true}
+	for i, r := range raw {
+		entries[i] = &logpb.LogEntry{}
+		if err := opts.Unmarshal(r, entries[i]); err != nil {
+			return nil, fmt.Errorf("failed to parse entry %d of %s: %v", i+1, path, err)
+		}
+	}
+	return entries, nil
+}
+
+// matchInput returns the entries matching the filter FetchLogs would send to
+// the Logging API for service, newest first like the API's results.
+func matchInput(entries []*logpb.LogEntry, config *configs.Config, service, startDate, endDate string) ([]*logpb.LogEntry, error) {
+	expr, err := filter.Parse(BuildFilter(config, service, startDate, endDate))
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %v", err)
+	}
+	var matched []*logpb.LogEntry
+	for _, e := range entries {
+		if filter.Match(expr, e) {
+			matched = append(matched, e)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].GetTimestamp().AsTime().After(matched[j].GetTimestamp().AsTime())
+	})
+	return matched, nil
+}
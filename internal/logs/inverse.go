@@ -0,0 +1,110 @@
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/phaserunner03/logging/internal/bigquery"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+	logtypepb "google.golang.org/genproto/googleapis/logging/type"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ConvertFromBQRow reconstructs the LogEntry row was converted from, so that
+// ConvertToBQRow(ConvertFromBQRow(row)) reproduces row. Fields ConvertToBQRow
+// does not keep (operation, split, receiveTimestamp) are not restored, nor
+// are the columns set after conversion such as service_name, error_group_id
+// and span_name.
+func ConvertFromBQRow(row bigquery.BQLogRow) (*logpb.LogEntry, error) {
+	entry := &logpb.LogEntry{
+		LogName:      row.LogName,
+		Timestamp:    timestamppb.New(row.Timestamp),
+		InsertId:     row.InsertID,
+		Trace:        row.Trace,
+		SpanId:       row.SpanID,
+		TraceSampled: row.TraceSampled,
+	}
+
+	severity, ok := logtypepb.LogSeverity_value[row.Severity]
+	if !ok && row.Severity != "" {
+		n, err := strconv.ParseInt(row.Severity, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("unknown severity %q", row.Severity)
+		}
+		severity = int32(n)
+	}
+	entry.Severity = logtypepb.LogSeverity(severity)
+
+	if row.TextPayload != "" {
+		entry.Payload = &logpb.LogEntry_TextPayload{TextPayload: row.TextPayload}
+	}
+	if isJSONValue(row.JsonPayload) {
+		payload := &structpb.Struct{}
+		if err := protojson.Unmarshal([]byte(row.JsonPayload), payload); err != nil {
+			return nil, fmt.Errorf("invalid json_payload: %v", err)
+		}
+		entry.Payload = &logpb.LogEntry_JsonPayload{JsonPayload: payload}
+	}
+	if isJSONValue(row.ProtoPayload) {
+		payload := &anypb.Any{}
+		if err := protojson.Unmarshal([]byte(row.ProtoPayload), payload); err != nil {
+			return nil, fmt.Errorf("invalid proto_payload: %v", err)
+		}
+		entry.Payload = &logpb.LogEntry_ProtoPayload{ProtoPayload: payload}
+	}
+
+	resourceLabels, err := stringMap("resource_labels", row.ResourceLabels)
+	if err != nil {
+		return nil, err
+	}
+	if row.ResourceType != "" || resourceLabels != nil {
+		entry.Resource = &mrpb.MonitoredResource{Type: row.ResourceType, Labels: resourceLabels}
+	}
+	if entry.Labels, err = stringMap("labels", row.Labels); err != nil {
+		return nil, err
+	}
+
+	if isJSONValue(row.HTTPRequest) {
+		entry.HttpRequest = &logtypepb.HttpRequest{}
+		if err := unmarshalColumn("http_request", row.HTTPRequest, entry.HttpRequest); err != nil {
+			return nil, err
+		}
+	}
+	if isJSONValue(row.SourceLocation) {
+		entry.SourceLocation = &logpb.LogEntrySourceLocation{}
+		if err := unmarshalColumn("source_location", row.SourceLocation, entry.SourceLocation); err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+// isJSONValue reports whether a JSON column holds something other than null.
+func isJSONValue(s string) bool {
+	return s != "" && s != "null"
+}
+
+// stringMap decodes a labels column; null yields a nil map, {} an empty one.
+func stringMap(column, s string) (map[string]string, error) {
+	if !isJSONValue(s) {
+		return nil, nil
+	}
+	m := map[string]string{}
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", column, err)
+	}
+	return m, nil
+}
+
+func unmarshalColumn(column, s string, m proto.Message) error {
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal([]byte(s), m); err != nil {
+		return fmt.Errorf("invalid %s: %v", column, err)
+	}
+	return nil
+}
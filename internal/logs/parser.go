@@ -3,12 +3,20 @@ package logs
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
 
+	bq "cloud.google.com/go/bigquery"
 	"github.com/phaserunner03/logging/internal/bigquery"
-	logpb "google.golang.org/genproto/googleapis/logging/v2"
+	"github.com/phaserunner03/logging/internal/logger"
+	"github.com/phaserunner03/logging/internal/telemetry"
+	_ "google.golang.org/genproto/googleapis/cloud/audit" // resolves AuditLog protoPayloads
 	logtypepb "google.golang.org/genproto/googleapis/logging/type"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/structpb"
 )
 
@@ -54,6 +62,14 @@ func marshalToJSONString(v interface{}) string {
 			return "{}"
 		}
 
+	case *anypb.Any:
+		if val == nil {
+			return "null"
+		}
+		// JSON field names, so columns read like protoPayload in Cloud
+		// Logging; the payload type must be linked in to be resolved.
+		data, err = protojson.Marshal(val)
+
 	case map[string]string:
 		if val == nil {
 			return "null"
@@ -68,7 +84,11 @@ func marshalToJSONString(v interface{}) string {
 	}
 
 	if err != nil {
-		log.Printf("Error marshalling to JSON: %v. Value: %+v. Returning 'null'.", err, v)
+		slog.Warn("Failed to marshal value to JSON, storing null",
+			logger.KeyStage, telemetry.StageConvert,
+			"error", err,
+			"value", fmt.Sprintf("%+v", v),
+		)
 		return "null"
 	}
 	if len(data) == 0 {
@@ -82,12 +102,13 @@ func ConvertToBQRow(entry *logpb.LogEntry) (bigquery.BQLogRow, error) {
 		return bigquery.BQLogRow{}, fmt.Errorf("nil log entry")
 	}
 
-	return bigquery.BQLogRow{
+	row := bigquery.BQLogRow{
 		Timestamp:      entry.GetTimestamp().AsTime(),
 		Severity:       entry.GetSeverity().String(),
 		LogName:        entry.GetLogName(),
 		TextPayload:    entry.GetTextPayload(),
 		JsonPayload:    marshalToJSONString(entry.GetJsonPayload()),
+		ProtoPayload:   marshalToJSONString(entry.GetProtoPayload()),
 		InsertID:       entry.GetInsertId(),
 		ResourceType:   entry.GetResource().GetType(),
 		ResourceLabels: marshalToJSONString(entry.GetResource().GetLabels()),
@@ -96,5 +117,78 @@ func ConvertToBQRow(entry *logpb.LogEntry) (bigquery.BQLogRow, error) {
 		SpanID:         entry.GetSpanId(),
 		SourceLocation: marshalToJSONString(entry.GetSourceLocation()),
 		Labels:         marshalToJSONString(entry.GetLabels()),
-	}, nil
+		SourceProject:  sourceProject(entry),
+		TraceID:        TraceID(entry.GetTrace()),
+		TraceSampled:   entry.GetTraceSampled(),
+	}
+	flattenHTTPRequest(&row, entry.GetHttpRequest())
+	return row, nil
+}
+
+// TraceID returns the bare trace ID of a trace reference such as
+// "projects/my-project/traces/06796866738c859f2f19b7cfb3214824".
+func TraceID(trace string) string {
+	if i := strings.LastIndex(trace, "/traces/"); i >= 0 {
+		return trace[i+len("/traces/"):]
+	}
+	return trace
+}
+
+// TraceProject returns the project of a trace reference, or "" if it has none.
+func TraceProject(trace string) string {
+	project, ok := strings.CutPrefix(trace, "projects/")
+	if !ok {
+		return ""
+	}
+	project, _, ok = strings.Cut(project, "/traces/")
+	if !ok {
+		return ""
+	}
+	return project
+}
+
+// flattenHTTPRequest copies the fields of req into the request columns of row.
+func flattenHTTPRequest(row *bigquery.BQLogRow, req *logtypepb.HttpRequest) {
+	if req == nil {
+		return
+	}
+
+	row.RequestMethod = req.GetRequestMethod()
+	row.RequestURL = req.GetRequestUrl()
+	if u, err := url.Parse(req.GetRequestUrl()); err == nil {
+		row.RequestPath = u.Path
+		row.RequestQuery = u.RawQuery
+	}
+	if req.GetStatus() != 0 {
+		row.Status = bq.NullInt64{Int64: int64(req.GetStatus()), Valid: true}
+	}
+	if req.GetLatency() != nil {
+		ms := float64(req.GetLatency().AsDuration()) / float64(time.Millisecond)
+		row.LatencyMS = bq.NullFloat64{Float64: ms, Valid: true}
+	}
+	if req.GetResponseSize() != 0 {
+		row.ResponseSize = bq.NullInt64{Int64: req.GetResponseSize(), Valid: true}
+	}
+	row.UserAgent = req.GetUserAgent()
+	row.RemoteIP = req.GetRemoteIp()
+	// Without a cache lookup there is no hit or miss to record.
+	if req.GetCacheLookup() {
+		row.CacheHit = bq.NullBool{Bool: req.GetCacheHit(), Valid: true}
+	}
+	row.Protocol = req.GetProtocol()
+}
+
+// sourceProject returns the project an entry belongs to: the monitored
+// resource's project_id label when present, otherwise the project in log_name
+// ("projects/P/logs/..."). Entries read from folders, organizations or billing
+// accounts without a project have no source project.
+func sourceProject(entry *logpb.LogEntry) string {
+	if project := entry.GetResource().GetLabels()["project_id"]; project != "" {
+		return project
+	}
+	if rest, ok := strings.CutPrefix(entry.GetLogName(), "projects/"); ok {
+		project, _, _ := strings.Cut(rest, "/")
+		return project
+	}
+	return ""
 }
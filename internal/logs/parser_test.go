@@ -0,0 +1,193 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/internal/bigquery"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+	logtypepb "google.golang.org/genproto/googleapis/logging/type"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// loadEntries reads the LogEntry fixtures in testdata/entries, keyed by file name.
+func loadEntries(t testing.TB) map[string]*logpb.LogEntry {
+	t.Helper()
+	paths, err := filepath.Glob("testdata/entries/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]*logpb.LogEntry, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entry logpb.LogEntry
+		if err := protojson.Unmarshal(data, &entry); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		entries[strings.TrimSuffix(filepath.Base(path), ".json")] = &entry
+	}
+	return entries
+}
+
+// TestConvertToBQRowGolden compares the conversion of every fixture with its
+// golden row. Run with -update to accept changes.
+func TestConvertToBQRowGolden(t *testing.T) {
+	schema, err := bigquery.LoadSchema("../../schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, entry := range loadEntries(t) {
+		t.Run(name, func(t *testing.T) {
+			row, err := ConvertToBQRow(entry)
+			if err != nil {
+				t.Fatalf("ConvertToBQRow: %v", err)
+			}
+			for _, err := range bigquery.ValidateRow(schema, row) {
+				t.Errorf("row does not match schema.json: %v", err)
+			}
+
+			compactJSONColumns(&row)
+			got, err := json.MarshalIndent(row, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			golden := filepath.Join("testdata", "golden", name+".golden.json")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("conversion differs from %s (run go test -update to accept):\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+// compactJSONColumns strips the whitespace protojson randomizes between
+// builds from the JSON columns of row.
+func compactJSONColumns(row *bigquery.BQLogRow) {
+	for _, col := range []*string{&row.JsonPayload, &row.ProtoPayload, &row.ResourceLabels, &row.HTTPRequest, &row.SourceLocation, &row.Labels} {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, []byte(*col)); err == nil {
+			*col = buf.String()
+		}
+	}
+}
+
+// TestConvertFromBQRowRoundTrip checks that converting a row back to an entry
+// and again to a row reproduces it, for every fixture and a few edge cases.
+func TestConvertFromBQRowRoundTrip(t *testing.T) {
+	entries := loadEntries(t)
+	entries["empty_labels"] = &logpb.LogEntry{
+		LogName:  "projects/p/logs/app",
+		Severity: logtypepb.LogSeverity(150),
+		Labels:   map[string]string{},
+		Resource: &mrpb.MonitoredResource{Labels: map[string]string{}},
+		Payload:  &logpb.LogEntry_JsonPayload{JsonPayload: &structpb.Struct{}},
+	}
+	entries["no_resource"] = &logpb.LogEntry{InsertId: "x", TraceSampled: true}
+
+	for name, entry := range entries {
+		t.Run(name, func(t *testing.T) {
+			want, err := ConvertToBQRow(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			back, err := ConvertFromBQRow(want)
+			if err != nil {
+				t.Fatalf("ConvertFromBQRow: %v", err)
+			}
+			got, err := ConvertToBQRow(back)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compactJSONColumns(&want)
+			compactJSONColumns(&got)
+			if !reflect.DeepEqual(got, want) {
+				gotJSON, _ := json.MarshalIndent(got, "", "  ")
+				wantJSON, _ := json.MarshalIndent(want, "", "  ")
+				t.Errorf("round trip changed the row:\ngot:\n%s\nwant:\n%s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+// FuzzConvertToBQRow checks that conversion of arbitrary LogEntry protos
+// never panics and that every JSON-typed column holds valid JSON.
+func FuzzConvertToBQRow(f *testing.F) {
+	for _, entry := range loadEntries(f) {
+		data, err := proto.Marshal(entry)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var entry logpb.LogEntry
+		if err := proto.Unmarshal(data, &entry); err != nil {
+			return
+		}
+		row, err := ConvertToBQRow(&entry)
+		if err != nil {
+			t.Fatalf("ConvertToBQRow: %v", err)
+		}
+		for col, value := range map[string]string{
+			"json_payload":    row.JsonPayload,
+			"proto_payload":   row.ProtoPayload,
+			"resource_labels": row.ResourceLabels,
+			"http_request":    row.HTTPRequest,
+			"source_location": row.SourceLocation,
+			"labels":          row.Labels,
+		} {
+			if !json.Valid([]byte(value)) {
+				t.Errorf("%s is not valid JSON: %q", col, value)
+			}
+		}
+	})
+}
+
+func TestConvertCacheHit(t *testing.T) {
+	tests := []struct {
+		name string
+		req  *logtypepb.HttpRequest
+		want bq.NullBool
+	}{
+		{"no cache fields", &logtypepb.HttpRequest{Status: 200}, bq.NullBool{}},
+		{"miss", &logtypepb.HttpRequest{Status: 200, CacheLookup: true}, bq.NullBool{Bool: false, Valid: true}},
+		{"hit", &logtypepb.HttpRequest{Status: 200, CacheLookup: true, CacheHit: true}, bq.NullBool{Bool: true, Valid: true}},
+	}
+	for _, tt := range tests {
+		row, err := ConvertToBQRow(&logpb.LogEntry{LogName: "projects/p/logs/requests", HttpRequest: tt.req})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if row.CacheHit != tt.want {
+			t.Errorf("%s: cache_hit = %+v, want %+v", tt.name, row.CacheHit, tt.want)
+		}
+	}
+}
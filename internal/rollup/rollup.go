@@ -0,0 +1,109 @@
+// Package rollup aggregates converted log rows into per-service time buckets.
+package rollup
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/internal/bigquery"
+)
+
+// Aggregate groups rows by service and bucket of length interval and returns
+// one rollup row per group, ordered by service and bucket start.
+func Aggregate(rows []bigquery.BQLogRow, interval time.Duration) []bigquery.RollupRow {
+	type key struct {
+		service string
+		bucket  time.Time
+	}
+	groups := make(map[key]*bigquery.RollupRow)
+	latencies := make(map[key][]float64)
+
+	for _, row := range rows {
+		k := key{service: row.ServiceName, bucket: row.Timestamp.UTC().Truncate(interval)}
+		agg, ok := groups[k]
+		if !ok {
+			agg = &bigquery.RollupRow{
+				BucketStart:   k.bucket,
+				BucketSeconds: int64(interval / time.Second),
+				ServiceName:   k.service,
+			}
+			groups[k] = agg
+		}
+
+		agg.EntryCount++
+		countSeverity(agg, row.Severity)
+
+		if row.Status.Valid {
+			agg.RequestCount++
+			countStatus(agg, int(row.Status.Int64))
+		}
+		if row.LatencyMS.Valid {
+			latencies[k] = append(latencies[k], row.LatencyMS.Float64)
+		}
+	}
+
+	out := make([]bigquery.RollupRow, 0, len(groups))
+	for k, agg := range groups {
+		if l := latencies[k]; len(l) > 0 {
+			sort.Float64s(l)
+			agg.LatencyP50Ms = bq.NullFloat64{Float64: percentile(l, 50), Valid: true}
+			agg.LatencyP90Ms = bq.NullFloat64{Float64: percentile(l, 90), Valid: true}
+			agg.LatencyP99Ms = bq.NullFloat64{Float64: percentile(l, 99), Valid: true}
+			agg.LatencyMaxMs = bq.NullFloat64{Float64: l[len(l)-1], Valid: true}
+		}
+		out = append(out, *agg)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ServiceName != out[j].ServiceName {
+			return out[i].ServiceName < out[j].ServiceName
+		}
+		return out[i].BucketStart.Before(out[j].BucketStart)
+	})
+	return out
+}
+
+func countSeverity(agg *bigquery.RollupRow, severity string) {
+	switch strings.ToUpper(severity) {
+	case "DEBUG":
+		agg.DebugCount++
+	case "INFO":
+		agg.InfoCount++
+	case "NOTICE":
+		agg.NoticeCount++
+	case "WARNING":
+		agg.WarningCount++
+	case "ERROR":
+		agg.ErrorCount++
+	case "CRITICAL", "ALERT", "EMERGENCY":
+		agg.CriticalCount++
+	default:
+		agg.DefaultCount++
+	}
+}
+
+func countStatus(agg *bigquery.RollupRow, status int) {
+	switch status / 100 {
+	case 1:
+		agg.Status1xx++
+	case 2:
+		agg.Status2xx++
+	case 3:
+		agg.Status3xx++
+	case 4:
+		agg.Status4xx++
+	case 5:
+		agg.Status5xx++
+	}
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
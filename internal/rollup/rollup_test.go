@@ -0,0 +1,97 @@
+package rollup
+
+import (
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/internal/bigquery"
+)
+
+var t0 = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func request(service string, at time.Time, severity string, status int64, latency float64) bigquery.BQLogRow {
+	return bigquery.BQLogRow{
+		ServiceName: service,
+		Timestamp:   at,
+		Severity:    severity,
+		Status:      bq.NullInt64{Int64: status, Valid: true},
+		LatencyMS:   bq.NullFloat64{Float64: latency, Valid: true},
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	rows := []bigquery.BQLogRow{
+		request("web", t0.Add(10*time.Second), "INFO", 200, 5),
+		request("api", t0.Add(59*time.Second), "ERROR", 503, 40),
+		request("api", t0, "info", 200, 10),
+		request("api", t0.Add(30*time.Second), "WARNING", 404, 20),
+		{ServiceName: "api", Timestamp: t0.Add(45 * time.Second), Severity: "ALERT"},
+		{ServiceName: "api", Timestamp: t0.Add(time.Minute), TextPayload: "next bucket"},
+	}
+
+	got := Aggregate(rows, time.Minute)
+	if len(got) != 3 {
+		t.Fatalf("got %d rollups, want 3: %+v", len(got), got)
+	}
+	api, next, web := got[0], got[1], got[2]
+	if api.ServiceName != "api" || !api.BucketStart.Equal(t0) || !next.BucketStart.Equal(t0.Add(time.Minute)) || web.ServiceName != "web" {
+		t.Fatalf("rollups are not ordered by service and bucket: %+v", got)
+	}
+
+	want := bigquery.RollupRow{
+		BucketStart:   t0,
+		BucketSeconds: 60,
+		ServiceName:   "api",
+		EntryCount:    4,
+		InfoCount:     1,
+		WarningCount:  1,
+		ErrorCount:    1,
+		CriticalCount: 1,
+		RequestCount:  3,
+		Status2xx:     1,
+		Status4xx:     1,
+		Status5xx:     1,
+		LatencyP50Ms:  bq.NullFloat64{Float64: 20, Valid: true},
+		LatencyP90Ms:  bq.NullFloat64{Float64: 40, Valid: true},
+		LatencyP99Ms:  bq.NullFloat64{Float64: 40, Valid: true},
+		LatencyMaxMs:  bq.NullFloat64{Float64: 40, Valid: true},
+	}
+	if api != want {
+		t.Errorf("api rollup = %+v, want %+v", api, want)
+	}
+	if next.EntryCount != 1 || next.DefaultCount != 1 || next.RequestCount != 0 || next.LatencyP50Ms.Valid {
+		t.Errorf("rollup without requests = %+v", next)
+	}
+}
+
+func TestAggregateEmpty(t *testing.T) {
+	if got := Aggregate(nil, time.Minute); len(got) != 0 {
+		t.Errorf("Aggregate(nil) = %+v, want no rollups", got)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := make([]float64, 100)
+	for i := range sorted {
+		sorted[i] = float64(i + 1)
+	}
+	tests := []struct {
+		values []float64
+		p      float64
+		want   float64
+	}{
+		{sorted, 50, 50},
+		{sorted, 90, 90},
+		{sorted, 99, 99},
+		{sorted, 100, 100},
+		{[]float64{7}, 50, 7},
+		{[]float64{1, 2}, 0, 1},
+		{[]float64{1, 2, 3}, 50, 2},
+	}
+	for _, tt := range tests {
+		if got := percentile(tt.values, tt.p); got != tt.want {
+			t.Errorf("percentile(%v, %v) = %v, want %v", tt.values, tt.p, got, tt.want)
+		}
+	}
+}
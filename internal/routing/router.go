@@ -0,0 +1,133 @@
+package routing
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/bigquery"
+)
+
+// Router picks the destination table of each row from the routing rules in the config.
+type Router struct {
+	rules    []rule
+	fallback bigquery.Destination
+}
+
+type rule struct {
+	service  matcher
+	severity matcher
+	logName  matcher
+	dest     bigquery.Destination
+}
+
+// matcher reports whether a value matches a pattern; nil matches everything.
+type matcher func(string) bool
+
+func New(config *configs.Config) (*Router, error) {
+	r := &Router{fallback: resolve(config.Routing.Default, bigquery.DefaultDestination(config))}
+
+	for i, cfg := range config.Routing.Rules {
+		var rl rule
+		var err error
+		if rl.service, err = compile(cfg.Match.Service); err != nil {
+			return nil, fmt.Errorf("routing rule %d: %v", i+1, err)
+		}
+		if rl.severity, err = compile(cfg.Match.Severity); err != nil {
+			return nil, fmt.Errorf("routing rule %d: %v", i+1, err)
+		}
+		if rl.logName, err = compile(cfg.Match.LogName); err != nil {
+			return nil, fmt.Errorf("routing rule %d: %v", i+1, err)
+		}
+		rl.dest = resolve(cfg.Destination, r.fallback)
+		r.rules = append(r.rules, rl)
+	}
+	return r, nil
+}
+
+// Route returns the destination of row: the first matching rule's, or the
+// default. Log name patterns are matched against the decoded log ID, such as
+// "run.googleapis.com/requests", rather than the full log name.
+func (r *Router) Route(row bigquery.BQLogRow) bigquery.Destination {
+	logID := bigquery.LogID(row.LogName)
+	for _, rl := range r.rules {
+		if rl.service.match(row.ServiceName) && rl.severity.match(row.Severity) && rl.logName.match(logID) {
+			return rl.dest
+		}
+	}
+	return r.fallback
+}
+
+// Split groups rows by destination, preserving their order within each group.
+func (r *Router) Split(rows []bigquery.BQLogRow) map[bigquery.Destination][]bigquery.BQLogRow {
+	batches := make(map[bigquery.Destination][]bigquery.BQLogRow)
+	for _, row := range rows {
+		dest := r.Route(row)
+		batches[dest] = append(batches[dest], row)
+	}
+	return batches
+}
+
+// Destinations returns every table rows can be routed to, the default first.
+func (r *Router) Destinations() []bigquery.Destination {
+	dests := []bigquery.Destination{r.fallback}
+	seen := map[bigquery.Destination]bool{r.fallback: true}
+	for _, rl := range r.rules {
+		if !seen[rl.dest] {
+			seen[rl.dest] = true
+			dests = append(dests, rl.dest)
+		}
+	}
+	return dests
+}
+
+// Matcher compiles a routing pattern for use outside the router, e.g. by
+// alert rules. The empty pattern matches everything.
+func Matcher(pattern string) (func(string) bool, error) {
+	m, err := compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return m.match, nil
+}
+
+func (m matcher) match(value string) bool {
+	return m == nil || m(value)
+}
+
+func compile(pattern string) (matcher, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %v", expr, err)
+		}
+		return re.MatchString, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid glob %q: %v", pattern, err)
+	}
+	return func(value string) bool {
+		ok, _ := path.Match(pattern, value)
+		return ok
+	}, nil
+}
+
+// resolve fills the unset fields of cfg from fallback.
+func resolve(cfg configs.DestinationConfig, fallback bigquery.Destination) bigquery.Destination {
+	dest := bigquery.Destination{ProjectID: cfg.ProjectID, DatasetID: cfg.DatasetID, TableID: cfg.TableID}
+	if dest.ProjectID == "" {
+		dest.ProjectID = fallback.ProjectID
+	}
+	if dest.DatasetID == "" {
+		dest.DatasetID = fallback.DatasetID
+	}
+	if dest.TableID == "" {
+		dest.TableID = fallback.TableID
+	}
+	return dest
+}
@@ -0,0 +1,46 @@
+package routing
+
+import (
+	"testing"
+
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/bigquery"
+)
+
+func TestRouteLogName(t *testing.T) {
+	config := &configs.Config{}
+	config.GCP.ProjectID = "p"
+	config.BigQuery.DatasetID = "logs"
+	config.BigQuery.TableID = "default"
+	for _, rule := range []struct{ logName, table string }{
+		{"cloudaudit.googleapis.com/*", "audit"},
+		{"re:run\\.googleapis\\.com/.*", "run"},
+		{"app", "app"},
+	} {
+		var r configs.RouteRule
+		r.Match.LogName = rule.logName
+		r.Destination.TableID = rule.table
+		config.Routing.Rules = append(config.Routing.Rules, r)
+	}
+	router, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		logName, table string
+	}{
+		{"projects/p/logs/cloudaudit.googleapis.com%2Factivity", "audit"},
+		{"organizations/1/logs/cloudaudit.googleapis.com%2Fdata_access", "audit"},
+		{"projects/p/logs/run.googleapis.com%2Fstderr", "run"},
+		{"projects/p/logs/app", "app"},
+		{"projects/p/logs/app%2Fworker", "default"},
+		{"", "default"},
+	}
+	for _, tt := range tests {
+		got := router.Route(bigquery.BQLogRow{LogName: tt.logName})
+		if got.TableID != tt.table {
+			t.Errorf("Route(%q) = %s, want table %s", tt.logName, got, tt.table)
+		}
+	}
+}
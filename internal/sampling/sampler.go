@@ -0,0 +1,205 @@
+package sampling
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/bigquery"
+)
+
+const dayLayout = "2006-01-02"
+
+// Dropped counts the rows removed by a Sampler.
+type Dropped struct {
+	Sampled int // removed by the severity sampling rate
+	Capped  int // removed by the daily cap
+}
+
+func (d Dropped) Total() int {
+	return d.Sampled + d.Capped
+}
+
+// Sampler applies the sampling policies of a config. Daily caps count the
+// rows recorded as inserted across every call on the same Sampler, starting
+// from the rows already exported when a CountFunc is set. It is safe for
+// concurrent use, but concurrent exports of the same service and day may
+// together exceed its cap by the rows they insert at once.
+type Sampler struct {
+	config *configs.Config
+	count  CountFunc
+
+	mu   sync.Mutex
+	kept map[dayKey]int
+}
+
+// CountFunc returns the number of rows of service already exported within
+// [start, end].
+type CountFunc func(ctx context.Context, service string, start, end time.Time) (int64, error)
+
+type dayKey struct {
+	service string
+	day     string
+}
+
+// New returns a Sampler for the policies of config. count, when set, is
+// called the first time a daily cap is applied to a service and day, so caps
+// hold across restarts and processes; without it caps count from zero.
+func New(config *configs.Config, count CountFunc) *Sampler {
+	return &Sampler{config: config, count: count, kept: make(map[dayKey]int)}
+}
+
+// Apply returns the rows of service that survive sampling and the daily cap,
+// in their original order. Kept rows only count towards the cap once passed
+// to Record.
+func (s *Sampler) Apply(ctx context.Context, service string, rows []bigquery.BQLogRow) ([]bigquery.BQLogRow, Dropped, error) {
+	policy := s.config.SamplingPolicy(service)
+	var dropped Dropped
+
+	kept := rows[:0:0]
+	for _, row := range rows {
+		if !Keep(s.key(row), rate(policy, row.Severity)) {
+			dropped.Sampled++
+			continue
+		}
+		kept = append(kept, row)
+	}
+	if policy.DailyCap == 0 {
+		return kept, dropped, nil
+	}
+
+	days := make(map[dayKey]bool)
+	for _, row := range kept {
+		days[dayKey{service: service, day: row.Timestamp.UTC().Format(dayLayout)}] = true
+	}
+	if err := s.countDays(ctx, days); err != nil {
+		return nil, dropped, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	capped := kept[:0]
+	pending := make(map[dayKey]int)
+	for _, row := range kept {
+		day := dayKey{service: service, day: row.Timestamp.UTC().Format(dayLayout)}
+		if s.kept[day]+pending[day] >= policy.DailyCap {
+			dropped.Capped++
+			continue
+		}
+		pending[day]++
+		capped = append(capped, row)
+	}
+	return capped, dropped, nil
+}
+
+// Record counts rows of service, as returned by Apply, towards the daily caps
+// once they are inserted. When the insert failed, or replaced earlier rows,
+// an unknown number of rows is in BigQuery: pass ok false and the days of
+// rows are counted again on the next Apply. Without a CountFunc they are then
+// left as they were.
+func (s *Sampler) Record(service string, rows []bigquery.BQLogRow, ok bool) {
+	if s.config.SamplingPolicy(service).DailyCap == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, row := range rows {
+		day := dayKey{service: service, day: row.Timestamp.UTC().Format(dayLayout)}
+		_, counted := s.kept[day]
+		switch {
+		case ok && (counted || s.count == nil):
+			s.kept[day]++
+		case !ok && s.count != nil:
+			delete(s.kept, day)
+		}
+	}
+}
+
+// countDays asks the CountFunc for the rows exported on the days not
+// counted yet. The queries run without s.mu held, so other services and
+// workers are not held up by them.
+func (s *Sampler) countDays(ctx context.Context, days map[dayKey]bool) error {
+	if s.count == nil {
+		return nil
+	}
+	s.mu.Lock()
+	for day := range days {
+		if _, ok := s.kept[day]; ok {
+			delete(days, day)
+		}
+	}
+	s.mu.Unlock()
+
+	counts := make(map[dayKey]int, len(days))
+	for day := range days {
+		start, _ := time.Parse(dayLayout, day.day)
+		n, err := s.count(ctx, day.service, start, start.Add(24*time.Hour-time.Nanosecond))
+		if err != nil {
+			return fmt.Errorf("failed to count exported rows of %s on %s: %v", day.service, day.day, err)
+		}
+		counts[day] = int(n)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for day, n := range counts {
+		// A concurrent Apply may have counted the day first.
+		if _, ok := s.kept[day]; !ok {
+			s.kept[day] = n
+		}
+	}
+	return nil
+}
+
+// key returns the value hashed to decide whether row is kept.
+func (s *Sampler) key(row bigquery.BQLogRow) string {
+	if s.config.Sampling.Key == "trace" && row.Trace != "" {
+		return row.Trace
+	}
+	return row.InsertID
+}
+
+func rate(policy configs.SamplingPolicy, severity string) float64 {
+	if severity == "" {
+		severity = "DEFAULT"
+	}
+	for s, r := range policy.Rates {
+		if strings.EqualFold(s, severity) {
+			return r
+		}
+	}
+	return 1
+}
+
+// Keep reports whether a row with the given key is kept at rate. The decision
+// only depends on key, and a key kept at some rate is kept at every higher rate.
+func Keep(key string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return float64(mix(h.Sum64())) < rate*math.MaxUint64
+}
+
+// mix spreads the bits of an FNV hash over the whole range with the
+// SplitMix64 finalizer; FNV alone leaves the high bits of similar keys such as
+// sequential insert IDs close together.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,224 @@
+package sampling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/bigquery"
+)
+
+var day1 = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+func testRows(n int, day time.Time, severity string) []bigquery.BQLogRow {
+	rows := make([]bigquery.BQLogRow, n)
+	for i := range rows {
+		rows[i] = bigquery.BQLogRow{
+			Timestamp: day.Add(time.Duration(i) * time.Minute),
+			Severity:  severity,
+			InsertID:  fmt.Sprintf("%s-%s-%d", day.Format("0102"), severity, i),
+		}
+	}
+	return rows
+}
+
+func TestKeep(t *testing.T) {
+	var kept30, kept60 int
+	for i := range 10000 {
+		key := fmt.Sprintf("id-%d", i)
+		k30, k60 := Keep(key, 0.3), Keep(key, 0.6)
+		if k30 && !k60 {
+			t.Fatalf("%s kept at rate 0.3 but not at 0.6", key)
+		}
+		if Keep(key, 0.3) != k30 {
+			t.Fatalf("%s: decision is not deterministic", key)
+		}
+		if k30 {
+			kept30++
+		}
+		if k60 {
+			kept60++
+		}
+	}
+	if kept30 < 2800 || kept30 > 3200 || kept60 < 5800 || kept60 > 6200 {
+		t.Errorf("kept %d at 0.3 and %d at 0.6 of 10000", kept30, kept60)
+	}
+	if !Keep("x", 1) || Keep("x", 0) {
+		t.Error("rates 1 and 0 must keep and drop everything")
+	}
+}
+
+func TestApplyRates(t *testing.T) {
+	config := &configs.Config{}
+	config.Sampling.Default.Rates = map[string]float64{"debug": 0, "INFO": 0.5}
+	config.Sampling.Services = map[string]configs.SamplingPolicy{"audit": {}}
+	s := New(config, nil)
+
+	rows := append(testRows(100, day1, "DEBUG"), testRows(1000, day1, "INFO")...)
+	rows = append(rows, testRows(10, day1, "ERROR")...)
+	kept, dropped, err := s.Apply(context.Background(), "api", rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, row := range kept {
+		counts[row.Severity]++
+	}
+	if counts["DEBUG"] != 0 || counts["ERROR"] != 10 || counts["INFO"] < 400 || counts["INFO"] > 600 {
+		t.Errorf("kept %v, want no DEBUG, all 10 ERROR and about 500 INFO", counts)
+	}
+	if dropped.Sampled != len(rows)-len(kept) || dropped.Capped != 0 {
+		t.Errorf("dropped = %+v, want %d sampled", dropped, len(rows)-len(kept))
+	}
+
+	if kept, _, _ := s.Apply(context.Background(), "audit", rows); len(kept) != len(rows) {
+		t.Errorf("audit kept %d of %d rows, want all under its own policy", len(kept), len(rows))
+	}
+}
+
+func TestApplyTraceKey(t *testing.T) {
+	config := &configs.Config{}
+	config.Sampling.Key = "trace"
+	config.Sampling.Default.Rates = map[string]float64{"INFO": 0.5}
+	s := New(config, nil)
+
+	var rows []bigquery.BQLogRow
+	for trace := range 50 {
+		for i := range 4 {
+			rows = append(rows, bigquery.BQLogRow{
+				Severity: "INFO",
+				Trace:    fmt.Sprintf("projects/p/traces/%d", trace),
+				InsertID: fmt.Sprintf("%d-%d", trace, i),
+			})
+		}
+	}
+	kept, _, err := s.Apply(context.Background(), "api", rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	perTrace := make(map[string]int)
+	for _, row := range kept {
+		perTrace[row.Trace]++
+	}
+	for trace, n := range perTrace {
+		if n != 4 {
+			t.Errorf("kept %d of 4 rows of %s", n, trace)
+		}
+	}
+}
+
+func TestDailyCap(t *testing.T) {
+	config := &configs.Config{}
+	config.Sampling.Default.DailyCap = 5
+	s := New(config, nil)
+	ctx := context.Background()
+
+	rows := append(testRows(8, day1, "INFO"), testRows(2, day1.AddDate(0, 0, 1), "INFO")...)
+	kept, dropped, err := s.Apply(ctx, "api", rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 7 || dropped.Capped != 3 {
+		t.Fatalf("kept %d, dropped %+v; want 7 kept and 3 capped", len(kept), dropped)
+	}
+
+	// Rows that were not inserted do not use up the cap.
+	s.Record("api", kept, false)
+	if again, _, _ := s.Apply(ctx, "api", rows); len(again) != 7 {
+		t.Errorf("after a failed insert kept %d rows, want 7", len(again))
+	}
+
+	s.Record("api", kept, true)
+	kept, dropped, _ = s.Apply(ctx, "api", testRows(4, day1.AddDate(0, 0, 1), "WARNING"))
+	if len(kept) != 3 || dropped.Capped != 1 {
+		t.Errorf("second day kept %d, dropped %+v; want 3 kept and 1 capped", len(kept), dropped)
+	}
+	if kept, _, _ := s.Apply(ctx, "worker", rows); len(kept) != 7 {
+		t.Errorf("worker kept %d rows, want its own cap", len(kept))
+	}
+}
+
+func TestDailyCapCountsExported(t *testing.T) {
+	config := &configs.Config{}
+	config.Sampling.Default.DailyCap = 5
+	var calls int
+	exported := int64(3)
+	s := New(config, func(_ context.Context, service string, start, end time.Time) (int64, error) {
+		calls++
+		if service != "api" || !start.Equal(day1) || !end.Equal(day1.Add(24*time.Hour-time.Nanosecond)) {
+			t.Errorf("count(%s, %s, %s), want api on %s", service, start, end, day1)
+		}
+		return exported, nil
+	})
+	ctx := context.Background()
+
+	kept, _, err := s.Apply(ctx, "api", testRows(4, day1, "INFO"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 2 {
+		t.Fatalf("kept %d rows with 3 of 5 exported, want 2", len(kept))
+	}
+	s.Record("api", kept, true)
+	if kept, _, _ := s.Apply(ctx, "api", testRows(1, day1, "INFO")); len(kept) != 0 || calls != 1 {
+		t.Errorf("kept %d rows after filling the cap with %d counts, want 0 rows and 1 count", len(kept), calls)
+	}
+
+	// After a failed or replacing insert the day is counted again.
+	s.Record("api", kept, false)
+	exported = 1
+	if kept, _, _ := s.Apply(ctx, "api", testRows(8, day1, "ERROR")); len(kept) != 4 || calls != 2 {
+		t.Errorf("kept %d rows with %d counts, want 4 rows and 2 counts", len(kept), calls)
+	}
+}
+
+func TestDailyCapCountError(t *testing.T) {
+	config := &configs.Config{}
+	config.Sampling.Default.DailyCap = 5
+	s := New(config, func(context.Context, string, time.Time, time.Time) (int64, error) {
+		return 0, errors.New("boom")
+	})
+	if _, _, err := s.Apply(context.Background(), "api", testRows(1, day1, "INFO")); err == nil {
+		t.Error("Apply succeeded although the exported rows could not be counted")
+	}
+}
+
+// TestDailyCapCountsConcurrently checks that a slow count of one service does
+// not hold up the others.
+func TestDailyCapCountsConcurrently(t *testing.T) {
+	config := &configs.Config{}
+	config.Sampling.Default.DailyCap = 5
+	release := make(chan struct{})
+	s := New(config, func(_ context.Context, service string, _, _ time.Time) (int64, error) {
+		if service == "slow" {
+			<-release
+		}
+		return 0, nil
+	})
+	ctx := context.Background()
+
+	done := make(chan error)
+	go func() {
+		_, _, err := s.Apply(ctx, "slow", testRows(1, day1, "INFO"))
+		done <- err
+	}()
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		if kept, _, err := s.Apply(ctx, "fast", testRows(1, day1, "INFO")); err != nil || len(kept) != 1 {
+			t.Errorf("fast Apply kept %d rows (err %v), want 1", len(kept), err)
+		}
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Apply waited for the count of another service")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
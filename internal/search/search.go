@@ -0,0 +1,420 @@
+// Package search translates search queries into parameterized GoogleSQL over
+// the exported log columns.
+//
+// Queries use the Logging query language as parsed by package filter, with
+// shortcuts for the exported columns:
+//
+//	service:api severity>=ERROR "connection reset"
+//	trace:06796866738c859f2f19b7cfb3214824
+//	jsonPayload.user.id=42 httpRequest.status>=500 since:2h
+//	after:2024-05-01 before:"2024-05-02T12:00:00Z" (error OR timeout)
+//
+// Bare terms match text_payload and json_payload case-insensitively.
+// since:, after: and before: (or timestamp comparisons) at the top level set
+// the time range every query is restricted to, so only the partitions in
+// range are scanned. Values containing colons, such as RFC 3339 timestamps,
+// must be quoted.
+package search
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/phaserunner03/logging/internal/filter"
+	"github.com/phaserunner03/logging/internal/logs"
+	logtypepb "google.golang.org/genproto/googleapis/logging/type"
+)
+
+// Query is a translated search.
+type Query struct {
+	// Where is a condition over the exported columns, not including the
+	// time range.
+	Where  string
+	Params map[string]any
+	Start  time.Time
+	End    time.Time
+}
+
+// Column kinds decide how values are parsed and compared.
+const (
+	kindString = iota
+	kindInt
+	kindFloat
+	kindBool
+	kindTime
+	kindSeverity
+	kindTrace
+)
+
+type column struct {
+	name string
+	kind int
+}
+
+// columns maps Logging field paths and shortcuts to exported columns.
+var columns = map[string]column{
+	"service":                   {"service_name", kindString},
+	"service_name":              {"service_name", kindString},
+	"severity":                  {"severity", kindSeverity},
+	"timestamp":                 {"timestamp", kindTime},
+	"logName":                   {"log_name", kindString},
+	"log_name":                  {"log_name", kindString},
+	"insertId":                  {"insert_id", kindString},
+	"insert_id":                 {"insert_id", kindString},
+	"text":                      {"text_payload", kindString},
+	"textPayload":               {"text_payload", kindString},
+	"text_payload":              {"text_payload", kindString},
+	"resource.type":             {"resource_type", kindString},
+	"resource_type":             {"resource_type", kindString},
+	"trace":                     {"trace_id", kindTrace},
+	"trace_id":                  {"trace_id", kindTrace},
+	"spanId":                    {"span_id", kindString},
+	"span_id":                   {"span_id", kindString},
+	"span_name":                 {"span_name", kindString},
+	"source_project":            {"source_project", kindString},
+	"error_group_id":            {"error_group_id", kindString},
+	"exception_type":            {"exception_type", kindString},
+	"httpRequest.requestMethod": {"request_method", kindString},
+	"method":                    {"request_method", kindString},
+	"request_method":            {"request_method", kindString},
+	"httpRequest.requestUrl":    {"request_url", kindString},
+	"request_url":               {"request_url", kindString},
+	"path":                      {"request_path", kindString},
+	"request_path":              {"request_path", kindString},
+	"request_query":             {"request_query", kindString},
+	"httpRequest.status":        {"status", kindInt},
+	"status":                    {"status", kindInt},
+	"latency_ms":                {"latency_ms", kindFloat},
+	"httpRequest.responseSize":  {"response_size", kindInt},
+	"response_size":             {"response_size", kindInt},
+	"httpRequest.userAgent":     {"user_agent", kindString},
+	"user_agent":                {"user_agent", kindString},
+	"httpRequest.remoteIp":      {"remote_ip", kindString},
+	"remote_ip":                 {"remote_ip", kindString},
+	"httpRequest.cacheHit":      {"cache_hit", kindBool},
+	"cache_hit":                 {"cache_hit", kindBool},
+	"httpRequest.protocol":      {"protocol", kindString},
+	"protocol":                  {"protocol", kindString},
+}
+
+// jsonColumns maps the prefixes of fields stored in JSON columns.
+var jsonColumns = []struct {
+	prefix []string
+	column string
+}{
+	{[]string{"jsonPayload"}, "json_payload"},
+	{[]string{"protoPayload"}, "proto_payload"},
+	{[]string{"resource", "labels"}, "resource_labels"},
+	{[]string{"labels"}, "labels"},
+	{[]string{"sourceLocation"}, "source_location"},
+	{[]string{"httpRequest"}, "http_request"},
+}
+
+// jsonKey matches keys that can be written into a JSONPath literal.
+var jsonKey = regexp.MustCompile(`^[A-Za-z0-9_\-/.:@ ]+$`)
+
+// Parse translates q. now anchors relative times; when q sets no start,
+// the range starts defaultSince before now.
+func Parse(q string, now time.Time, defaultSince time.Duration) (*Query, error) {
+	expr, err := filter.Parse(q)
+	if err != nil {
+		return nil, err
+	}
+	t := &translator{now: now, query: &Query{Params: make(map[string]any), End: now}}
+
+	// Time terms at the top level bound the scanned partitions.
+	var rest []filter.Expr
+	operands := []filter.Expr{expr}
+	if and, ok := expr.(filter.AndExpr); ok {
+		operands = and
+	}
+	for _, e := range operands {
+		c, ok := e.(filter.Comparison)
+		if !ok {
+			rest = append(rest, e)
+			continue
+		}
+		bound, err := t.timeBound(c)
+		if err != nil {
+			return nil, err
+		}
+		if !bound {
+			rest = append(rest, e)
+		}
+	}
+	if t.query.Start.IsZero() {
+		t.query.Start = now.Add(-defaultSince)
+	}
+	if t.query.End.Before(t.query.Start) {
+		return nil, fmt.Errorf("time range ends (%s) before it starts (%s)", t.query.End.Format(time.RFC3339), t.query.Start.Format(time.RFC3339))
+	}
+
+	where := "TRUE"
+	if len(rest) > 0 {
+		if where, err = t.expr(filter.And(rest...)); err != nil {
+			return nil, err
+		}
+	}
+	t.query.Where = where
+	return t.query, nil
+}
+
+type translator struct {
+	now   time.Time
+	query *Query
+}
+
+// param adds a query parameter holding v and returns its reference.
+func (t *translator) param(v any) string {
+	name := fmt.Sprintf("p%d", len(t.query.Params))
+	t.query.Params[name] = v
+	return "@" + name
+}
+
+// timeBound applies c to the time range if it is a time term, reporting
+// whether it was one.
+func (t *translator) timeBound(c filter.Comparison) (bool, error) {
+	var lower bool
+	switch {
+	case c.Field == "since" || c.Field == "after":
+		lower = true
+	case c.Field == "before" || c.Field == "until":
+	case c.Field == "timestamp" && (c.Op == filter.OpGe || c.Op == filter.OpGt):
+		lower = true
+	case c.Field == "timestamp" && (c.Op == filter.OpLe || c.Op == filter.OpLt):
+	default:
+		return false, nil
+	}
+	if c.Field != "timestamp" && c.Op != filter.OpHas && c.Op != filter.OpEq {
+		return false, fmt.Errorf("%s takes a value, as in %s:2h", c.Field, c.Field)
+	}
+	at, err := t.parseTime(c.Value)
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", c.Field, err)
+	}
+	// The range is inclusive, so strict bounds move by the microsecond
+	// precision of BigQuery timestamps.
+	switch c.Op {
+	case filter.OpGt:
+		at = at.Truncate(time.Microsecond).Add(time.Microsecond)
+	case filter.OpLt:
+		at = at.Add(-time.Nanosecond).Truncate(time.Microsecond)
+	}
+	if lower {
+		if at.After(t.query.Start) {
+			t.query.Start = at
+		}
+	} else if at.Before(t.query.End) {
+		t.query.End = at
+	}
+	return true, nil
+}
+
+// parseTime accepts RFC 3339 timestamps, dates and durations before now
+// such as 90m, 2h or 7d.
+func (t *translator) parseTime(s string) (time.Time, error) {
+	if at, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return at, nil
+	}
+	if at, err := time.Parse("2006-01-02", s); err == nil {
+		return at, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return t.now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return t.now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: use RFC 3339, YYYY-MM-DD or a duration like 2h or 7d", s)
+}
+
+func (t *translator) expr(e filter.Expr) (string, error) {
+	switch e := e.(type) {
+	case filter.AndExpr:
+		return t.join(e, " AND ")
+	case filter.OrExpr:
+		return t.join(e, " OR ")
+	case filter.NotExpr:
+		inner, err := t.expr(e.Expr)
+		if err != nil {
+			return "", err
+		}
+		return "NOT " + inner, nil
+	case filter.Term:
+		p := t.param(e.Value)
+		return fmt.Sprintf("(CONTAINS_SUBSTR(text_payload, %s) OR CONTAINS_SUBSTR(TO_JSON_STRING(json_payload), %s))", p, p), nil
+	case filter.Comparison:
+		return t.comparison(e)
+	}
+	return "", fmt.Errorf("unsupported expression %s", e)
+}
+
+func (t *translator) join(exprs []filter.Expr, sep string) (string, error) {
+	parts := make([]string, len(exprs))
+	for i, e := range exprs {
+		var err error
+		if parts[i], err = t.expr(e); err != nil {
+			return "", err
+		}
+	}
+	return "(" + strings.Join(parts, sep) + ")", nil
+}
+
+func (t *translator) comparison(c filter.Comparison) (string, error) {
+	switch c.Field {
+	case "since", "after", "before", "until":
+		return "", fmt.Errorf("%s: must be at the top level of the query", c.Field)
+	}
+
+	col, ok := columns[c.Field]
+	if !ok {
+		return t.jsonComparison(c)
+	}
+	if c.Op == filter.OpHas && c.Value == "*" {
+		if col.kind == kindString || col.kind == kindTrace {
+			// Rows exported before a column was nullable hold '' instead of NULL.
+			return fmt.Sprintf("NULLIF(%s, '') IS NOT NULL", col.name), nil
+		}
+		return fmt.Sprintf("%s IS NOT NULL", col.name), nil
+	}
+
+	if col.kind == kindSeverity {
+		return t.severity(c)
+	}
+	if col.kind != kindString && col.kind != kindTrace {
+		switch c.Op {
+		case filter.OpHas:
+			c.Op = filter.OpEq
+		case filter.OpRegex, filter.OpNotRegex:
+			return "", fmt.Errorf("%s: regular expressions only apply to text fields", c.Field)
+		}
+	}
+
+	var value any = c.Value
+	var err error
+	switch col.kind {
+	case kindTrace:
+		value = logs.TraceID(c.Value)
+	case kindInt:
+		value, err = strconv.ParseInt(c.Value, 10, 64)
+	case kindFloat:
+		value, err = strconv.ParseFloat(c.Value, 64)
+	case kindBool:
+		value, err = strconv.ParseBool(c.Value)
+	case kindTime:
+		value, err = t.parseTime(c.Value)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: invalid value %q", c.Field, c.Value)
+	}
+	return compare(col.name, c.Op, t.param(value)), nil
+}
+
+// jsonComparison compares a field stored in one of the JSON columns.
+func (t *translator) jsonComparison(c filter.Comparison) (string, error) {
+	path := c.Path()
+	for _, jc := range jsonColumns {
+		if len(path) <= len(jc.prefix) || !hasPrefix(path, jc.prefix) {
+			continue
+		}
+		keys := path[len(jc.prefix):]
+		jsonPath := "$"
+		for _, key := range keys {
+			if !jsonKey.MatchString(key) {
+				return "", fmt.Errorf("%s: unsupported key %q", c.Field, key)
+			}
+			jsonPath += `."` + key + `"`
+		}
+
+		if c.Op == filter.OpHas && c.Value == "*" {
+			return fmt.Sprintf("JSON_QUERY(%s, '%s') IS NOT NULL", jc.column, jsonPath), nil
+		}
+		value := fmt.Sprintf("JSON_VALUE(%s, '%s')", jc.column, jsonPath)
+		switch c.Op {
+		case filter.OpLt, filter.OpLe, filter.OpGt, filter.OpGe:
+			if n, err := strconv.ParseFloat(c.Value, 64); err == nil {
+				return compare(fmt.Sprintf("SAFE_CAST(%s AS FLOAT64)", value), c.Op, t.param(n)), nil
+			}
+		}
+		return compare(value, c.Op, t.param(c.Value)), nil
+	}
+	return "", fmt.Errorf("unknown field %q", c.Field)
+}
+
+// severity compares with severity names by rank, e.g. severity>=ERROR is
+// severity IN ('ERROR', 'CRITICAL', 'ALERT', 'EMERGENCY').
+func (t *translator) severity(c filter.Comparison) (string, error) {
+	want, ok := logtypepb.LogSeverity_value[strings.ToUpper(c.Value)]
+	if !ok {
+		return "", fmt.Errorf("severity: unknown level %q", c.Value)
+	}
+	if c.Op == filter.OpHas {
+		c.Op = filter.OpEq
+	}
+	var names []string
+	for _, level := range levels {
+		rank := int32(level)
+		var match bool
+		switch c.Op {
+		case filter.OpEq:
+			match = rank == want
+		case filter.OpNe:
+			match = rank != want
+		case filter.OpLt:
+			match = rank < want
+		case filter.OpLe:
+			match = rank <= want
+		case filter.OpGt:
+			match = rank > want
+		case filter.OpGe:
+			match = rank >= want
+		default:
+			return "", fmt.Errorf("severity: unsupported operator %s", c.Op)
+		}
+		if match {
+			names = append(names, level.String())
+		}
+	}
+	return fmt.Sprintf("severity IN UNNEST(%s)", t.param(names)), nil
+}
+
+// levels lists the severities in rank order.
+var levels = func() []logtypepb.LogSeverity {
+	var out []logtypepb.LogSeverity
+	for _, rank := range logtypepb.LogSeverity_value {
+		out = append(out, logtypepb.LogSeverity(rank))
+	}
+	slices.Sort(out)
+	return out
+}()
+
+// compare renders a comparison of expr with the parameter p. Like Cloud
+// Logging, != and !~ also match rows without the field.
+func compare(expr, op, p string) string {
+	switch op {
+	case filter.OpHas:
+		return fmt.Sprintf("CONTAINS_SUBSTR(%s, %s)", expr, p)
+	case filter.OpRegex:
+		return fmt.Sprintf("REGEXP_CONTAINS(%s, %s)", expr, p)
+	case filter.OpNotRegex:
+		return fmt.Sprintf("(%s IS NULL OR NOT REGEXP_CONTAINS(%s, %s))", expr, expr, p)
+	case filter.OpNe:
+		return fmt.Sprintf("(%s IS NULL OR %s != %s)", expr, expr, p)
+	}
+	return fmt.Sprintf("%s %s %s", expr, op, p)
+}
+
+func hasPrefix(path, prefix []string) bool {
+	for i, p := range prefix {
+		if path[i] != p {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package search
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query  string
+		where  string
+		params map[string]any
+	}{
+		{"", "TRUE", map[string]any{}},
+		{"service:api", "CONTAINS_SUBSTR(service_name, @p0)", map[string]any{"p0": "api"}},
+		{"service=api severity>=ERROR",
+			"(service_name = @p0 AND severity IN UNNEST(@p1))",
+			map[string]any{"p0": "api", "p1": []string{"ERROR", "CRITICAL", "ALERT", "EMERGENCY"}}},
+		{`trace:"projects/p/traces/abc"`, "CONTAINS_SUBSTR(trace_id, @p0)", map[string]any{"p0": "abc"}},
+		{`"connection reset"`,
+			"(CONTAINS_SUBSTR(text_payload, @p0) OR CONTAINS_SUBSTR(TO_JSON_STRING(json_payload), @p0))",
+			map[string]any{"p0": "connection reset"}},
+		{"jsonPayload.user.id=42", `JSON_VALUE(json_payload, '$."user"."id"') = @p0`, map[string]any{"p0": "42"}},
+		{"jsonPayload.took_ms>100", `SAFE_CAST(JSON_VALUE(json_payload, '$."took_ms"') AS FLOAT64) > @p0`, map[string]any{"p0": 100.0}},
+		{"protoPayload.methodName:Services", `CONTAINS_SUBSTR(JSON_VALUE(proto_payload, '$."methodName"'), @p0)`, map[string]any{"p0": "Services"}},
+		{`error_group_id:*`, `NULLIF(error_group_id, '') IS NOT NULL`, map[string]any{}},
+		{`labels."k8s-pod/app":*`, `JSON_QUERY(labels, '$."k8s-pod/app"') IS NOT NULL`, map[string]any{}},
+		{"httpRequest.status>=500 -path:health",
+			"(status >= @p0 AND NOT CONTAINS_SUBSTR(request_path, @p1))",
+			map[string]any{"p0": int64(500), "p1": "health"}},
+		{"logName!=x", "(log_name IS NULL OR log_name != @p0)", map[string]any{"p0": "x"}},
+		{`text=~"time(out|d)"`, "REGEXP_CONTAINS(text_payload, @p0)", map[string]any{"p0": "time(out|d)"}},
+		{"since:1h error", "(CONTAINS_SUBSTR(text_payload, @p0) OR CONTAINS_SUBSTR(TO_JSON_STRING(json_payload), @p0))", map[string]any{"p0": "error"}},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.query, now, 24*time.Hour)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.query, err)
+			continue
+		}
+		if q.Where != tt.where {
+			t.Errorf("Parse(%q).Where = %s, want %s", tt.query, q.Where, tt.where)
+		}
+		if !reflect.DeepEqual(q.Params, tt.params) {
+			t.Errorf("Parse(%q).Params = %v, want %v", tt.query, q.Params, tt.params)
+		}
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	now := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query      string
+		start, end time.Time
+	}{
+		{"", now.Add(-24 * time.Hour), now},
+		{"since:90m", now.Add(-90 * time.Minute), now},
+		{"since:7d", now.AddDate(0, 0, -7), now},
+		{`after:2024-05-01 before:"2024-05-01T06:00:00Z"`, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 1, 6, 0, 0, 0, time.UTC)},
+		{`timestamp>="2024-04-30T00:00:00Z" service:api`, time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC), now},
+		{`timestamp>"2024-05-01T00:00:00Z" timestamp<"2024-05-01T06:00:00Z"`, time.Date(2024, 5, 1, 0, 0, 0, 1000, time.UTC), time.Date(2024, 5, 1, 5, 59, 59, 999999000, time.UTC)},
+		{`timestamp>"2024-05-01T00:00:00.0000005Z"`, time.Date(2024, 5, 1, 0, 0, 0, 1000, time.UTC), now},
+		{`timestamp<="2024-05-02T06:00:00Z"`, now.Add(-24 * time.Hour), time.Date(2024, 5, 2, 6, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.query, now, 24*time.Hour)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.query, err)
+			continue
+		}
+		if !q.Start.Equal(tt.start) || !q.End.Equal(tt.end) {
+			t.Errorf("Parse(%q) range = %v to %v, want %v to %v", tt.query, q.Start, q.End, tt.start, tt.end)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	now := time.Now()
+	tests := map[string]string{
+		"nosuchfield=1":                      "unknown field",
+		"severity>=LOUD":                     "unknown level",
+		"status=abc":                         "invalid value",
+		"since:yesterday":                    "invalid time",
+		"after:2024-05-02 before:2024-05-01": "ends",
+		"error OR since:1h":                  "top level",
+		`jsonPayload."it's"=1`:               "unsupported key",
+		"status=~5..":                        "regular expressions",
+	}
+	for query, want := range tests {
+		_, err := Parse(query, now, time.Hour)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Parse(%q) error = %v, want one containing %q", query, err, want)
+		}
+	}
+}
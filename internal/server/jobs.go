@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+)
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// Job is an ad-hoc export triggered through POST /export.
+type Job struct {
+	ID        string    `json:"id"`
+	Service   string    `json:"service"`
+	StartDate string    `json:"start"`
+	EndDate   string    `json:"end"`
+	State     JobState  `json:"state"`
+	Rows      int       `json:"rows"`
+	Error     string    `json:"error,omitempty"`
+	Created   time.Time `json:"created"`
+	Started   time.Time `json:"started,omitzero"`
+	Finished  time.Time `json:"finished,omitzero"`
+}
+
+// Finished jobs are kept for jobTTL, and at most maxFinishedJobs of them,
+// so clients can poll their outcome without the list growing forever.
+const (
+	jobTTL          = time.Hour
+	maxFinishedJobs = 1000
+)
+
+// Jobs tracks ad-hoc export jobs by ID. It is safe for concurrent use.
+type Jobs struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func NewJobs() *Jobs {
+	return &Jobs{jobs: make(map[string]*Job)}
+}
+
+// Start registers a new job and runs export for it in the background.
+func (j *Jobs) Start(ctx context.Context, export ExportFunc, service, startDate, endDate string) (Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	job := &Job{
+		ID:        id,
+		Service:   service,
+		StartDate: startDate,
+		EndDate:   endDate,
+		State:     JobQueued,
+		Created:   time.Now().UTC(),
+	}
+
+	j.mu.Lock()
+	j.evict(job.Created)
+	j.jobs[id] = job
+	snapshot := *job
+	j.mu.Unlock()
+
+	go func() {
+		j.update(id, func(job *Job) {
+			job.State = JobRunning
+			job.Started = time.Now().UTC()
+		})
+
+		rows, err := export(ctx, service, startDate, endDate)
+
+		j.update(id, func(job *Job) {
+			job.Rows = rows
+			job.Finished = time.Now().UTC()
+			job.State = JobSucceeded
+			if err != nil {
+				job.State = JobFailed
+				job.Error = err.Error()
+			}
+		})
+	}()
+
+	return snapshot, nil
+}
+
+// Get returns a copy of the job with the given ID.
+func (j *Jobs) Get(id string) (Job, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job, ok := j.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// evict drops jobs that finished more than jobTTL before now, then the
+// oldest finished jobs beyond maxFinishedJobs. j.mu must be held.
+func (j *Jobs) evict(now time.Time) {
+	var finished []*Job
+	for id, job := range j.jobs {
+		switch {
+		case job.Finished.IsZero():
+		case now.Sub(job.Finished) > jobTTL:
+			delete(j.jobs, id)
+		default:
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(a, b int) bool { return finished[a].Finished.Before(finished[b].Finished) })
+	for _, job := range finished[:len(finished)-maxFinishedJobs] {
+		delete(j.jobs, job.ID)
+	}
+}
+
+func (j *Jobs) update(id string, fn func(*Job)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if job, ok := j.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/phaserunner03/logging/internal/telemetry"
+)
+
+// maxRequestBytes bounds the body of POST /export.
+const maxRequestBytes = 64 << 10
+
+// ExportFunc exports the logs of a single service for the window [startDate, endDate]
+// and returns the number of rows written.
+type ExportFunc func(ctx context.Context, service, startDate, endDate string) (int, error)
+
+// Server is the HTTP control plane of the exporter.
+type Server struct {
+	status   *Status
+	jobs     *Jobs
+	services []string
+	export   ExportFunc
+	ready    atomic.Bool
+
+	// jobCtx is the parent context of ad-hoc export jobs. It outlives
+	// individual requests so jobs keep running after POST /export returns.
+	jobCtx context.Context
+}
+
+// New returns a control plane that runs export for the configured services.
+func New(status *Status, services []string, export ExportFunc) *Server {
+	return &Server{
+		status:   status,
+		jobs:     NewJobs(),
+		services: services,
+		export:   export,
+		jobCtx:   context.Background(),
+	}
+}
+
+// SetReady marks the server as ready (or not) to accept export requests.
+func (s *Server) SetReady(ready bool) {
+	s.ready.Store(ready)
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+	mux.HandleFunc("GET /status", s.handleStatus)
+	mux.Handle("GET /metrics", telemetry.MetricsHandler())
+	mux.HandleFunc("POST /export", s.handleExport)
+	mux.HandleFunc("GET /export/{id}", s.handleJob)
+	return mux
+}
+
+// ListenAndServe serves the control plane on addr until ctx is cancelled.
+// The server reports ready once addr is bound.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	s.jobCtx = ctx
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ln)
+	}()
+	s.SetReady(true)
+
+	select {
+	case err := <-errCh:
+		s.SetReady(false)
+		return err
+	case <-ctx.Done():
+	}
+
+	s.SetReady(false)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down HTTP server: %v", err)
+	}
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !s.ready.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"services": s.status.Snapshot(),
+	})
+}
+
+type exportRequest struct {
+	Service string `json:"service"`
+	Start   string `json:"start"`
+	End     string `json:"end"`
+}
+
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	var req exportRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !slices.Contains(s.services, req.Service) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("service %q is not configured", req.Service))
+		return
+	}
+
+	job, err := s.jobs.Start(s.jobCtx, s.export, req.Service, req.Start, req.End)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to start export job: %v", err))
+		return
+	}
+	w.Header().Set("Location", "/export/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %q not found", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (req exportRequest) validate() error {
+	if req.Service == "" {
+		return fmt.Errorf("service is required")
+	}
+	start, err := time.Parse(time.RFC3339, req.Start)
+	if err != nil {
+		return fmt.Errorf("start must be an RFC 3339 timestamp: %v", err)
+	}
+	end, err := time.Parse(time.RFC3339, req.End)
+	if err != nil {
+		return fmt.Errorf("end must be an RFC 3339 timestamp: %v", err)
+	}
+	if end.Before(start) {
+		return fmt.Errorf("end must not be before start")
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
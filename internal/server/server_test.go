@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, export ExportFunc) (*Server, *httptest.Server) {
+	t.Helper()
+	s := New(NewStatus(), []string{"api", "web"}, export)
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+// do sends a request to ts and decodes the JSON response into v.
+func do(t *testing.T, ts *httptest.Server, method, path, body string, v any) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+	return resp
+}
+
+func TestProbes(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+
+	if resp := do(t, ts, "GET", "/healthz", "", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("healthz status = %d, want 200", resp.StatusCode)
+	}
+	if resp := do(t, ts, "GET", "/readyz", "", nil); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("readyz before SetReady status = %d, want 503", resp.StatusCode)
+	}
+	s.SetReady(true)
+	if resp := do(t, ts, "GET", "/readyz", "", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("readyz status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	s, ts := newTestServer(t, nil)
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.status.Record("web", Run{Start: t0, End: t0, Err: errors.New("boom")})
+	s.status.Record("api", Run{Start: t0, End: t0, Rows: 3, Newest: t0})
+	s.status.Record("api", Run{Start: t0, End: t0, Rows: 2, Newest: t0.Add(-time.Hour)})
+
+	var body struct {
+		Services []map[string]any `json:"services"`
+	}
+	do(t, ts, "GET", "/status", "", &body)
+	if len(body.Services) != 2 || body.Services[0]["service"] != "api" {
+		t.Fatalf("services = %v, want api and web", body.Services)
+	}
+	api, web := body.Services[0], body.Services[1]
+	if api["total_rows"] != float64(5) || api["runs"] != float64(2) || api["checkpoint"] != "2024-05-01T12:00:00Z" {
+		t.Errorf("api status = %v", api)
+	}
+	if _, ok := web["checkpoint"]; ok {
+		t.Errorf("web status has a checkpoint without exported rows: %v", web)
+	}
+	if web["errors"] != float64(1) || web["last_error"] != "boom" {
+		t.Errorf("web status = %v", web)
+	}
+}
+
+func TestExportValidation(t *testing.T) {
+	_, ts := newTestServer(t, func(ctx context.Context, service, startDate, endDate string) (int, error) {
+		t.Errorf("export ran for an invalid request")
+		return 0, nil
+	})
+	tests := []struct {
+		name, body string
+	}{
+		{"not json", `{`},
+		{"no service", `{"start": "2024-05-01T00:00:00Z", "end": "2024-05-01T01:00:00Z"}`},
+		{"bad start", `{"service": "api", "start": "2024-05-01", "end": "2024-05-01T01:00:00Z"}`},
+		{"end before start", `{"service": "api", "start": "2024-05-01T01:00:00Z", "end": "2024-05-01T00:00:00Z"}`},
+		{"unknown service", `{"service": "billing", "start": "2024-05-01T00:00:00Z", "end": "2024-05-01T01:00:00Z"}`},
+		{"too large", `{"service": "api", "pad": "` + strings.Repeat("x", maxRequestBytes) + `"}`},
+	}
+	for _, tt := range tests {
+		var body map[string]string
+		resp := do(t, ts, "POST", "/export", tt.body, &body)
+		if resp.StatusCode != http.StatusBadRequest || body["error"] == "" {
+			t.Errorf("%s: status = %d, body = %v, want 400 with an error", tt.name, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestExportJob(t *testing.T) {
+	release := make(chan struct{})
+	_, ts := newTestServer(t, func(ctx context.Context, service, startDate, endDate string) (int, error) {
+		<-release
+		if service == "web" {
+			return 1, fmt.Errorf("partial failure")
+		}
+		return 7, nil
+	})
+
+	var job Job
+	resp := do(t, ts, "POST", "/export", `{"service": "api", "start": "2024-05-01T00:00:00Z", "end": "2024-05-01T01:00:00Z"}`, &job)
+	if resp.StatusCode != http.StatusAccepted || resp.Header.Get("Location") != "/export/"+job.ID {
+		t.Fatalf("status = %d, location = %q, want 202 and /export/%s", resp.StatusCode, resp.Header.Get("Location"), job.ID)
+	}
+	if job.State != JobQueued && job.State != JobRunning {
+		t.Errorf("new job state = %s", job.State)
+	}
+
+	var failed Job
+	do(t, ts, "POST", "/export", `{"service": "web", "start": "2024-05-01T00:00:00Z", "end": "2024-05-01T01:00:00Z"}`, &failed)
+	close(release)
+
+	job = waitJob(t, ts, job.ID)
+	if job.State != JobSucceeded || job.Rows != 7 || job.Error != "" || job.Started.IsZero() {
+		t.Errorf("job = %+v, want succeeded with 7 rows", job)
+	}
+	failed = waitJob(t, ts, failed.ID)
+	if failed.State != JobFailed || failed.Rows != 1 || failed.Error != "partial failure" {
+		t.Errorf("job = %+v, want failed with 1 row", failed)
+	}
+
+	if resp := do(t, ts, "GET", "/export/unknown", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown job status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestJobOmitsUnsetTimes(t *testing.T) {
+	b, err := json.Marshal(Job{ID: "a", State: JobQueued, Created: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); strings.Contains(s, "started") || strings.Contains(s, "finished") {
+		t.Errorf("queued job JSON = %s, want no started or finished", s)
+	}
+}
+
+// waitJob polls a job until it finishes.
+func waitJob(t *testing.T, ts *httptest.Server, id string) Job {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var job Job
+		do(t, ts, "GET", "/export/"+id, "", &job)
+		if job.State == JobSucceeded || job.State == JobFailed {
+			return job
+		}
+	}
+	t.Fatalf("job %s did not finish", id)
+	return Job{}
+}
+
+func TestJobsEviction(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	j := NewJobs()
+	j.jobs["running"] = &Job{ID: "running", State: JobRunning}
+	j.jobs["expired"] = &Job{ID: "expired", State: JobSucceeded, Finished: now.Add(-jobTTL - time.Second)}
+	for i := range maxFinishedJobs + 1 {
+		id := fmt.Sprint("done-", i)
+		j.jobs[id] = &Job{ID: id, State: JobSucceeded, Finished: now.Add(-time.Duration(i) * time.Second)}
+	}
+
+	j.evict(now)
+	for _, id := range []string{"expired", fmt.Sprint("done-", maxFinishedJobs)} {
+		if _, ok := j.Get(id); ok {
+			t.Errorf("job %s was not evicted", id)
+		}
+	}
+	for _, id := range []string{"running", "done-0", fmt.Sprint("done-", maxFinishedJobs-1)} {
+		if _, ok := j.Get(id); !ok {
+			t.Errorf("job %s was evicted", id)
+		}
+	}
+	if len(j.jobs) != maxFinishedJobs+1 {
+		t.Errorf("%d jobs left, want %d", len(j.jobs), maxFinishedJobs+1)
+	}
+}
+
+func TestListenAndServe(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	s := New(NewStatus(), nil, nil)
+	if err := s.ListenAndServe(context.Background(), taken.Addr().String()); err == nil {
+		t.Fatal("ListenAndServe on a bound address succeeded")
+	}
+	if s.ready.Load() {
+		t.Error("server is ready after failing to bind")
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ServiceStatus describes the most recent export run for a single service.
+type ServiceStatus struct {
+	Service         string    `json:"service"`
+	LastRunStart    time.Time `json:"last_run_start"`
+	LastRunEnd      time.Time `json:"last_run_end"`
+	WindowStart     string    `json:"window_start"`
+	WindowEnd       string    `json:"window_end"`
+	RowsExported    int       `json:"rows_exported"`
+	RollupsExported int       `json:"rollups_exported"`
+	RowsDropped     int       `json:"rows_dropped"`
+	TotalRows       int       `json:"total_rows"`
+	Runs            int       `json:"runs"`
+	Errors          int       `json:"errors"`
+	LastError       string    `json:"last_error,omitempty"`
+	Checkpoint      time.Time `json:"checkpoint,omitzero"` // newest exported log timestamp
+}
+
+// Run is the outcome of one export run for a service.
+type Run struct {
+	Start       time.Time
+	End         time.Time
+	WindowStart string
+	WindowEnd   string
+	Rows        int
+	Rollups     int
+	Dropped     int
+	Newest      time.Time
+	Err         error
+}
+
+// Status keeps track of export runs per service. It is safe for concurrent use.
+type Status struct {
+	mu       sync.Mutex
+	services map[string]*ServiceStatus
+}
+
+func NewStatus() *Status {
+	return &Status{services: make(map[string]*ServiceStatus)}
+}
+
+// Record stores the result of an export run for service.
+func (s *Status) Record(service string, run Run) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.services[service]
+	if !ok {
+		st = &ServiceStatus{Service: service}
+		s.services[service] = st
+	}
+	st.LastRunStart = run.Start
+	st.LastRunEnd = run.End
+	st.WindowStart = run.WindowStart
+	st.WindowEnd = run.WindowEnd
+	st.RowsExported = run.Rows
+	st.RollupsExported = run.Rollups
+	st.RowsDropped = run.Dropped
+	st.TotalRows += run.Rows
+	st.Runs++
+	st.LastError = ""
+	if run.Err != nil {
+		st.Errors++
+		st.LastError = run.Err.Error()
+	}
+	if run.Newest.After(st.Checkpoint) {
+		st.Checkpoint = run.Newest
+	}
+}
+
+// Snapshot returns a copy of the status of every known service, sorted by name.
+func (s *Status) Snapshot() []ServiceStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]ServiceStatus, 0, len(s.services))
+	for _, st := range s.services {
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Service < out[j].Service })
+	return out
+}
@@ -0,0 +1,89 @@
+package telemetry
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Pipeline stages used as the "stage" label.
+const (
+	StageFetch   = "fetch"
+	StageConvert = "convert"
+	StageSample  = "sample"
+	StageEnrich  = "enrich"
+	StageInsert  = "insert"
+)
+
+const namespace = "log_exporter"
+
+var (
+	EntriesFetched = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "entries_fetched_total",
+		Help:      "Log entries read from Cloud Logging.",
+	}, []string{"service", "stage"})
+
+	RowsConverted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rows_converted_total",
+		Help:      "Log entries successfully converted to BigQuery rows.",
+	}, []string{"service", "stage"})
+
+	ConversionErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "conversion_errors_total",
+		Help:      "Log entries that failed to convert to BigQuery rows.",
+	}, []string{"service", "stage"})
+
+	RowsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rows_dropped_total",
+		Help:      "Rows dropped before insertion, by reason (sampled, daily_cap).",
+	}, []string{"service", "stage", "reason"})
+
+	RowsInserted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rows_inserted_total",
+		Help:      "Rows accepted by BigQuery.",
+	}, []string{"service", "stage"})
+
+	RowsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rows_rejected_total",
+		Help:      "Rows rejected by BigQuery.",
+	}, []string{"service", "stage"})
+
+	APILatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_latency_seconds",
+		Help:      "Latency of Cloud Logging and BigQuery API calls.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"service", "stage"})
+
+	APIRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_retries_total",
+		Help:      "Retried Cloud Logging and BigQuery API calls.",
+	}, []string{"service", "stage"})
+
+	StageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "stage_duration_seconds",
+		Help:      "Wall-clock time spent in each pipeline stage per run.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 15),
+	}, []string{"service", "stage"})
+
+	AlertsFired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "alerts_fired_total",
+		Help:      "Alerts delivered to notifiers, after cooldown.",
+	}, []string{"rule", "service"})
+)
+
+// MetricsHandler serves the registered metrics in the Prometheus exposition format.
+func MetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
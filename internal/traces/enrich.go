@@ -0,0 +1,121 @@
+// Package traces adds span details from Cloud Trace to exported rows.
+package traces
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/auth"
+	"github.com/phaserunner03/logging/internal/bigquery"
+	"github.com/phaserunner03/logging/internal/logger"
+	"github.com/phaserunner03/logging/internal/logs"
+	"github.com/phaserunner03/logging/internal/telemetry"
+	cloudtrace "google.golang.org/api/cloudtrace/v1"
+)
+
+// span is what enrichment records about a Cloud Trace span.
+type span struct {
+	name       string
+	durationMS float64
+}
+
+// Enrich sets SpanName and SpanDurationMS on every sampled row of service
+// whose span is found in Cloud Trace and returns the number of rows enriched.
+// At most trace.max_traces traces are fetched. Traces that cannot be fetched
+// are logged and skipped; only failing to create the client is an error.
+func Enrich(ctx context.Context, config *configs.Config, service string, rows []bigquery.BQLogRow) (int, error) {
+	ctx, sp := telemetry.Tracer().Start(ctx, "Enrich")
+	defer sp.End()
+	start := time.Now()
+	defer func() {
+		telemetry.StageDuration.WithLabelValues(service, telemetry.StageEnrich).Observe(time.Since(start).Seconds())
+	}()
+
+	opts, err := auth.ClientOptions(ctx, config.ReadIdentity(), auth.TraceReadScope)
+	if err != nil {
+		return 0, err
+	}
+	svc, err := cloudtrace.NewService(ctx, opts...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create Cloud Trace client: %v", err)
+	}
+
+	type traceKey struct{ project, id string }
+	fetched := make(map[traceKey]map[string]span)
+	var enriched int
+	for i := range rows {
+		row := &rows[i]
+		if !row.TraceSampled || row.TraceID == "" || row.SpanID == "" {
+			continue
+		}
+
+		project := logs.TraceProject(row.Trace)
+		if project == "" {
+			project = row.SourceProject
+		}
+		if project == "" {
+			project = config.GCP.ProjectID
+		}
+		k := traceKey{project: project, id: row.TraceID}
+		spans, ok := fetched[k]
+		if !ok {
+			if config.Trace.MaxTraces > 0 && len(fetched) >= config.Trace.MaxTraces {
+				continue
+			}
+			spans = fetchSpans(ctx, svc, service, project, row.TraceID)
+			fetched[k] = spans
+		}
+
+		if s, ok := spans[row.SpanID]; ok {
+			row.SpanName = s.name
+			row.SpanDurationMS = bq.NullFloat64{Float64: s.durationMS, Valid: true}
+			enriched++
+		}
+	}
+
+	slog.DebugContext(ctx, "Enriched rows from Cloud Trace",
+		logger.KeyService, service,
+		logger.KeyStage, telemetry.StageEnrich,
+		"traces", len(fetched),
+		"rows", enriched,
+	)
+	return enriched, nil
+}
+
+// fetchSpans returns the spans of a trace keyed by span ID. Log entries carry
+// span IDs as 16 hex digits while the v1 API uses decimal, so every span is
+// indexed under both forms. A trace that cannot be fetched yields no spans.
+func fetchSpans(ctx context.Context, svc *cloudtrace.Service, service, project, traceID string) map[string]span {
+	start := time.Now()
+	trace, err := svc.Projects.Traces.Get(project, traceID).Context(ctx).Do()
+	telemetry.APILatency.WithLabelValues(service, telemetry.StageEnrich).Observe(time.Since(start).Seconds())
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to fetch trace",
+			logger.KeyService, service,
+			logger.KeyStage, telemetry.StageEnrich,
+			"project", project,
+			"trace_id", traceID,
+			"error", err,
+		)
+		return nil
+	}
+
+	spans := make(map[string]span, 2*len(trace.Spans))
+	for _, s := range trace.Spans {
+		var duration float64
+		st, err1 := time.Parse(time.RFC3339Nano, s.StartTime)
+		et, err2 := time.Parse(time.RFC3339Nano, s.EndTime)
+		if err1 == nil && err2 == nil {
+			duration = float64(et.Sub(st)) / float64(time.Millisecond)
+		}
+		info := span{name: s.Name, durationMS: duration}
+		spans[strconv.FormatUint(s.SpanId, 10)] = info
+		spans[fmt.Sprintf("%016x", s.SpanId)] = info
+	}
+	return spans
+}
@@ -3,69 +3,157 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	bq "cloud.google.com/go/bigquery"
 	"github.com/phaserunner03/logging/configs"
 	"github.com/phaserunner03/logging/internal/bigquery"
-	"github.com/phaserunner03/logging/internal/logs"
+	"github.com/phaserunner03/logging/internal/logger"
+	"github.com/phaserunner03/logging/internal/routing"
+	"github.com/phaserunner03/logging/internal/telemetry"
 )
 
+func main() {
+	ctx := context.Background()
+
+	// Log with defaults until a command has loaded its configuration.
+	if err := logger.Setup("info", ""); err != nil {
+		fatal("Failed to configure logging", err)
+	}
 
-func processLogs(ctx context.Context, services []string, startDate, endDate string) error {
-	// Fetch logs from Cloud Logging
-	entries, err := logs.FetchLogs(ctx, services, startDate, endDate)
+	shutdownTracing, err := telemetry.SetupTracing(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to fetch logs: %v", err)
+		fatal("Failed to set up tracing", err)
 	}
 
-	if len(entries) == 0 {
-		log.Println("No log entries to process")
-		return nil
+	err = run(ctx, os.Args[1:])
+	// Flush pending spans before exiting, including on failure.
+	if shutdownErr := shutdownTracing(context.Background()); shutdownErr != nil {
+		slog.Warn("Failed to flush traces", "error", shutdownErr)
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal("Exporter failed", err)
 	}
+}
 
-	// Pre-allocate slice with exact capacity needed
-	bqRows := make([]bigquery.BQLogRow, 0, len(entries))
-	var conversionErrors int
+// fatal logs err at CRITICAL severity and exits.
+func fatal(msg string, err error) {
+	slog.Log(context.Background(), logger.LevelCritical, msg, "error", err)
+	os.Exit(1)
+}
 
-	// Convert log entries to BigQuery rows in batch
-	for _, entry := range entries {
-		row, err := logs.ConvertToBQRow(entry)
-		if err != nil {
-			log.Printf("Warning: Failed to convert log entry: %v", err)
-			conversionErrors++
-			continue
-		}
-		row.ServiceName = entry.GetResource().GetLabels()["service_name"] // Add service name to row
-		bqRows = append(bqRows, row)
+func run(ctx context.Context, args []string) error {
+	cmd := "export"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
 	}
 
-	if len(bqRows) == 0 {
-		return fmt.Errorf("all %d log entries failed to convert", len(entries))
+	switch cmd {
+	case "export":
+		return runExport(ctx, args)
+	case "serve":
+		return runServe(ctx, args)
+	case "migrate":
+		return runMigrate(ctx, args)
+	case "trace":
+		return runTrace(ctx, args)
+	case "errors":
+		return runErrors(ctx, args)
+	case "backfill":
+		return runBackfill(ctx, args)
+	case "verify":
+		return runVerify(ctx, args)
+	case "dump":
+		return runDump(ctx, args)
+	case "search":
+		return runSearch(ctx, args)
+	case "retention":
+		return runRetention(ctx, args)
 	}
+	return fmt.Errorf("unknown command %q", cmd)
+}
 
-	// Insert all rows into BigQuery in a single batch
-	if err := bigquery.InsertLogs(ctx, bqRows); err != nil {
-		return fmt.Errorf("failed to insert logs into BigQuery: %v", err)
+// loadConfig loads the configuration selected by a command's flags and
+// reconfigures logging accordingly.
+func loadConfig(flags *configs.Flags) (*configs.Config, error) {
+	config, err := configs.Load(flags)
+	if err != nil {
+		return nil, err
 	}
+	if err := logger.Setup(config.Log.Level, config.GCP.ProjectID); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
 
-	log.Printf("Successfully processed %d log entries (%d conversion errors)", len(bqRows), conversionErrors)
-	return nil
+// exportedTables opens a BigQuery client and lists the tables the exporter
+// writes to under config. The caller closes the client.
+func exportedTables(ctx context.Context, config *configs.Config) (*bq.Client, []bigquery.Destination, error) {
+	router, err := routing.New(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := bigquery.NewClient(ctx, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	tables, err := bigquery.ExportTables(ctx, client, config, router.Destinations())
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	if len(tables) == 0 {
+		client.Close()
+		return nil, nil, errors.New("no exported tables found")
+	}
+	return client, tables, nil
 }
 
-func main() {
-	ctx := context.Background()
-	config, err := configs.LoadConfig()
+func runExport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	configFlags := configs.RegisterFlags(fs)
+	dry := fs.Bool("dry-run", false, "print filters, sample rows and estimates without writing to BigQuery")
+	sampleSize := fs.Int("sample-size", 100, "entries sampled per service in dry-run mode")
+	showRows := fs.Int("show-rows", 3, "converted rows printed per service in dry-run mode")
+	replace := fs.Bool("replace", false, "replace the rows already exported for the window instead of appending")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(configFlags)
+	if err != nil {
+		return err
+	}
+	startDate, endDate, err := config.Window()
 	if err != nil {
-		log.Fatalf("Error loading configuration: %v", err)
+		return err
 	}
+	services := config.Services.Name
 
-	fmt.Println(config.Services.Name)
+	if *dry {
+		return dryRun(ctx, os.Stdout, config, services, startDate, endDate, dryRunOptions{
+			SampleSize: *sampleSize,
+			ShowRows:   *showRows,
+		})
+	}
 
-	services := config.Services.Name    // Replace with actual service names
-	startDate := "2025-06-01T00:00:00Z" // Example start date
-	endDate := "2025-06-05T23:59:59Z"   // Example end date
+	exp, err := newExporter(config)
+	if err != nil {
+		return err
+	}
+	exp.replace = *replace
 
-	if err := processLogs(ctx, services, startDate, endDate); err != nil {
-		log.Fatalf("Error processing logs: %v", err)
+	slog.InfoContext(ctx, "Exporting logs", "services", services, logger.Window(startDate, endDate), "replace", *replace)
+	if err := exp.processLogs(ctx, services, startDate, endDate); err != nil {
+		return fmt.Errorf("failed to process logs: %v", err)
 	}
+	return nil
 }
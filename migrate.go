@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/bigquery"
+	"github.com/phaserunner03/logging/internal/routing"
+)
+
+// runMigrate adds columns introduced by newer schema files to existing tables.
+func runMigrate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	configFlags := configs.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(configFlags)
+	if err != nil {
+		return err
+	}
+	return migrate(ctx, os.Stdout, config)
+}
+
+// migrate brings every table the exporter writes to up to date with the
+// configured schemas and reports the added columns to w.
+func migrate(ctx context.Context, w io.Writer, config *configs.Config) error {
+	schema, err := bigquery.LoadSchema(config.BigQuery.Schema)
+	if err != nil {
+		return err
+	}
+	router, err := routing.New(config)
+	if err != nil {
+		return err
+	}
+	client, err := bigquery.NewClient(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	tables, err := bigquery.ExportTables(ctx, client, config, router.Destinations())
+	if err != nil {
+		return err
+	}
+
+	for _, dest := range tables {
+		if err := migrateTable(ctx, w, client, dest, schema); err != nil {
+			return err
+		}
+	}
+
+	if config.Rollup.Enabled {
+		rollupSchema, err := bigquery.LoadSchema(config.Rollup.Schema)
+		if err != nil {
+			return err
+		}
+		if err := migrateTable(ctx, w, client, bigquery.RollupDestination(config), rollupSchema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateTable(ctx context.Context, w io.Writer, client *bq.Client, dest bigquery.Destination, schema bq.Schema) error {
+	added, err := bigquery.MigrateSchema(ctx, client, dest, schema)
+	if err != nil {
+		return err
+	}
+	if len(added) == 0 {
+		fmt.Fprintf(w, "%s: up to date\n", dest)
+		return nil
+	}
+	fmt.Fprintf(w, "%s: added %v\n", dest, added)
+	return nil
+}
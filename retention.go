@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/bigquery"
+)
+
+// runRetention deletes exported rows older than the retention of their
+// service and sets the expiration of the exported tables to match.
+func runRetention(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("retention", flag.ContinueOnError)
+	configFlags := configs.RegisterFlags(fs)
+	dry := fs.Bool("dry-run", false, "report expired rows and expiration changes without making them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(configFlags)
+	if err != nil {
+		return err
+	}
+	return applyRetention(ctx, os.Stdout, config, time.Now(), *dry)
+}
+
+// applyRetention deletes the expired rows of every exported table, one table
+// partition (UTC day) at a time, and reports them to w. When every service
+// has a retention, tables are also set to expire after the longest one, so
+// BigQuery drops old partitions or shards by itself between runs.
+func applyRetention(ctx context.Context, w io.Writer, config *configs.Config, now time.Time, dry bool) error {
+	r := bigquery.NewRetention(config, now)
+	if r.Empty() {
+		return errors.New("no retention configured: set retention.days or retention.services")
+	}
+
+	client, tables, err := exportedTables(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	days, err := bigquery.ExpiredRows(ctx, client, tables, r)
+	if err != nil {
+		return err
+	}
+
+	verb := "DELETED"
+	if dry {
+		verb = "EXPIRED"
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "TABLE\tDAY\tSERVICE\t%s\tSTATUS\n", verb)
+	var errs []error
+	var deleted, partitions int64
+	for _, day := range days {
+		status := "ok"
+		if dry {
+			status = "dry run"
+		} else if err := bigquery.DeleteExpired(ctx, client, day, r); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %v", day.Table, day.Day.Format(time.DateOnly), err))
+			status = "failed"
+		}
+
+		services := make([]string, 0, len(day.Rows))
+		for service := range day.Rows {
+			services = append(services, service)
+		}
+		sort.Strings(services)
+		for _, service := range services {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", day.Table, day.Day.Format(time.DateOnly), service, day.Rows[service], status)
+			if status != "failed" {
+				deleted += day.Rows[service]
+			}
+		}
+		if status != "failed" {
+			partitions++
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if dry {
+		fmt.Fprintf(w, "\n%d expired rows in %d partitions (dry run, nothing deleted)\n", deleted, partitions)
+	} else {
+		fmt.Fprintf(w, "\nDeleted %d rows from %d partitions\n", deleted, partitions)
+	}
+
+	if r.Days == 0 {
+		fmt.Fprintln(w, "Table expiration unchanged: some services keep their rows forever")
+	} else {
+		for _, table := range tables {
+			change, err := bigquery.SetExpiration(ctx, client, config, table, r.Days, dry)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if change != "" {
+				fmt.Fprintf(w, "%s: %s\n", table, change)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
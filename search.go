@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/bigquery"
+	"github.com/phaserunner03/logging/internal/search"
+	"google.golang.org/api/iterator"
+)
+
+// runSearch searches the exported rows with a query such as
+// `service:api severity>=ERROR "timeout" since:2h`.
+func runSearch(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	configFlags := configs.RegisterFlags(fs)
+	format := fs.String("format", "table", "output format: table, json or csv")
+	limit := fs.Int("limit", 100, "maximum number of rows to print")
+	since := fs.Duration("since", 24*time.Hour, "time range searched when the query sets no start")
+	dry := fs.Bool("dry-run", false, "print the SQL and the bytes it would scan without running it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch *format {
+	case "table", "json", "csv":
+	default:
+		return fmt.Errorf("unknown format %q: use table, json or csv", *format)
+	}
+	if *limit <= 0 {
+		return fmt.Errorf("limit must be positive")
+	}
+
+	q, err := search.Parse(strings.Join(fs.Args(), " "), time.Now().UTC(), *since)
+	if err != nil {
+		return fmt.Errorf("invalid query: %v", err)
+	}
+	config, err := loadConfig(configFlags)
+	if err != nil {
+		return err
+	}
+	return searchRows(ctx, os.Stdout, config, q, *format, *limit, *dry)
+}
+
+// searchRow is one search result.
+type searchRow struct {
+	Timestamp   time.Time     `bigquery:"timestamp" json:"timestamp"`
+	ServiceName bq.NullString `bigquery:"service_name" json:"service"`
+	Severity    bq.NullString `bigquery:"severity" json:"severity"`
+	LogName     bq.NullString `bigquery:"log_name" json:"log_name"`
+	TraceID     bq.NullString `bigquery:"trace_id" json:"trace_id"`
+	SpanID      bq.NullString `bigquery:"span_id" json:"span_id"`
+	InsertID    bq.NullString `bigquery:"insert_id" json:"insert_id"`
+	Status      bq.NullInt64  `bigquery:"status" json:"status"`
+	Message     bq.NullString `bigquery:"message" json:"message"`
+}
+
+// searchRows runs q over every routed table, newest rows first, and writes
+// up to limit results to w. The bytes the query scans are estimated with a
+// dry run first and reported on stderr; with dry set, the query is printed
+// instead of run.
+func searchRows(ctx context.Context, w io.Writer, config *configs.Config, q *search.Query, format string, limit int, dry bool) error {
+	client, tables, err := exportedTables(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	selects := make([]string, len(tables))
+	for i, table := range tables {
+		selects[i] = fmt.Sprintf(`SELECT timestamp, service_name, severity, log_name, trace_id, span_id, insert_id, status,
+  COALESCE(NULLIF(text_payload, ''), JSON_VALUE(json_payload, '$.message'), TO_JSON_STRING(json_payload)) AS message
+FROM %s
+WHERE timestamp BETWEEN @start AND @end AND %s`, bigquery.TableRef(table), q.Where)
+	}
+	sql := strings.Join(selects, "\nUNION ALL\n") + "\nORDER BY timestamp DESC\nLIMIT @limit"
+
+	params := map[string]any{"start": q.Start, "end": q.End, "limit": limit}
+	for name, value := range q.Params {
+		params[name] = value
+	}
+
+	bytes, err := bigquery.EstimateBytes(ctx, client, sql, params)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Searching %s to %s across %d tables, scanning %s\n",
+		q.Start.Format(time.RFC3339), q.End.Format(time.RFC3339), len(tables), formatBytes(bytes))
+	if dry {
+		fmt.Fprintln(w, sql)
+		return nil
+	}
+	if maxBytes := config.MaxBytesBilled(); maxBytes > 0 && bytes > maxBytes {
+		return fmt.Errorf("search would scan %s, over budget.max_bytes_billed (%s): narrow the time range", formatBytes(bytes), formatBytes(maxBytes))
+	}
+
+	it, err := bigquery.Query(ctx, client, sql, params, config.MaxBytesBilled())
+	if err != nil {
+		return err
+	}
+	var rows []searchRow
+	for {
+		var row searchRow
+		err := it.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read search results: %v", err)
+		}
+		rows = append(rows, row)
+	}
+
+	switch format {
+	case "json":
+		return writeSearchJSON(w, rows)
+	case "csv":
+		return writeSearchCSV(w, rows)
+	}
+	return writeSearchTable(w, rows)
+}
+
+func writeSearchTable(w io.Writer, rows []searchRow) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tSERVICE\tSEVERITY\tSTATUS\tTRACE\tMESSAGE")
+	for _, row := range rows {
+		status := ""
+		if row.Status.Valid {
+			status = strconv.FormatInt(row.Status.Int64, 10)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			row.Timestamp.UTC().Format(time.RFC3339Nano),
+			row.ServiceName.StringVal,
+			row.Severity.StringVal,
+			status,
+			row.TraceID.StringVal,
+			truncate(row.Message.StringVal, 120),
+		)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n%d rows\n", len(rows))
+	return nil
+}
+
+// writeSearchJSON writes rows as NDJSON, omitting NULL columns.
+func writeSearchJSON(w io.Writer, rows []searchRow) error {
+	enc := json.NewEncoder(w)
+	for _, row := range rows {
+		out := map[string]any{"timestamp": row.Timestamp.UTC().Format(time.RFC3339Nano)}
+		for key, value := range map[string]bq.NullString{
+			"service":   row.ServiceName,
+			"severity":  row.Severity,
+			"log_name":  row.LogName,
+			"trace_id":  row.TraceID,
+			"span_id":   row.SpanID,
+			"insert_id": row.InsertID,
+			"message":   row.Message,
+		} {
+			if value.Valid {
+				out[key] = value.StringVal
+			}
+		}
+		if row.Status.Valid {
+			out["status"] = row.Status.Int64
+		}
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSearchCSV(w io.Writer, rows []searchRow) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"timestamp", "service", "severity", "log_name", "trace_id", "span_id", "insert_id", "status", "message"})
+	for _, row := range rows {
+		status := ""
+		if row.Status.Valid {
+			status = strconv.FormatInt(row.Status.Int64, 10)
+		}
+		cw.Write([]string{
+			row.Timestamp.UTC().Format(time.RFC3339Nano),
+			row.ServiceName.StringVal,
+			row.Severity.StringVal,
+			row.LogName.StringVal,
+			row.TraceID.StringVal,
+			row.SpanID.StringVal,
+			row.InsertID.StringVal,
+			status,
+			row.Message.StringVal,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatBytes formats n with a binary unit, as in 1.5 GiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/budget"
+	"github.com/phaserunner03/logging/internal/server"
+)
+
+// runServe runs the HTTP control plane until the process receives SIGINT or SIGTERM.
+func runServe(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	configFlags := configs.RegisterFlags(fs)
+	addr := fs.String("addr", "", "listen address (default :$PORT or :8080)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(configFlags)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *addr == "" {
+		*addr = ":8080"
+		if port := os.Getenv("PORT"); port != "" {
+			*addr = ":" + port
+		}
+	}
+
+	exp, err := newExporter(config)
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "Control plane listening", "addr", *addr)
+	// Every control plane job is a run with its own insert budget.
+	export := func(ctx context.Context, service, startDate, endDate string) (int, error) {
+		job := *exp
+		job.budget = budget.New(config)
+		return job.exportService(ctx, service, startDate, endDate)
+	}
+	if err := server.New(runStatus, config.Services.Name, export).ListenAndServe(ctx, *addr); err != nil {
+		return fmt.Errorf("failed to run control plane: %v", err)
+	}
+	return nil
+}
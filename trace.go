@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/bigquery"
+	"github.com/phaserunner03/logging/internal/logs"
+	"google.golang.org/api/iterator"
+)
+
+// runTrace prints every exported row of a trace, across services and
+// destination tables, in chronological order.
+func runTrace(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("trace", flag.ContinueOnError)
+	configFlags := configs.RegisterFlags(fs)
+	days := fs.Int("days", 7, "number of days back to search")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: trace [flags] <trace-id>")
+	}
+
+	config, err := loadConfig(configFlags)
+	if err != nil {
+		return err
+	}
+	return traceTimeline(ctx, os.Stdout, config, logs.TraceID(fs.Arg(0)), *days)
+}
+
+// timelineRow is one row of a trace timeline.
+type timelineRow struct {
+	Timestamp   time.Time     `bigquery:"timestamp"`
+	ServiceName bq.NullString `bigquery:"service_name"`
+	Severity    bq.NullString `bigquery:"severity"`
+	LogName     bq.NullString `bigquery:"log_name"`
+	SpanID      bq.NullString `bigquery:"span_id"`
+	SpanName    bq.NullString `bigquery:"span_name"`
+	Message     bq.NullString `bigquery:"message"`
+}
+
+// traceTimeline queries the rows of traceID exported within the last days
+// from every routed table and writes them to w as a table.
+func traceTimeline(ctx context.Context, w io.Writer, config *configs.Config, traceID string, days int) error {
+	client, tables, err := exportedTables(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	selects := make([]string, len(tables))
+	for i, table := range tables {
+		selects[i] = fmt.Sprintf(`SELECT timestamp, service_name, severity, log_name, span_id, span_name,
+  COALESCE(NULLIF(text_payload, ''), JSON_VALUE(json_payload, '$.message'), TO_JSON_STRING(json_payload)) AS message
+FROM %s
+WHERE trace_id = @trace_id AND timestamp >= TIMESTAMP_SUB(CURRENT_TIMESTAMP(), INTERVAL @days DAY)`, bigquery.TableRef(table))
+	}
+	sql := strings.Join(selects, "\nUNION ALL\n") + "\nORDER BY timestamp"
+
+	it, err := bigquery.Query(ctx, client, sql, map[string]any{"trace_id": traceID, "days": days}, config.MaxBytesBilled())
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tOFFSET\tSERVICE\tSEVERITY\tSPAN\tLOG\tMESSAGE")
+	var first time.Time
+	var n int
+	for {
+		var row timelineRow
+		err := it.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read trace rows: %v", err)
+		}
+		if n == 0 {
+			first = row.Timestamp
+		}
+		n++
+
+		span := row.SpanID.StringVal
+		if row.SpanName.StringVal != "" {
+			span += " (" + row.SpanName.StringVal + ")"
+		}
+		fmt.Fprintf(tw, "%s\t+%s\t%s\t%s\t%s\t%s\t%s\n",
+			row.Timestamp.UTC().Format(time.RFC3339Nano),
+			row.Timestamp.Sub(first),
+			row.ServiceName.StringVal,
+			row.Severity.StringVal,
+			span,
+			bigquery.LogID(row.LogName.StringVal),
+			truncate(row.Message.StringVal, 120),
+		)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("no rows found for trace %s in the last %d days", traceID, days)
+	}
+	fmt.Fprintf(w, "\n%d rows across %d tables\n", n, len(tables))
+	return nil
+}
+
+// truncate shortens s to at most n runes on a single line.
+func truncate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/phaserunner03/logging/configs"
+	"github.com/phaserunner03/logging/internal/bigquery"
+	"github.com/phaserunner03/logging/internal/logger"
+	"github.com/phaserunner03/logging/internal/logs"
+	"google.golang.org/api/iterator"
+)
+
+// runVerify compares per-hour entry counts in Cloud Logging with the rows
+// exported to BigQuery and optionally re-exports the hours missing rows.
+func runVerify(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	configFlags := configs.RegisterFlags(fs)
+	fix := fs.Bool("fix", false, "re-export missing rows of mismatched hours and rewrite hours with excess rows")
+	all := fs.Bool("all", false, "list matching hours as well as mismatches")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(configFlags)
+	if err != nil {
+		return err
+	}
+	startDate, endDate, err := config.Window()
+	if err != nil {
+		return err
+	}
+
+	mismatches, err := verifyCounts(ctx, os.Stdout, config, config.Services.Name, startDate, endDate, *all)
+	if err != nil {
+		return err
+	}
+	if len(mismatches) == 0 {
+		return nil
+	}
+	if !*fix {
+		return fmt.Errorf("%d service-hours do not match (rerun with --fix to re-export them)", len(mismatches))
+	}
+	return fixHours(ctx, config, mismatches)
+}
+
+// hourCount is the number of entries of a service within one UTC hour in
+// Cloud Logging and in BigQuery.
+type hourCount struct {
+	Service  string
+	Hour     time.Time
+	Logging  int64
+	BigQuery int64
+}
+
+// bqHourCount is one row of the BigQuery count query.
+type bqHourCount struct {
+	ServiceName bq.NullString `bigquery:"service_name"`
+	Hour        time.Time     `bigquery:"hour"`
+	Count       int64         `bigquery:"count"`
+}
+
+// verifyCounts writes a per-service, per-hour comparison of the window to w
+// and returns the hours whose counts differ.
+func verifyCounts(ctx context.Context, w io.Writer, config *configs.Config, services []string, startDate, endDate string, all bool) ([]hourCount, error) {
+	if config.Rollup.Enabled && !config.Rollup.Raw {
+		return nil, fmt.Errorf("rollup.raw is false, so no raw rows are exported to verify")
+	}
+	start, err := time.Parse(time.RFC3339, startDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start date: %v", err)
+	}
+	end, err := time.Parse(time.RFC3339, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end date: %v", err)
+	}
+
+	exported, err := bigqueryHourCounts(ctx, config, services, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(w, "Verifying %s to %s\n\n", startDate, endDate)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tHOUR\tLOGGING\tBIGQUERY\tDIFF\tSTATUS")
+	var mismatches []hourCount
+	var summaries []string
+	for _, service := range services {
+		fetched, err := logs.CountByHour(ctx, config, service, startDate, endDate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count %s entries: %v", service, err)
+		}
+
+		var hours []time.Time
+		for hour := range fetched {
+			hours = append(hours, hour)
+		}
+		for hour := range exported[service] {
+			if _, ok := fetched[hour]; !ok {
+				hours = append(hours, hour)
+			}
+		}
+		slices.SortFunc(hours, time.Time.Compare)
+
+		var missing, excess int
+		var loggingTotal, bigqueryTotal int64
+		for _, hour := range hours {
+			c := hourCount{Service: service, Hour: hour, Logging: fetched[hour], BigQuery: exported[service][hour]}
+			loggingTotal += c.Logging
+			bigqueryTotal += c.BigQuery
+			status := "ok"
+			switch {
+			case c.BigQuery < c.Logging:
+				status = "missing"
+				missing++
+			case c.BigQuery > c.Logging:
+				status = "excess"
+				excess++
+			}
+			if status != "ok" {
+				mismatches = append(mismatches, c)
+			} else if !all {
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%+d\t%s\n", service, hour.Format(time.RFC3339), c.Logging, c.BigQuery, c.BigQuery-c.Logging, status)
+		}
+
+		summary := fmt.Sprintf("%s: %d entries in Cloud Logging, %d rows in BigQuery, %d hours missing rows, %d hours with excess rows",
+			service, loggingTotal, bigqueryTotal, missing, excess)
+		if p := config.SamplingPolicy(service); len(p.Rates) > 0 || p.DailyCap > 0 {
+			summary += " (sampling drops rows, so missing rows are expected)"
+		}
+		summaries = append(summaries, summary)
+	}
+	if err := tw.Flush(); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(w, "\n%s\n", strings.Join(summaries, "\n"))
+	return mismatches, nil
+}
+
+// bigqueryHourCounts counts the exported rows of services per hour within
+// [start, end], keyed by service and hour.
+func bigqueryHourCounts(ctx context.Context, config *configs.Config, services []string, start, end time.Time) (map[string]map[time.Time]int64, error) {
+	client, tables, err := exportedTables(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	selects := make([]string, len(tables))
+	for i, table := range tables {
+		selects[i] = fmt.Sprintf(`SELECT service_name, timestamp
+FROM %s
+WHERE timestamp BETWEEN @start AND @end AND service_name IN UNNEST(@services)`, bigquery.TableRef(table))
+	}
+	sql := fmt.Sprintf(`SELECT service_name, TIMESTAMP_TRUNC(timestamp, HOUR) AS hour, COUNT(*) AS count
+FROM (
+%s
+)
+GROUP BY service_name, hour`, strings.Join(selects, "\nUNION ALL\n"))
+
+	it, err := bigquery.Query(ctx, client, sql, map[string]any{"start": start, "end": end, "services": services}, config.MaxBytesBilled())
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]map[time.Time]int64)
+	for {
+		var row bqHourCount
+		err := it.Next(&row)
+		if err == iterator.Done {
+			return counts, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read row counts: %v", err)
+		}
+		if counts[row.ServiceName.StringVal] == nil {
+			counts[row.ServiceName.StringVal] = make(map[time.Time]int64)
+		}
+		counts[row.ServiceName.StringVal][row.Hour.UTC()] = row.Count
+	}
+}
+
+// fixHours re-exports the mismatched hours. Hours with rows missing from
+// BigQuery are gap-filled, inserting only the entries whose insert_id is not
+// exported yet; hours with excess rows are rewritten in replace mode.
+func fixHours(ctx context.Context, config *configs.Config, mismatches []hourCount) error {
+	// Re-exporting history must not page anyone.
+	config.Alerts.Rules = nil
+	exp, err := newExporter(config)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, c := range mismatches {
+		start := c.Hour.Format(time.RFC3339Nano)
+		end := c.Hour.Add(time.Hour - time.Nanosecond).Format(time.RFC3339Nano)
+		exp.replace = c.BigQuery > c.Logging
+		exp.fillGaps = !exp.replace
+		rows, err := exp.exportService(ctx, c.Service, start, end)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to re-export hour",
+				logger.KeyService, c.Service,
+				logger.Window(start, end),
+				"error", err,
+			)
+			failed++
+			continue
+		}
+		slog.InfoContext(ctx, "Re-exported hour",
+			logger.KeyService, c.Service,
+			logger.Window(start, end),
+			"logging", c.Logging,
+			"bigquery", c.BigQuery,
+			"replaced", exp.replace,
+			"rows", rows,
+		)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d hours failed to re-export", failed, len(mismatches))
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phaserunner03/logging/internal/fakes"
+	bqv2 "google.golang.org/api/bigquery/v2"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestVerifyFixesMissingAndExcessHours checks that verify finds an hour
+// missing a row and an hour with an extra row, and that fixing them gap-fills
+// the first and rewrites the second.
+func TestVerifyFixesMissingAndExcessHours(t *testing.T) {
+	logging, bq, config := newTestExport(t, "verify")
+	bq.HandleQueries(verifyQueries(bq))
+
+	h1 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	h2 := h1.Add(time.Hour)
+	entry := func(id string, at time.Time) *logpb.LogEntry {
+		return &logpb.LogEntry{
+			LogName:   "projects/test-project/logs/run.googleapis.com%2Frequests",
+			Resource:  &mrpb.MonitoredResource{Type: "cloud_run_revision", Labels: map[string]string{"service_name": "checkout"}},
+			Timestamp: timestamppb.New(at),
+			InsertId:  id,
+		}
+	}
+	logging.Add(entry("a1", h1), entry("a2", h1.Add(time.Minute)), entry("a3", h1.Add(2*time.Minute)), entry("b1", h2.Add(5*time.Minute)))
+
+	row := func(id string, at time.Time) map[string]any {
+		return map[string]any{"insert_id": id, "service_name": "checkout", "timestamp": at.Format(time.RFC3339Nano)}
+	}
+	// a3 was never exported and x1 is a row Cloud Logging does not have.
+	bq.SetRows("test-project", "logs", "verify", []map[string]any{
+		row("a1", h1), row("a2", h1.Add(time.Minute)), row("b1", h2.Add(5*time.Minute)), row("x1", h2.Add(10*time.Minute)),
+	})
+
+	ctx := context.Background()
+	start, end := h1.Format(time.RFC3339), h2.Add(time.Hour-time.Second).Format(time.RFC3339)
+	var out strings.Builder
+	mismatches, err := verifyCounts(ctx, &out, config, config.Services.Name, start, end, false)
+	if err != nil {
+		t.Fatalf("verifyCounts: %v", err)
+	}
+	want := []hourCount{
+		{Service: "checkout", Hour: h1, Logging: 3, BigQuery: 2},
+		{Service: "checkout", Hour: h2, Logging: 1, BigQuery: 2},
+	}
+	if !slices.Equal(mismatches, want) {
+		t.Fatalf("mismatches = %+v, want %+v\n%s", mismatches, want, out.String())
+	}
+	for _, s := range []string{"missing", "excess", "1 hours missing rows, 1 hours with excess rows"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("report does not mention %q:\n%s", s, out.String())
+		}
+	}
+
+	if err := fixHours(ctx, config, mismatches); err != nil {
+		t.Fatalf("fixHours: %v", err)
+	}
+	var ids []string
+	for _, r := range bq.Rows("test-project", "logs", "verify") {
+		ids = append(ids, r["insert_id"].(string))
+	}
+	slices.Sort(ids)
+	if want := []string{"a1", "a2", "a3", "b1"}; !slices.Equal(ids, want) {
+		t.Errorf("rows after fixing = %v, want %v", ids, want)
+	}
+	if tables := bq.Tables(); len(tables) != 1 {
+		t.Errorf("tables after fixing = %v, want the staging table deleted", tables)
+	}
+
+	out.Reset()
+	if mismatches, err = verifyCounts(ctx, &out, config, config.Services.Name, start, end, false); err != nil || len(mismatches) != 0 {
+		t.Errorf("verify after fixing found %+v (err %v)\n%s", mismatches, err, out.String())
+	}
+}
+
+var (
+	deleteFrom = regexp.MustCompile("DELETE FROM `([^`]+)`")
+	insertFrom = regexp.MustCompile("SELECT .* FROM `([^`]+)`;")
+)
+
+// verifyQueries answers the queries verify and its fixes run against the
+// single exported table of the test, the way BigQuery would.
+func verifyQueries(bq *fakes.BigQuery) fakes.QueryFunc {
+	const project, dataset = "test-project", "logs"
+	inWindow := func(row map[string]any, params map[string]any) bool {
+		at := rowTime(row)
+		return !at.Before(params["start"].(time.Time)) && !at.After(params["end"].(time.Time))
+	}
+	field := func(name, typ string) *bqv2.TableFieldSchema {
+		return &bqv2.TableFieldSchema{Name: name, Type: typ}
+	}
+
+	return func(sql string, params map[string]any) (*fakes.QueryResult, error) {
+		rows := bq.Rows(project, dataset, "verify")
+		result := &fakes.QueryResult{Schema: &bqv2.TableSchema{}}
+		switch {
+		case strings.Contains(sql, "TIMESTAMP_TRUNC(timestamp, HOUR)"):
+			result.Schema.Fields = []*bqv2.TableFieldSchema{field("service_name", "STRING"), field("hour", "TIMESTAMP"), field("count", "INTEGER")}
+			counts := make(map[time.Time]int64)
+			for _, row := range rows {
+				if slices.Contains(params["services"].([]any), row["service_name"]) && inWindow(row, params) {
+					counts[rowTime(row).Truncate(time.Hour)]++
+				}
+			}
+			for hour, n := range counts {
+				result.Rows = append(result.Rows, []any{"checkout", hour, n})
+			}
+
+		case strings.HasPrefix(sql, "SELECT DISTINCT insert_id"):
+			result.Schema.Fields = []*bqv2.TableFieldSchema{field("insert_id", "STRING")}
+			for _, row := range rows {
+				if row["service_name"] == params["service"] && inWindow(row, params) {
+					result.Rows = append(result.Rows, []any{row["insert_id"]})
+				}
+			}
+
+		case strings.Contains(sql, "AS table_index"):
+			result.Schema.Fields = []*bqv2.TableFieldSchema{field("table_index", "INTEGER"), field("day", "TIMESTAMP")}
+			days := make(map[time.Time]bool)
+			for _, row := range rows {
+				if row["service_name"] == params["service"] && inWindow(row, params) {
+					days[rowTime(row).Truncate(24*time.Hour)] = true
+				}
+			}
+			for day := range days {
+				result.Rows = append(result.Rows, []any{int64(0), day})
+			}
+
+		case strings.HasPrefix(sql, "BEGIN TRANSACTION"):
+			if err := swapRows(bq, sql, params, project+"."+dataset+".verify", "timestamp"); err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, fmt.Errorf("unexpected query: %s", sql)
+		}
+		return result, nil
+	}
+}
+
+// swapRows answers the transaction that deletes the rows of @service whose
+// column is within [@start, @end] from table and inserts the rows of a
+// staging table.
+func swapRows(bq *fakes.BigQuery, sql string, params map[string]any, table, column string) error {
+	dest, staging := deleteFrom.FindStringSubmatch(sql), insertFrom.FindStringSubmatch(sql)
+	if dest == nil || staging == nil || dest[1] != table {
+		return fmt.Errorf("unexpected transaction: %s", sql)
+	}
+	ref := strings.Split(table, ".")
+	var kept []map[string]any
+	for _, row := range bq.Rows(ref[0], ref[1], ref[2]) {
+		at := parseTime(row[column])
+		if row["service_name"] != params["service"] || at.Before(params["start"].(time.Time)) || at.After(params["end"].(time.Time)) {
+			kept = append(kept, row)
+		}
+	}
+	from := strings.Split(staging[1], ".")
+	bq.SetRows(ref[0], ref[1], ref[2], append(kept, bq.Rows(from[0], from[1], from[2])...))
+	return nil
+}
+
+// rowTime parses the timestamp of a row, as streamed or loaded.
+func rowTime(row map[string]any) time.Time {
+	return parseTime(row["timestamp"])
+}
+
+// parseTime parses a timestamp value, as streamed or loaded.
+func parseTime(v any) time.Time {
+	s, _ := v.(string)
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t
+	}
+	t, _ := time.Parse("2006-01-02 15:04:05.999999-07:00", s)
+	return t.UTC()
+}